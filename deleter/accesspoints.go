@@ -0,0 +1,62 @@
+package deleter
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3control"
+	"strings"
+)
+
+//checkAccessPoints looks for access points attached to the bucket, which make DeleteBucket fail until they are
+//gone. Without -delete-access-points they are only reported, so they can be removed by hand. Access points are
+//an S3 Control resource, looked up in the caller's account, which is where they live unless the bucket is
+//shared across accounts.
+func (j *bucketJob) checkAccessPoints() {
+	identity, err := callerIdentity()
+	if err != nil {
+		if *verbosity {
+			WarningLogger.Printf("Unable to check %s for access points without the account ID: %v\n", j.name, err)
+		}
+		return
+	}
+	sess, err := newSession(j.region)
+	if err != nil {
+		return
+	}
+	j.control = s3control.New(sess)
+	j.accountID = identity.Account
+	err = j.control.ListAccessPointsPagesWithContext(j.ctx, &s3control.ListAccessPointsInput{
+		AccountId: j.accountID,
+		Bucket:    aws.String(j.name),
+	}, func(page *s3control.ListAccessPointsOutput, lastPage bool) bool {
+		for _, point := range page.AccessPointList {
+			j.accessPoints = append(j.accessPoints, aws.StringValue(point.Name))
+		}
+		return true
+	})
+	if err != nil {
+		if *verbosity {
+			WarningLogger.Printf("Unable to list the access points of %s: %v\n", j.name, err)
+		}
+		return
+	}
+	if len(j.accessPoints) > 0 && !*deleteAccessPoints {
+		WarningLogger.Printf("%s has access points %s, it can't be deleted until they are removed, e.g. with -delete-access-points\n",
+			j.name, strings.Join(j.accessPoints, ", "))
+	}
+}
+
+//removeAccessPoints is -delete-access-points, run just before DeleteBucket. A failure is only warned about,
+//DeleteBucket then fails with the reason.
+func (j *bucketJob) removeAccessPoints() {
+	for _, name := range j.accessPoints {
+		_, err := j.control.DeleteAccessPointWithContext(j.ctx, &s3control.DeleteAccessPointInput{
+			AccountId: j.accountID,
+			Name:      aws.String(name),
+		})
+		if err != nil {
+			WarningLogger.Printf("Unable to delete access point %s of %s: %v\n", name, j.name, err)
+			continue
+		}
+		InfoLogger.Printf("Deleted access point %s of %s\n", name, j.name)
+	}
+}
@@ -0,0 +1,234 @@
+package deleter
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Files of a run in its -audit-dir directory
+const (
+	auditSchedule = "scheduled.csv"
+	auditDigest   = "scheduled.sha256"
+	auditLogFile  = "deleted.csv"
+)
+
+//Open audit log of an -audit-dir delete pass, nil otherwise
+var auditLog *auditTrail
+
+//auditRunDir is the directory of the run -audit-dir is working on
+var auditRunDir string
+
+//setupAudit is -audit-dir's two passes over -plan-out and -plan-in. A -dry-run lists the buckets into a new
+//run's schedule, a plan file whose SHA-256 is kept beside it. A run without -dry-run deletes exactly that
+//schedule, given its -run-id, after checking it wasn't changed since, and appends every outcome to the run's
+//audit log. The delete pass can be repeated until nothing is left: each one picks up where the last stopped.
+func setupAudit() {
+	if *planOutPath != "" || *planInPath != "" || *partitionPlan {
+		exitErrorf("-audit-dir writes and reads its own plan, it can't be combined with -plan-out, -plan-in or -partition-plan")
+	}
+	if *dryRun {
+		if *runID == "" {
+			*runID = newRunID()
+		}
+		auditRunDir = filepath.Join(*auditDir, *runID)
+		if _, err := os.Stat(auditRunDir); err == nil {
+			exitErrorf("Run %s already has a schedule in %s", *runID, auditRunDir)
+		}
+		if err := os.MkdirAll(auditRunDir, 0755); err != nil {
+			exitErrorf("Unable to create %s: %v", auditRunDir, err)
+		}
+		*planOutPath = filepath.Join(auditRunDir, auditSchedule)
+		return
+	}
+	if *runID == "" {
+		exitErrorf("-audit-dir deletes the schedule of an earlier -dry-run -audit-dir pass, give its -run-id")
+	}
+	auditRunDir = filepath.Join(*auditDir, *runID)
+	digest, err := checkScheduleDigest()
+	if err != nil {
+		exitErrorf("Unable to use the schedule of run %s: %v", *runID, err)
+	}
+	*planInPath = filepath.Join(auditRunDir, auditSchedule)
+	if auditLog, err = openAuditTrail(filepath.Join(auditRunDir, auditLogFile), digest); err != nil {
+		exitErrorf("Unable to use the audit log of run %s: %v", *runID, err)
+	}
+}
+
+//newRunID makes a run ID that sorts by time, e.g. 20210102T150405Z-1a2b3c4d
+func newRunID() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return time.Now().UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(b)
+}
+
+func fileDigest(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+//finishSchedule records the SHA-256 of a dry run's schedule, in sha256sum's format, once it is written
+func finishSchedule() {
+	digest, err := fileDigest(filepath.Join(auditRunDir, auditSchedule))
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(auditRunDir, auditDigest), []byte(digest+"  "+auditSchedule+"\n"), 0444)
+	}
+	if err != nil {
+		exitErrorf("Unable to record the digest of run %s's schedule: %v", *runID, err)
+	}
+	InfoLogger.Printf("Scheduled run %s in %s, delete it with -audit-dir %s -run-id %s\n", *runID, auditRunDir, *auditDir, *runID)
+}
+
+//checkScheduleDigest returns the schedule's SHA-256 if it still matches the one recorded when it was written
+func checkScheduleDigest() (string, error) {
+	recorded, err := ioutil.ReadFile(filepath.Join(auditRunDir, auditDigest))
+	if err != nil {
+		return "", err
+	}
+	digest, err := fileDigest(filepath.Join(auditRunDir, auditSchedule))
+	if err != nil {
+		return "", err
+	}
+	if fields := strings.Fields(string(recorded)); len(fields) == 0 || fields[0] != digest {
+		return "", fmt.Errorf("%s has changed since it was scheduled, its SHA-256 no longer matches %s", auditSchedule, auditDigest)
+	}
+	return digest, nil
+}
+
+//auditTrail is a run's audit log: a CSV row for every entry deleted, failed or found missing, across all of
+//the run's delete passes. Each row carries a chain hash, the SHA-256 of the row before's chain hash and its own
+//fields, starting from the schedule's digest, so a row changed, dropped or added afterwards breaks the chain
+//from there on, and the log can't be passed off as that of another schedule.
+type auditTrail struct {
+	mu   sync.Mutex
+	file *os.File
+	w    *csv.Writer
+	head string
+	rows int
+	//Entries deleted by earlier passes, by bucket and planKey
+	done map[string]bool
+}
+
+var auditHeader = []string{"run_id", "time", "bucket", "type", "key", "version_id", "outcome", "chain"}
+
+//chainHash is the chain hash of a row following head
+func chainHash(head string, fields []string) string {
+	sum := sha256.Sum256([]byte(head + "\n" + strings.Join(fields, "\x1f")))
+	return hex.EncodeToString(sum[:])
+}
+
+//openAuditTrail opens a run's audit log for appending, checking the chain of the rows earlier passes wrote
+func openAuditTrail(path string, digest string) (*auditTrail, error) {
+	t := &auditTrail{head: digest, done: map[string]bool{}}
+	existing, err := os.Open(path)
+	if err == nil {
+		err = t.replay(existing)
+		existing.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	if t.file, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644); err != nil {
+		return nil, err
+	}
+	t.w = csv.NewWriter(t.file)
+	if info, err := t.file.Stat(); err == nil && info.Size() == 0 {
+		t.w.Write(auditHeader)
+	}
+	if len(t.done) > 0 {
+		InfoLogger.Printf("Resuming run %s, whose earlier passes deleted %d entries\n", *runID, len(t.done))
+	}
+	return t, nil
+}
+
+//replay checks the chain of an existing audit log and picks up its head and what it says was deleted
+func (t *auditTrail) replay(r io.Reader) error {
+	cr := csv.NewReader(bufio.NewReader(r))
+	cr.FieldsPerRecord = len(auditHeader)
+	if _, err := cr.Read(); err != nil {
+		return fmt.Errorf("reading header: %v", err)
+	}
+	for row := 2; ; row++ {
+		fields, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		chain := fields[len(fields)-1]
+		if chainHash(t.head, fields[:len(fields)-1]) != chain {
+			return fmt.Errorf("row %d doesn't match its chain hash, the log was changed after it was written", row)
+		}
+		t.head = chain
+		if fields[6] == "deleted" && fields[3] != planBucketRow {
+			t.done[fields[2]+"\x00"+planKey(aws.String(fields[4]), aws.String(fields[5]))] = true
+		}
+	}
+}
+
+//record appends an outcome to the log, flushed straight away so a pass that dies leaves every row it got to
+func (t *auditTrail) record(bucket string, entryType string, key string, versionId string, outcome string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fields := []string{*runID, time.Now().UTC().Format(time.RFC3339Nano), bucket, entryType, key, versionId, outcome}
+	t.head = chainHash(t.head, fields)
+	t.w.Write(append(fields, t.head))
+	t.w.Flush()
+	t.rows++
+}
+
+//deletedBefore reports whether an earlier pass of the run deleted an entry
+func (t *auditTrail) deletedBefore(bucket string, entry s3Entry) bool {
+	return t.done[bucket+"\x00"+planKey(entry.Key, entry.VersionId)]
+}
+
+func (t *auditTrail) close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.w.Flush()
+	if err := t.w.Error(); err != nil {
+		t.file.Close()
+		return err
+	}
+	InfoLogger.Printf("Audit log of run %s: %d rows from this pass, chain head %s\n", *runID, t.rows, t.head)
+	return t.file.Close()
+}
+
+//auditMissing sorts the planned entries a delete pass didn't find: those an earlier pass of the run deleted are
+//taken out of remaining, and the rest, gone some other way, are logged as missing
+func (j *bucketJob) auditMissing(remaining map[string]s3Entry) {
+	earlier := 0
+	for k, entry := range remaining {
+		if auditLog.deletedBefore(j.name, entry) {
+			delete(remaining, k)
+			earlier++
+			continue
+		}
+		auditLog.record(j.name, entry.Type, aws.StringValue(entry.Key), aws.StringValue(entry.VersionId), "missing")
+	}
+	if earlier > 0 {
+		InfoLogger.Printf("%d planned entries in %s were deleted by an earlier pass of run %s\n", earlier, j.name, *runID)
+	}
+}
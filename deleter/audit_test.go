@@ -0,0 +1,113 @@
+package deleter
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//writeAuditLog writes a log of three rows following digest and returns its lines, header first
+func writeAuditLog(t *testing.T, path, digest string) []string {
+	t.Helper()
+	trail, err := openAuditTrail(path, digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trail.record("demo", "Version", "a/b,c", "v1", "deleted")
+	trail.record("demo", "Marker", "a/b,c", "v2", "failed")
+	trail.record("demo", "Version", "d", "v3", "missing")
+	if err := trail.close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+func TestAuditTrailChain(t *testing.T) {
+	setFlags(t, map[string]string{"run-id": "20210102T150405Z-1a2b3c4d"})
+	const digest = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	tests := []struct {
+		name   string
+		digest string
+		//tamper changes the log's lines, the header being line 0
+		tamper  func(lines []string) []string
+		wantErr string
+	}{
+		{name: "untouched", digest: digest, tamper: func(lines []string) []string { return lines }},
+		{name: "row changed", digest: digest, tamper: func(lines []string) []string {
+			lines[2] = strings.Replace(lines[2], "failed", "deleted", 1)
+			return lines
+		}, wantErr: "row 3"},
+		{name: "row dropped", digest: digest, tamper: func(lines []string) []string {
+			return append(lines[:1], lines[2:]...)
+		}, wantErr: "row 2"},
+		{name: "row added", digest: digest, tamper: func(lines []string) []string {
+			return append(lines, lines[1])
+		}, wantErr: "row 5"},
+		{name: "another schedule", digest: strings.Repeat("0", 64), tamper: func(lines []string) []string { return lines }, wantErr: "row 2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), auditLogFile)
+			lines := writeAuditLog(t, path, digest)
+			if len(lines) != 4 {
+				t.Fatalf("%d lines written, want a header and 3 rows", len(lines))
+			}
+			if err := ioutil.WriteFile(path, []byte(strings.Join(tt.tamper(lines), "\n")+"\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			trail, err := openAuditTrail(path, tt.digest)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("openAuditTrail = %v, want an error about %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer trail.close()
+			if !trail.deletedBefore("demo", s3Entry{Key: aws.String("a/b,c"), VersionId: aws.String("v1")}) {
+				t.Error("the deleted row wasn't picked up")
+			}
+			if trail.deletedBefore("demo", s3Entry{Key: aws.String("a/b,c"), VersionId: aws.String("v2")}) {
+				t.Error("a failed row was taken as deleted")
+			}
+			//The next pass carries on from the head of the last row
+			if last := strings.Split(lines[3], ","); trail.head != last[len(last)-1] {
+				t.Errorf("chain head %s, want the last row's %s", trail.head, last[len(last)-1])
+			}
+		})
+	}
+}
+
+func TestCheckScheduleDigest(t *testing.T) {
+	auditRunDir = t.TempDir()
+	defer func() { auditRunDir = "" }()
+	schedule := filepath.Join(auditRunDir, auditSchedule)
+	if err := ioutil.WriteFile(schedule, []byte("bucket,type,key,version_id\ndemo,Version,k,v1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	digest, err := fileDigest(schedule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(auditRunDir, auditDigest), []byte(digest+"  "+auditSchedule+"\n"), 0444); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := checkScheduleDigest(); err != nil || got != digest {
+		t.Fatalf("checkScheduleDigest = %q, %v, want %q", got, err, digest)
+	}
+	if err := ioutil.WriteFile(schedule, []byte("bucket,type,key,version_id\ndemo,Version,other,v1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := checkScheduleDigest(); err == nil {
+		t.Error("a changed schedule passed the digest check")
+	}
+}
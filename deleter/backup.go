@@ -0,0 +1,135 @@
+package deleter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/cenkalti/backoff/v4"
+	"net/url"
+	"strings"
+	"sync/atomic"
+)
+
+//backupTarget is where -backup-to copies every version and object before it is deleted
+type backupTarget struct {
+	bucket string
+	prefix string
+	svc    *s3.S3
+}
+
+//Set by -backup-to, nil when not backing up
+var backup *backupTarget
+
+//Versions and objects copied by -backup-to across the run, and their bytes, updated atomically
+var (
+	backedUpCount int64
+	backedUpBytes int64
+)
+
+//parseBackupURL splits s3://bucket/prefix into its bucket and key prefix. The prefix is taken as it is, not
+//URL-decoded, since keys can hold %, ? and # like any other character.
+func parseBackupURL(raw string) (string, string, error) {
+	if !strings.HasPrefix(raw, "s3://") {
+		return "", "", errors.New("must look like s3://bucket/prefix")
+	}
+	parts := strings.SplitN(strings.TrimPrefix(raw, "s3://"), "/", 2)
+	if parts[0] == "" {
+		return "", "", errors.New("must look like s3://bucket/prefix")
+	}
+	prefix := ""
+	if len(parts) == 2 {
+		prefix = parts[1]
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return parts[0], prefix, nil
+}
+
+func newBackupTarget(raw string) (*backupTarget, error) {
+	bucket, prefix, err := parseBackupURL(raw)
+	if err != nil {
+		return nil, err
+	}
+	region := getRegion(bucket)
+	if region == "unknown" {
+		return nil, fmt.Errorf("unable to find backup bucket %s", bucket)
+	}
+	sess, err := newSession(region)
+	if err != nil {
+		return nil, err
+	}
+	return &backupTarget{bucket: bucket, prefix: prefix, svc: s3.New(sess)}, nil
+}
+
+//copySource builds CopyObject's x-amz-copy-source for a version, URL-encoded but keeping the slashes
+func copySource(bucket string, key *string, versionId *string) string {
+	source := bucket + "/" + strings.Replace(url.PathEscape(aws.StringValue(key)), "%2F", "/", -1)
+	if versionId != nil && aws.StringValue(versionId) != "null" {
+		source += "?versionId=" + url.QueryEscape(aws.StringValue(versionId))
+	}
+	return source
+}
+
+//backupEntry copies a version or object to -backup-to under the same key, retrying transient failures.
+//Delete markers have no data and are not copied. Single CopyObject requests are limited to 5 GiB,
+//so bigger objects fail here and are kept rather than deleted.
+func (j *bucketJob) backupEntry(ctx context.Context, entry s3Entry) error {
+	if entry.Type == "Marker" {
+		return nil
+	}
+	err := backoff.RetryNotify(func() error {
+		if limiter != nil {
+			if err := limiter.Wait(ctx); err != nil {
+				return backoff.Permanent(err)
+			}
+		}
+		_, err := backup.svc.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
+			Bucket:     aws.String(backup.bucket),
+			Key:        aws.String(backup.prefix + aws.StringValue(entry.Key)),
+			CopySource: aws.String(copySource(j.name, entry.Key, entry.VersionId)),
+		})
+		if err != nil && (isFatal(err) || !isRetryable(err)) {
+			return backoff.Permanent(err)
+		}
+		return err
+	}, backoff.WithContext(newDeleteBackOff(), ctx), countRetry)
+	if err != nil {
+		return err
+	}
+	atomic.AddInt64(&backedUpCount, 1)
+	atomic.AddInt64(&backedUpBytes, entry.Size)
+	if *verbosity {
+		InfoLogger.Printf("Backed up %s: %s\n", aws.StringValue(entry.Key), aws.StringValue(entry.VersionId))
+	}
+	return nil
+}
+
+//backupEntries copies a batch's entries before they are deleted, returning only the ones that made it.
+//Entries that failed to copy are recorded as failed so the bucket isn't deleted with them still in it.
+func (j *bucketJob) backupEntries(ctx context.Context, entries []s3Entry) ([]s3Entry, error) {
+	copied := make([]s3Entry, 0, len(entries))
+	for _, entry := range entries {
+		if err := j.backupEntry(ctx, entry); err != nil {
+			if j.stopIfFatal(err) {
+				return nil, err
+			}
+			if ctx.Err() != nil {
+				return nil, nil
+			}
+			ErrorLogger.Printf("Unable to back up %s: %s, not deleting it: %v\n", aws.StringValue(entry.Key), aws.StringValue(entry.VersionId), err)
+			j.recordFailed(entry)
+			continue
+		}
+		copied = append(copied, entry)
+	}
+	return copied, nil
+}
+
+//reportBackup logs how much -backup-to copied over the run
+func reportBackup() {
+	InfoLogger.Printf("Backed up %d versions and objects (%s) to s3://%s/%s\n",
+		atomic.LoadInt64(&backedUpCount), formatBytes(float64(atomic.LoadInt64(&backedUpBytes))), backup.bucket, backup.prefix)
+}
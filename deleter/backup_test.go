@@ -0,0 +1,54 @@
+package deleter
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"testing"
+)
+
+func TestParseBackupURL(t *testing.T) {
+	tests := []struct {
+		raw            string
+		bucket, prefix string
+		wantErr        bool
+	}{
+		{raw: "s3://backups", bucket: "backups"},
+		{raw: "s3://backups/", bucket: "backups"},
+		{raw: "s3://backups/old", bucket: "backups", prefix: "old/"},
+		{raw: "s3://backups/old/data/", bucket: "backups", prefix: "old/data/"},
+		{raw: "s3://backups/100%/#1?", bucket: "backups", prefix: "100%/#1?/"},
+		{raw: "backups/old", wantErr: true},
+		{raw: "https://backups/old", wantErr: true},
+		{raw: "s3:///old", wantErr: true},
+		{raw: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		bucket, prefix, err := parseBackupURL(tt.raw)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseBackupURL(%q) error = %v, want an error: %v", tt.raw, err, tt.wantErr)
+			continue
+		}
+		if bucket != tt.bucket || prefix != tt.prefix {
+			t.Errorf("parseBackupURL(%q) = %q, %q, want %q, %q", tt.raw, bucket, prefix, tt.bucket, tt.prefix)
+		}
+	}
+}
+
+func TestCopySource(t *testing.T) {
+	tests := []struct {
+		key       string
+		versionId *string
+		want      string
+	}{
+		{"a/b/c.dat", aws.String("v1"), "src/a/b/c.dat?versionId=v1"},
+		{"plain", nil, "src/plain"},
+		{"unversioned", aws.String("null"), "src/unversioned"},
+		{"with space/and%percent", aws.String("v1"), "src/with%20space/and%25percent?versionId=v1"},
+		{"q?hash#", aws.String("v+/="), "src/q%3Fhash%23?versionId=v%2B%2F%3D"},
+		{"ünï/cödé", aws.String("v1"), "src/%C3%BCn%C3%AF/c%C3%B6d%C3%A9?versionId=v1"},
+	}
+	for _, tt := range tests {
+		if got := copySource("src", aws.String(tt.key), tt.versionId); got != tt.want {
+			t.Errorf("copySource(%q, %v) = %q, want %q", tt.key, aws.StringValue(tt.versionId), got, tt.want)
+		}
+	}
+}
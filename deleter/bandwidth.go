@@ -0,0 +1,63 @@
+package deleter
+
+import (
+	"context"
+	"fmt"
+	"golang.org/x/time/rate"
+	"sync/atomic"
+	"time"
+)
+
+//Bytes of deleted versions and objects across the run, updated atomically
+var freedBytes int64
+
+//Soft -max-bandwidth cap in bytes per second, nil when unlimited
+var bandwidthLimiter *rate.Limiter
+
+func newBandwidthLimiter(bytesPerSecond int64) *rate.Limiter {
+	burst := int(bytesPerSecond)
+	if int64(burst) != bytesPerSecond || burst < 0 {
+		burst = int(^uint(0) >> 1)
+	}
+	return rate.NewLimiter(rate.Limit(bytesPerSecond), burst)
+}
+
+//waitBandwidth holds back a delete of size bytes until it fits under -max-bandwidth.
+//An object bigger than a whole second's budget waits for a full second's budget rather than forever.
+func waitBandwidth(ctx context.Context, size int64) {
+	if bandwidthLimiter == nil || size <= 0 {
+		return
+	}
+	if size > int64(bandwidthLimiter.Burst()) {
+		size = int64(bandwidthLimiter.Burst())
+	}
+	bandwidthLimiter.WaitN(ctx, int(size))
+}
+
+func recordFreed(size int64) {
+	atomic.AddInt64(&freedBytes, size)
+}
+
+//reportBandwidth logs the bytes freed by the run and the effective rate
+func reportBandwidth(elapsed time.Duration) {
+	freed := atomic.LoadInt64(&freedBytes)
+	perSecond := float64(0)
+	if elapsed > 0 {
+		perSecond = float64(freed) / elapsed.Seconds()
+	}
+	InfoLogger.Printf("Freed %s in %s, %s/s\n", formatBytes(float64(freed)), elapsed.Round(time.Second), formatBytes(perSecond))
+}
+
+//formatBytes renders a byte count with a binary unit, e.g. 1.5 GiB
+func formatBytes(n float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB", "PiB"}
+	i := 0
+	for n >= 1024 && i < len(units)-1 {
+		n /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%.0f %s", n, units[i])
+	}
+	return fmt.Sprintf("%.1f %s", n, units[i])
+}
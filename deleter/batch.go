@@ -0,0 +1,249 @@
+package deleter
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/cenkalti/backoff/v4"
+	"golang.org/x/sync/errgroup"
+	"sync/atomic"
+)
+
+//Most keys a single DeleteObjects request accepts
+const maxBatchSize = 1000
+
+//Default -batch-max-bytes, enough for 1000 keys of S3's 1024-byte maximum so S3 batches are never split by size
+const defaultBatchMaxBytes = 2 << 20
+
+//XML around each key in a DeleteObjects body, <Object><Key></Key><VersionId></VersionId></Object>, and around
+//the whole list
+const (
+	batchObjectOverhead = 51
+	batchBodyOverhead   = 128
+)
+
+//byteCounter is an io.Writer that only counts what is written to it
+type byteCounter int
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	*c += byteCounter(len(p))
+	return len(p), nil
+}
+
+//batchEntryBytes is roughly what an entry adds to a DeleteObjects body, its key and version ID escaped as XML
+func batchEntryBytes(entry s3Entry) int {
+	var n byteCounter
+	xml.EscapeText(&n, []byte(aws.StringValue(entry.Key)))
+	return int(n) + len(aws.StringValue(entry.VersionId)) + batchObjectOverhead
+}
+
+//splitBatches cuts entries into batches of at most -batch-size entries, starting a new batch early when the
+//next entry would take the request body past -batch-max-bytes. A single entry over the cap gets a batch alone.
+func splitBatches(entries []s3Entry) [][]s3Entry {
+	var batches [][]s3Entry
+	start, size := 0, batchBodyOverhead
+	for i, entry := range entries {
+		entrySize := batchEntryBytes(entry)
+		if i > start && (i-start == *batchSize || size+entrySize > *batchMaxBytes) {
+			batches = append(batches, entries[start:i])
+			start, size = i, batchBodyOverhead
+		}
+		size += entrySize
+	}
+	if start < len(entries) {
+		batches = append(batches, entries[start:])
+	}
+	return batches
+}
+
+//batchDeleteEntries starts deleting a page of entries with DeleteObjects requests of up to -batch-size keys
+//and -batch-max-bytes each.
+//Waiting on the group returns the first fatal error, if any.
+func (j *bucketJob) batchDeleteEntries(entries []s3Entry) *errgroup.Group {
+	kept := orderPage(j.filterEntries(entries))
+	if *dryRun {
+		return j.planEntries(kept)
+	}
+	g, ctx := errgroup.WithContext(j.ctx)
+	perPage := newPageLimit()
+	for _, batch := range splitBatches(kept) {
+		if ctx.Err() != nil {
+			break
+		}
+		batch := batch
+		acquireGoroutine()
+		perPage.acquire()
+		j.pool.acquire()
+		g.Go(func() error {
+			defer j.recoverDelete(batch...)
+			defer releaseGoroutine()
+			defer perPage.release()
+			return j.deleteBatch(ctx, batch)
+		})
+	}
+	return g
+}
+
+//deleteBatch removes entries with one DeleteObjects call, retrying the request as a whole with backoff.
+//Per-key errors in the response are logged rather than retried, except fatal ones which are returned.
+func (j *bucketJob) deleteBatch(ctx context.Context, entries []s3Entry) error {
+	defer j.pool.release()
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	if backup != nil {
+		var err error
+		if entries, err = j.backupEntries(ctx, entries); err != nil || len(entries) == 0 {
+			return err
+		}
+	}
+
+	var size int64
+	identifiers := make([]*s3.ObjectIdentifier, 0, len(entries))
+	for _, entry := range entries {
+		size += entry.Size
+		identifiers = append(identifiers, &s3.ObjectIdentifier{
+			Key:       entry.Key,
+			VersionId: entry.VersionId,
+		})
+	}
+
+	waitBandwidth(ctx, size)
+
+	var out *s3.DeleteObjectsOutput
+	attempt := 1
+	policy := &retryAfterBackOff{BackOff: newDeleteBackOff()}
+	err := backoff.RetryNotify(func() error {
+		if limiter != nil {
+			//Cancelled, or no token comes before the context's deadline: deleting anyway would overrun -rate
+			if err := limiter.Wait(ctx); err != nil {
+				return backoff.Permanent(err)
+			}
+		}
+		attemptCtx, cancel := attemptContext(ctx)
+		var err error
+		out, err = j.svc.DeleteObjectsWithContext(attemptCtx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(j.name),
+			//Quiet responses only list the keys that failed, which keeps them small for large batches
+			Delete: &s3.Delete{Objects: identifiers, Quiet: aws.Bool(!*verboseBatch && !*verbosity)},
+			MFA:    j.mfa,
+		}, policy.capture())
+		hung := attemptTimedOut(ctx, attemptCtx, err)
+		cancel()
+		if hung {
+			tallyError(attemptTimeoutCode, hintNetwork)
+			WarningLogger.Printf("RT: %d Batch of %d got no answer within %s, retrying\n", attempt, len(identifiers), *objectTimeout)
+			attempt++
+			return err
+		}
+		if err != nil {
+			recordError(err)
+			checkAccessDenied(err)
+			if refreshExpiredCredentials(err) {
+				attempt++
+				return err
+			}
+			if isFatal(err) || isNoSuchBucket(err) {
+				return backoff.Permanent(err)
+			}
+			if isThrottle(err) {
+				j.pool.recordThrottle()
+			}
+			if *verbosity {
+				WarningLogger.Printf("RT: %d Unable to delete batch of %d: %s\n", attempt, len(identifiers), attemptFields(err))
+			}
+			if !retryDelete(err) {
+				return backoff.Permanent(err)
+			}
+			attempt++
+		}
+		return err
+	}, backoff.WithContext(policy, ctx), countRetry)
+	if err != nil {
+		if j.stopIfFatal(err) {
+			return err
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		ErrorLogger.Printf("Unable to delete batch of %d after %d attempts: %v\n", len(identifiers), attempt, err)
+		for _, entry := range entries {
+			j.recordFailed(entry)
+		}
+		if *onError == "abort" {
+			err = fmt.Errorf("batch of %d failed with -on-error=abort: %w", len(identifiers), err)
+			stopRun(err)
+			return err
+		}
+		return nil
+	}
+
+	deleted := int64(len(identifiers) - len(out.Errors))
+	atomic.AddInt64(&deletedCount, deleted)
+	atomic.AddInt64(&j.stats.deleted, deleted)
+	if *verbosity {
+		for _, d := range out.Deleted {
+			InfoLogger.Printf("Deleted %s: %s\n", aws.StringValue(d.Key), aws.StringValue(d.VersionId))
+		}
+	}
+	sent := make(map[string]s3Entry, len(out.Errors))
+	if len(out.Errors) > 0 {
+		for _, entry := range entries {
+			sent[planKey(entry.Key, entry.VersionId)] = entry
+		}
+		for _, e := range out.Errors {
+			size -= sent[planKey(e.Key, e.VersionId)].Size
+		}
+	}
+	recordFreed(size)
+	failed := make(map[string]bool, len(out.Errors))
+	for _, e := range out.Errors {
+		failed[planKey(e.Key, e.VersionId)] = true
+	}
+	for _, entry := range entries {
+		if !failed[planKey(entry.Key, entry.VersionId)] {
+			j.recordDeleted(entry)
+		}
+	}
+	for _, e := range out.Errors {
+		code := aws.StringValue(e.Code)
+		keyErr := awserr.New(code, aws.StringValue(e.Message), nil)
+		recordError(keyErr)
+		checkAccessDenied(keyErr)
+		ErrorLogger.Printf("Unable to delete %s: %s %s: %s\n", aws.StringValue(e.Key), aws.StringValue(e.VersionId), code, aws.StringValue(e.Message))
+		failed, ok := sent[planKey(e.Key, e.VersionId)]
+		if !ok {
+			failed = s3Entry{Key: e.Key, VersionId: e.VersionId}
+		}
+		j.recordFailed(failed)
+		if fatalCodes[code] {
+			err := awserr.New(code, aws.StringValue(e.Message), nil)
+			j.stop(err)
+			return err
+		}
+	}
+	if len(out.Errors) > 0 && *onError == "abort" {
+		e := out.Errors[0]
+		err := fmt.Errorf("%s %s failed with -on-error=abort: %s: %s", aws.StringValue(e.Key), aws.StringValue(e.VersionId), aws.StringValue(e.Code), aws.StringValue(e.Message))
+		stopRun(err)
+		return err
+	}
+	if *verifyDeletes {
+		//Quiet responses don't list what was deleted, so that is everything sent that didn't fail
+		for _, entry := range entries {
+			if !failed[planKey(entry.Key, entry.VersionId)] {
+				j.verifyDeleted(ctx, s3.DeleteObjectInput{
+					Bucket:    aws.String(j.name),
+					Key:       entry.Key,
+					VersionId: entry.VersionId,
+					MFA:       j.mfa,
+				}, entry.Type, entry.Size)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package deleter
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//headCountingS3 is the fake bucket, counting the HeadObject calls made on it
+type headCountingS3 struct {
+	*fakeS3
+	heads int64
+}
+
+func (f *headCountingS3) HeadObjectWithContext(ctx aws.Context, input *s3.HeadObjectInput, opts ...request.Option) (*s3.HeadObjectOutput, error) {
+	atomic.AddInt64(&f.heads, 1)
+	return f.fakeS3.HeadObjectWithContext(ctx, input, opts...)
+}
+
+func TestBatchVerifyDeletes(t *testing.T) {
+	setFlags(t, map[string]string{"batch": "true", "include-versioned-batch": "true", "verify-deletes": "true"})
+	f := &headCountingS3{fakeS3: newFakeS3("demo", 10)}
+	j := newTestJob(t, f)
+	if err := j.deleteAllVersions(); err != nil {
+		t.Fatal(err)
+	}
+	deleted := atomic.LoadInt64(&j.stats.deleted)
+	if deleted == 0 {
+		t.Fatal("nothing was deleted")
+	}
+	if heads := atomic.LoadInt64(&f.heads); heads != deleted {
+		t.Errorf("%d HeadObject calls for %d batch deletes, want one each", heads, deleted)
+	}
+	if len(j.failed) != 0 {
+		t.Errorf("%d deletes recorded as failed, want none", len(j.failed))
+	}
+}
+
+func TestDeleteBatchWaitsOnLimiter(t *testing.T) {
+	useExhaustedLimiter(t)
+	f := newFakeS3("demo", 1)
+	j := newTestJob(t, f)
+	ctx, cancel := context.WithTimeout(j.ctx, time.Minute)
+	defer cancel()
+
+	j.pool.acquire()
+	if err := j.deleteBatch(ctx, []s3Entry{firstVersion(f)}); err != nil {
+		t.Fatal(err)
+	}
+	if j.stats.deleted != 0 {
+		t.Error("deleted without a -rate token")
+	}
+	if len(j.failed) != 1 {
+		t.Errorf("%d deletes recorded as failed, want 1", len(j.failed))
+	}
+}
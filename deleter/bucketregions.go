@@ -0,0 +1,80 @@
+package deleter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+//readBucketList reads the bucket names in a -bucket-list file, or stdin for "-", one per line.
+//Blank lines and lines starting with # are skipped, and a bucket listed twice is only kept once.
+func readBucketList(path string) ([]string, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		r = file
+	}
+	seen := map[string]bool{}
+	var buckets []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		bucket := strings.TrimSpace(scanner.Text())
+		if bucket == "" || strings.HasPrefix(bucket, "#") || seen[bucket] {
+			continue
+		}
+		seen[bucket] = true
+		buckets = append(buckets, bucket)
+	}
+	return buckets, scanner.Err()
+}
+
+//listBucketRegions is -list-regions-of-buckets: it prints each bucket with its region, tab separated, in the order
+//the buckets were given, then how many are in each region. The lookups run in parallel through the region cache,
+//so -region-map entries aren't looked up again. Nothing is deleted. It returns the exit code: 1 when any region
+//couldn't be found, since a region-scoped run would leave those buckets out.
+func listBucketRegions(buckets []string) int {
+	resolveRegions(buckets)
+	perRegion := map[string]int{}
+	var unresolved []string
+	for _, bucket := range buckets {
+		region := getRegion(bucket)
+		if region == "unknown" {
+			if bucketMissing(bucket) {
+				region = "missing"
+			}
+			unresolved = append(unresolved, bucket)
+		} else {
+			perRegion[region]++
+		}
+		fmt.Printf("%s\t%s\n", bucket, region)
+	}
+
+	regions := make([]string, 0, len(perRegion))
+	for region := range perRegion {
+		regions = append(regions, region)
+	}
+	sort.Strings(regions)
+	InfoLogger.Printf("%d buckets in %d regions\n", len(buckets)-len(unresolved), len(regions))
+	for _, region := range regions {
+		InfoLogger.Printf("  %s: %d\n", region, perRegion[region])
+	}
+	if len(unresolved) == 0 {
+		return 0
+	}
+	WarningLogger.Printf("Unable to find the region of %d buckets:\n", len(unresolved))
+	for _, bucket := range unresolved {
+		if bucketMissing(bucket) {
+			WarningLogger.Printf("  %s (does not exist)\n", bucket)
+		} else {
+			WarningLogger.Printf("  %s\n", bucket)
+		}
+	}
+	return 1
+}
@@ -0,0 +1,317 @@
+package deleter
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	identityOnce sync.Once
+	identity     *sts.GetCallerIdentityOutput
+	identityErr  error
+)
+
+//callerIdentity asks STS who the credentials belong to, once per run
+func callerIdentity() (*sts.GetCallerIdentityOutput, error) {
+	identityOnce.Do(func() {
+		sess, err := newSession("us-east-1")
+		if err != nil {
+			identityErr = err
+			return
+		}
+		identity, identityErr = sts.New(sess).GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	})
+	return identity, identityErr
+}
+
+//logWhoami records which identity is about to delete from a bucket, for anyone reading the logs afterwards
+func logWhoami(bucket string, region string) {
+	identity, err := callerIdentity()
+	if err != nil {
+		WarningLogger.Printf("Unable to look up the caller identity: %v\n", err)
+		return
+	}
+	InfoLogger.Printf("Running as %s (account %s) against %s in %s\n", aws.StringValue(identity.Arn), aws.StringValue(identity.Account), bucket, region)
+}
+
+//-match-regex, compiled
+var bucketNameRegex *regexp.Regexp
+
+//bucketMatcher is one of the name filters a discovered bucket has to pass
+type bucketMatcher func(bucket *s3.Bucket) bool
+
+//bucketMatchers builds the active discovery filters. A bucket is selected only if it passes all of them.
+func bucketMatchers() []bucketMatcher {
+	var matchers []bucketMatcher
+	if *namePrefix != "" {
+		matchers = append(matchers, func(bucket *s3.Bucket) bool {
+			return strings.HasPrefix(aws.StringValue(bucket.Name), *namePrefix)
+		})
+	}
+	if *nameSuffix != "" {
+		matchers = append(matchers, func(bucket *s3.Bucket) bool {
+			return strings.HasSuffix(aws.StringValue(bucket.Name), *nameSuffix)
+		})
+	}
+	if bucketNameRegex != nil {
+		matchers = append(matchers, func(bucket *s3.Bucket) bool {
+			return bucketNameRegex.MatchString(aws.StringValue(bucket.Name))
+		})
+	}
+	return matchers
+}
+
+//discoverBuckets lists the caller's buckets and returns the names of those passing every matcher
+func discoverBuckets() []string {
+	sess, err := newSession("us-east-1")
+	if err != nil {
+		exitErrorf("Unable to setup s3 connection: %v", err)
+	}
+	out, err := s3.New(sess).ListBuckets(&s3.ListBucketsInput{})
+	if err != nil {
+		exitErrorf("Unable to list buckets: %v", err)
+	}
+
+	matchers := bucketMatchers()
+	var names []string
+	for _, bucket := range out.Buckets {
+		matched := true
+		for _, match := range matchers {
+			if !match(bucket) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			names = append(names, aws.StringValue(bucket.Name))
+		}
+	}
+	return names
+}
+
+//GetBucketRegion calls in flight while resolving the regions of discovered buckets
+const regionLookupConcurrency = 16
+
+//resolveRegions looks up the region of every discovered bucket up front, a few at a time, so the preview can show
+//them and each bucket later gets a client in its own region from the cache instead of running into redirects
+func resolveRegions(buckets []string) {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, regionLookupConcurrency)
+	for _, bucket := range buckets {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(bucket string) {
+			defer wg.Done()
+			getRegion(bucket)
+			<-sem
+		}(bucket)
+	}
+	wg.Wait()
+}
+
+//checkOwnership keeps the buckets that belong to the caller's account and loudly reports the rest.
+//HeadBucket with ExpectedBucketOwner makes S3 itself refuse the request for a bucket owned by any other account.
+func checkOwnership(buckets []string) []string {
+	identity, err := callerIdentity()
+	if err != nil {
+		exitErrorf("Unable to look up the caller's account: %v", err)
+	}
+	account := aws.StringValue(identity.Account)
+
+	var owned []string
+	for _, bucket := range buckets {
+		region := getRegion(bucket)
+		if region == "unknown" {
+			WarningLogger.Printf("SKIPPING %s: unable to find its region to confirm it belongs to account %s\n", bucket, account)
+			continue
+		}
+		sess, err := newSession(region)
+		if err == nil {
+			_, err = s3.New(sess).HeadBucket(&s3.HeadBucketInput{
+				Bucket:              aws.String(bucket),
+				ExpectedBucketOwner: aws.String(account),
+			})
+		}
+		if err != nil {
+			WarningLogger.Printf("SKIPPING %s: unable to confirm it belongs to account %s: %v\n", bucket, account, err)
+			continue
+		}
+		owned = append(owned, bucket)
+	}
+	return owned
+}
+
+//confirmBuckets shows the matched buckets and exits unless the user confirms, or -force is set
+func confirmBuckets(buckets []string) {
+	InfoLogger.Printf("%d buckets matched:\n", len(buckets))
+	for _, bucket := range buckets {
+		region := getRegion(bucket)
+		if region == "unknown" {
+			region = "region unknown, will fail"
+		}
+		fmt.Printf("  %s (%s)\n", bucket, region)
+	}
+	if *force || readOnly() {
+		return
+	}
+
+	fmt.Printf("Type \"yes\" to delete these %d buckets and everything in them: ", len(buckets))
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	if strings.TrimSpace(answer) != "yes" {
+		exitErrorf("Aborted, nothing was deleted")
+	}
+}
+
+//Environment variable that confirms a -b run without a prompt, for automation that can't pass -force
+const confirmEnv = "DELETE_S3_CONFIRM"
+
+//confirmBucket asks for the bucket name to be typed back before a -b run deletes anything.
+//Setting DELETE_S3_CONFIRM to the exact name answers the prompt; setting it to anything else refuses the run, even with -force.
+func confirmBucket(bucket string) {
+	if token, set := os.LookupEnv(confirmEnv); set {
+		if token != bucket {
+			exitErrorf("%s is set to %q, which doesn't match bucket %s, nothing was deleted", confirmEnv, token, bucket)
+		}
+		return
+	}
+	if *force {
+		return
+	}
+
+	previewBucket(bucket)
+	fmt.Printf("Type the bucket name to confirm deleting %s: ", bucket)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	if strings.TrimSpace(answer) != bucket {
+		exitErrorf("Aborted, nothing was deleted")
+	}
+}
+
+//How many keys the confirmation prompt shows, and how many listing pages it counts at most
+const (
+	previewKeys  = 5
+	previewPages = 10
+)
+
+//previewBucket shows a few of the bucket's keys, and how many versions and delete markers it holds and their
+//size, before the confirmation prompt, so the operator can tell it's the bucket they meant. Counting stops after
+//previewPages pages, so a huge bucket costs at most that many listing requests and is shown as "at least".
+//Failing to list is only warned about: the prompt is shown anyway.
+func previewBucket(bucket string) {
+	var svc s3iface.S3API
+	if *fakeKeys > 0 {
+		svc = newFakeS3(bucket, *fakeKeys)
+	} else {
+		sess, err := newSession(getRegion(bucket))
+		if err != nil {
+			WarningLogger.Printf("Unable to preview %s: %v\n", bucket, err)
+			return
+		}
+		svc = newS3Client(sess)
+	}
+	var keys []string
+	var count, size int64
+	pages := 0
+	truncated := false
+	err := svc.ListObjectVersionsPagesWithContext(runCtx, &s3.ListObjectVersionsInput{
+		Bucket:  aws.String(bucket),
+		MaxKeys: aws.Int64(listPageSize),
+	}, func(page *s3.ListObjectVersionsOutput, lastPage bool) bool {
+		for _, version := range page.Versions {
+			size += aws.Int64Value(version.Size)
+			if key := aws.StringValue(version.Key); len(keys) < previewKeys && (len(keys) == 0 || keys[len(keys)-1] != key) {
+				keys = append(keys, key)
+			}
+		}
+		count += int64(len(page.Versions) + len(page.DeleteMarkers))
+		pages++
+		truncated = !lastPage && pages >= previewPages
+		return !truncated
+	})
+	if err != nil {
+		WarningLogger.Printf("Unable to preview %s: %v\n", bucket, err)
+		return
+	}
+	if count == 0 {
+		fmt.Printf("%s is empty\n", bucket)
+		return
+	}
+	fmt.Printf("Some of the keys in %s:\n", bucket)
+	for _, key := range keys {
+		fmt.Printf("  %s\n", key)
+	}
+	if truncated {
+		fmt.Printf("%s holds at least %d versions and delete markers, %s or more\n", bucket, count, formatBytes(float64(size)))
+		return
+	}
+	fmt.Printf("%s holds %d versions and delete markers, %s in all\n", bucket, count, formatBytes(float64(size)))
+}
+
+//The key=value of -require-bucket-tag
+var ownerTagKey, ownerTagValue string
+
+//checkOwnerTag is -require-bucket-tag's guard against emptying a bucket the team didn't create, such as a
+//long-lived shared one. It reports whether to go on. -force goes on regardless, with a warning.
+func (j *bucketJob) checkOwnerTag() bool {
+	out, err := j.svc.GetBucketTaggingWithContext(j.ctx, &s3.GetBucketTaggingInput{
+		Bucket: aws.String(j.name),
+	})
+	var tags []*s3.Tag
+	if err == nil {
+		tags = out.TagSet
+	} else if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != "NoSuchTagSet" {
+		if j.timedOut() {
+			j.failTimeout()
+			return false
+		}
+		//Not being able to read the tags is no proof of ownership, so it counts as not tagged
+		WarningLogger.Printf("Unable to read the tags of %s: %v\n", j.name, err)
+	}
+	if hasTag(tags, ownerTagKey, ownerTagValue) {
+		return true
+	}
+	if *force {
+		WarningLogger.Printf("%s isn't tagged %s=%s, going ahead because of -force\n", j.name, ownerTagKey, ownerTagValue)
+		return true
+	}
+	j.stats.skipped = fmt.Sprintf("not tagged %s=%s", ownerTagKey, ownerTagValue)
+	WarningLogger.Printf("Not touching %s: it isn't tagged %s=%s, use -force to delete it anyway\n", j.name, ownerTagKey, ownerTagValue)
+	return false
+}
+
+//Buckets emptied at the same time take turns at the -confirm-before-bucket-delete prompt
+var bucketDeletePromptMu sync.Mutex
+
+//confirmBucketDelete is -confirm-before-bucket-delete's last check once a bucket has been emptied.
+//On a terminal the bucket name has to be typed again; otherwise the run waits -bucket-delete-delay,
+//giving time to interrupt it. It reports whether to go ahead with DeleteBucket.
+func (j *bucketJob) confirmBucketDelete() bool {
+	deleted := atomic.LoadInt64(&j.stats.deleted)
+	if !isTerminal(os.Stdin) {
+		InfoLogger.Printf("Emptied %s (%d deleted), deleting the bucket in %s unless the run is interrupted\n", j.name, deleted, *bucketDeleteDelay)
+		timer := time.NewTimer(*bucketDeleteDelay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			return true
+		case <-j.ctx.Done():
+			return false
+		}
+	}
+
+	bucketDeletePromptMu.Lock()
+	defer bucketDeletePromptMu.Unlock()
+	fmt.Printf("Emptied %s (%d deleted). Type the bucket name again to delete the bucket itself, anything else keeps it: ", j.name, deleted)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	return strings.TrimSpace(answer) == j.name
+}
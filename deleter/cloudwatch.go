@@ -0,0 +1,143 @@
+package deleter
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//Most data points sent in one PutMetricData call
+const metricsPerPut = 20
+
+//Buckets whose numbers -cloudwatch-namespace publishes, with what was last published for each
+var (
+	metricJobsMu sync.Mutex
+	metricJobs   = map[*bucketJob]*publishedMetrics{}
+)
+
+//publishedMetrics is a bucket's counts as of its last push, so each push sends what changed since
+type publishedMetrics struct {
+	deleted  int64
+	failed   int
+	at       time.Time
+	finished bool
+}
+
+//trackMetrics adds a bucket to what -cloudwatch-namespace publishes
+func trackMetrics(j *bucketJob) {
+	if *cloudWatchNamespace == "" {
+		return
+	}
+	metricJobsMu.Lock()
+	metricJobs[j] = &publishedMetrics{at: time.Now()}
+	metricJobsMu.Unlock()
+}
+
+//untrackMetrics marks a bucket finished: its last numbers go out with the next push, then it is dropped
+func untrackMetrics(j *bucketJob) {
+	if *cloudWatchNamespace == "" {
+		return
+	}
+	metricJobsMu.Lock()
+	if published, ok := metricJobs[j]; ok {
+		published.finished = true
+	}
+	metricJobsMu.Unlock()
+}
+
+//startMetricsPush publishes ObjectsDeleted, Failures and DeletionRate for each bucket under -cloudwatch-namespace
+//every -stats-interval, with the bucket as the Bucket dimension, through a CloudWatch client in the bucket's
+//region built like the S3 ones. Counts are what changed since the last push. It is best effort: a failed push
+//is only warned about. The returned func stops it after a last push.
+func startMetricsPush(every time.Duration) (stop func()) {
+	stopping, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		clients := map[string]*cloudwatch.CloudWatch{}
+		ticker := time.NewTicker(every)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopping:
+				pushMetrics(clients)
+				return
+			case <-ticker.C:
+				pushMetrics(clients)
+			}
+		}
+	}()
+	return func() {
+		close(stopping)
+		<-stopped
+	}
+}
+
+//pushMetrics sends one round of data points, grouped by region
+func pushMetrics(clients map[string]*cloudwatch.CloudWatch) {
+	now := time.Now()
+	byRegion := map[string][]*cloudwatch.MetricDatum{}
+	metricJobsMu.Lock()
+	for j, published := range metricJobs {
+		deleted := atomic.LoadInt64(&j.stats.deleted)
+		j.failedMu.Lock()
+		failed := len(j.failed)
+		j.failedMu.Unlock()
+		dimensions := []*cloudwatch.Dimension{{Name: aws.String("Bucket"), Value: aws.String(j.name)}}
+		var perSecond float64
+		if seconds := now.Sub(published.at).Seconds(); seconds > 0 {
+			perSecond = float64(deleted-published.deleted) / seconds
+		}
+		byRegion[j.region] = append(byRegion[j.region],
+			metricDatum("ObjectsDeleted", float64(deleted-published.deleted), cloudwatch.StandardUnitCount, dimensions, now),
+			metricDatum("Failures", float64(failed-published.failed), cloudwatch.StandardUnitCount, dimensions, now),
+			metricDatum("DeletionRate", perSecond, cloudwatch.StandardUnitCountSecond, dimensions, now),
+		)
+		published.deleted, published.failed, published.at = deleted, failed, now
+		if published.finished {
+			delete(metricJobs, j)
+		}
+	}
+	metricJobsMu.Unlock()
+
+	for region, data := range byRegion {
+		client, ok := clients[region]
+		if !ok {
+			sess, err := newSession(region)
+			if err != nil {
+				WarningLogger.Printf("Unable to publish CloudWatch metrics in %s: %v\n", region, err)
+				continue
+			}
+			client = cloudwatch.New(sess)
+			clients[region] = client
+		}
+		for start := 0; start < len(data); start += metricsPerPut {
+			end := start + metricsPerPut
+			if end > len(data) {
+				end = len(data)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			_, err := client.PutMetricDataWithContext(ctx, &cloudwatch.PutMetricDataInput{
+				Namespace:  cloudWatchNamespace,
+				MetricData: data[start:end],
+			})
+			cancel()
+			if err != nil {
+				WarningLogger.Printf("Unable to publish CloudWatch metrics in %s: %v\n", region, err)
+				break
+			}
+		}
+	}
+}
+
+func metricDatum(name string, value float64, unit string, dimensions []*cloudwatch.Dimension, at time.Time) *cloudwatch.MetricDatum {
+	return &cloudwatch.MetricDatum{
+		MetricName: aws.String(name),
+		Value:      aws.Float64(value),
+		Unit:       aws.String(unit),
+		Dimensions: dimensions,
+		Timestamp:  aws.Time(at),
+	}
+}
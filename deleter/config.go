@@ -0,0 +1,101 @@
+package deleter
+
+import (
+	"flag"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+)
+
+//Environment variables the AWS SDK reads that affect where and as whom the run happens
+var configEnvVars = []string{
+	"AWS_PROFILE",
+	"AWS_REGION",
+	"AWS_DEFAULT_REGION",
+	"AWS_CONFIG_FILE",
+	"AWS_SHARED_CREDENTIALS_FILE",
+	"AWS_SDK_LOAD_CONFIG",
+	"AWS_ACCESS_KEY_ID",
+	"AWS_SECRET_ACCESS_KEY",
+	"AWS_SESSION_TOKEN",
+	"AWS_CA_BUNDLE",
+	"HTTPS_PROXY",
+	"HTTP_PROXY",
+}
+
+//printConfig logs the configuration the run will use, once every flag has been validated and defaulted.
+//It goes to stderr like the heartbeat, so -q doesn't hide it. Secrets are redacted.
+func printConfig(buckets []string) {
+	logger := log.New(os.Stderr, "CONFIG: ", log.Ldate|log.Ltime)
+
+	regionCacheMu.Lock()
+	for _, bucket := range buckets {
+		region, ok := regionCache[bucket]
+		if !ok {
+			region = "looked up when the bucket starts"
+		}
+		logger.Printf("bucket %s (%s)\n", bucket, region)
+	}
+	regionCacheMu.Unlock()
+
+	flag.VisitAll(func(f *flag.Flag) {
+		source := "default"
+		if flagSet(f.Name) {
+			source = "set"
+		}
+		logger.Printf("-%s=%s (%s)\n", f.Name, redactFlag(f.Name, f.Value.String()), source)
+	})
+	//Settings other flags change rather than set directly
+	logger.Printf("list page size %d\n", listPageSize)
+	logger.Printf("key prefixes %q\n", keyPrefixes)
+
+	for _, name := range configEnvVars {
+		if value, ok := os.LookupEnv(name); ok {
+			logger.Printf("$%s=%s\n", name, redactEnv(name, value))
+		}
+	}
+}
+
+//flagSet reports whether a flag was given on the command line
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+//redactFlag hides the MFA code and any password in a URL flag
+func redactFlag(name string, value string) string {
+	switch name {
+	case "mfa":
+		if i := strings.LastIndex(value, " "); i >= 0 {
+			return value[:i] + " ******"
+		}
+	case "endpoint-url", "otel-endpoint":
+		if u, err := url.Parse(value); err == nil && u.User != nil {
+			u.User = url.User(u.User.Username())
+			return u.String()
+		}
+	}
+	return value
+}
+
+//redactEnv hides credentials, keeping the last four characters of the access key ID so it can be told apart
+func redactEnv(name string, value string) string {
+	switch name {
+	case "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN":
+		return "******"
+	case "AWS_ACCESS_KEY_ID":
+		if len(value) > 4 {
+			return "******" + value[len(value)-4:]
+		}
+		return "******"
+	case "HTTPS_PROXY", "HTTP_PROXY":
+		return redactFlag("endpoint-url", value)
+	}
+	return value
+}
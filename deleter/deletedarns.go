@@ -0,0 +1,73 @@
+package deleter
+
+import (
+	"encoding/json"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"io"
+	"os"
+)
+
+//DeletedBucket is one line of -deleted-arns-out
+type DeletedBucket struct {
+	ARN     string `json:"arn"`
+	Bucket  string `json:"bucket"`
+	Region  string `json:"region"`
+	Account string `json:"account,omitempty"`
+}
+
+//bucketARN is the ARN of a bucket in region. Bucket ARNs carry neither region nor account, only the partition.
+func bucketARN(bucket string, region string) string {
+	partition := "aws"
+	if p, ok := endpoints.PartitionForRegion(endpoints.DefaultPartitions(), region); ok {
+		partition = p.ID()
+	}
+	return "arn:" + partition + ":s3:::" + bucket
+}
+
+//writeDeletedARNs writes a line of JSON for every bucket the run deleted, to path or to stdout for "-", so
+//infrastructure tooling can reconcile its state. Buckets that were kept or failed are left out. The account is
+//the caller's, from STS, and is left out when it can't be looked up, as on S3-compatible stores.
+func writeDeletedARNs(path string) error {
+	account := ""
+	if *fakeKeys == 0 && *endpointURL == "" {
+		if identity, err := callerIdentity(); err == nil {
+			account = aws.StringValue(identity.Account)
+		} else {
+			WarningLogger.Printf("Unable to look up the account for -deleted-arns-out: %v\n", err)
+		}
+	}
+	if path == "-" {
+		return encodeDeletedARNs(os.Stdout, account)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := encodeDeletedARNs(f, account); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func encodeDeletedARNs(out io.Writer, account string) error {
+	enc := json.NewEncoder(out)
+	bucketResultsMu.Lock()
+	defer bucketResultsMu.Unlock()
+	for _, bucket := range bucketResults {
+		if !bucket.BucketDeleted {
+			continue
+		}
+		err := enc.Encode(DeletedBucket{
+			ARN:     bucketARN(bucket.Name, bucket.Region),
+			Bucket:  bucket.Name,
+			Region:  bucket.Region,
+			Account: account,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+//Package deleter is deleteS3bucket. Main runs the command line tool, and programs that embed the tool build a
+//Deleter with NewDeleter instead: the same engine, with Options in place of the flags.
+package deleter
+
+import (
+	"context"
+	"flag"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"io/ioutil"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//The engine's settings and counters are package state, so runs through a Deleter take turns
+var runMu sync.Mutex
+
+//Deleter deletes what is in a bucket through client. Build it with NewDeleter.
+type Deleter struct {
+	client s3iface.S3API
+	//Values of the engine's flags the options set, by flag name, applied for each run
+	flags  map[string]string
+	logger *log.Logger
+}
+
+//Option configures a Deleter
+type Option func(*Deleter)
+
+//WithConcurrency sets how many deletes are in flight at once, 1000 by default like -concurrency
+func WithConcurrency(n int) Option {
+	return func(d *Deleter) {
+		if n > 0 {
+			d.flags["concurrency"] = strconv.Itoa(n)
+		}
+	}
+}
+
+//WithDryRun logs what would be deleted instead of deleting it, like -dry-run
+func WithDryRun(dryRun bool) Option {
+	return func(d *Deleter) {
+		d.flags["dry-run"] = strconv.FormatBool(dryRun)
+	}
+}
+
+//WithRateLimit caps deletes per second like -rate, 0 for no limit (the default)
+func WithRateLimit(perSecond float64) Option {
+	return func(d *Deleter) {
+		if perSecond > 0 {
+			d.flags["rate"] = strconv.FormatFloat(perSecond, 'g', -1, 64)
+		} else {
+			delete(d.flags, "rate")
+		}
+	}
+}
+
+//WithLogger sends what the tool would log to logger. Nothing is logged by default.
+func WithLogger(logger *log.Logger) Option {
+	return func(d *Deleter) {
+		if logger != nil {
+			d.logger = logger
+		}
+	}
+}
+
+//WithPrefix only deletes keys starting with prefix, like -prefix
+func WithPrefix(prefix string) Option {
+	return func(d *Deleter) {
+		if prefix != "" {
+			d.flags["prefix"] = prefix
+		} else {
+			delete(d.flags, "prefix")
+		}
+	}
+}
+
+//NewDeleter returns a Deleter using client with opts applied over the defaults, which are the tool's
+func NewDeleter(client s3iface.S3API, opts ...Option) *Deleter {
+	d := &Deleter{
+		client: client,
+		flags:  map[string]string{},
+		logger: log.New(ioutil.Discard, "", 0),
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+//Empty deletes every version and delete marker in bucket, under the prefix if one is set, and returns how many
+//it deleted (or would have, with WithDryRun). The bucket itself is kept. Deletes are retried like the tool's;
+//the ones that still fail are listed in the *ErrPartialFailure returned.
+func (d *Deleter) Empty(ctx context.Context, bucket string) (int64, error) {
+	runMu.Lock()
+	defer runMu.Unlock()
+	restore, err := d.apply()
+	defer restore()
+	if err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	j := &bucketJob{
+		ctx:     ctx,
+		cancel:  cancel,
+		name:    bucket,
+		svc:     d.client,
+		started: time.Now(),
+	}
+	var stopPool func()
+	j.pool, stopPool = startPool(bucket)
+	defer stopPool()
+	if err := j.deleteAllVersions(); err != nil {
+		return j.deletedSoFar(), err
+	}
+	return j.deletedSoFar(), j.partialFailure()
+}
+
+//apply sets the engine up as the options and the tool's defaults say, returning what puts it back as it was
+func (d *Deleter) apply() (restore func(), err error) {
+	if engineFlags == nil {
+		defineFlags(flag.NewFlagSet("deleter", flag.ContinueOnError))
+	}
+	var undo []func()
+	restore = func() {
+		for i := len(undo) - 1; i >= 0; i-- {
+			undo[i]()
+		}
+	}
+	for name, value := range d.flags {
+		f := engineFlags.Lookup(name)
+		old := f.Value.String()
+		if err := f.Value.Set(value); err != nil {
+			return restore, err
+		}
+		undo = append(undo, func() { f.Value.Set(old) })
+	}
+
+	savedLoggers := []*log.Logger{InfoLogger, WarningLogger, ErrorLogger}
+	savedPrefixes, savedLimiter := keyPrefixes, limiter
+	undo = append(undo, func() {
+		InfoLogger, WarningLogger, ErrorLogger = savedLoggers[0], savedLoggers[1], savedLoggers[2]
+		keyPrefixes, limiter = savedPrefixes, savedLimiter
+	})
+	InfoLogger, WarningLogger, ErrorLogger = d.logger, d.logger, d.logger
+	keyPrefixes = nil
+	if *keyPrefix != "" {
+		keyPrefixes = []string{*keyPrefix}
+	}
+	limiter = newDeleteLimiter()
+	return restore, nil
+}
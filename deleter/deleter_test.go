@@ -0,0 +1,165 @@
+package deleter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"golang.org/x/time/rate"
+	"io/ioutil"
+	"log"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+//engineSettings is what the engine was set up with when a Deleter's run listed the bucket
+type engineSettings struct {
+	concurrency int
+	dryRun      bool
+	rate        rate.Limit
+	prefixes    []string
+}
+
+//settingsS3 is the fake bucket, noting the engine's settings at the first listing
+type settingsS3 struct {
+	*fakeS3
+	seen *engineSettings
+}
+
+func (f *settingsS3) ListObjectVersionsPagesWithContext(ctx aws.Context, input *s3.ListObjectVersionsInput, fn func(*s3.ListObjectVersionsOutput, bool) bool, opts ...request.Option) error {
+	if f.seen == nil {
+		f.seen = &engineSettings{concurrency: *concurrency, dryRun: *dryRun, prefixes: append([]string(nil), keyPrefixes...)}
+		if limiter != nil {
+			f.seen.rate = limiter.Limit()
+		}
+	}
+	return f.fakeS3.ListObjectVersionsPagesWithContext(ctx, input, fn, opts...)
+}
+
+//entryCount is how many versions and delete markers newFakeS3 puts under a key prefix
+func entryCount(f *fakeS3, prefix string) int64 {
+	var n int64
+	for _, key := range f.sortedKeys(prefix) {
+		n += int64(len(f.keys[key]))
+	}
+	return n
+}
+
+func TestNewDeleterDefaults(t *testing.T) {
+	f := &settingsS3{fakeS3: newFakeS3("demo", 20)}
+	d := NewDeleter(f)
+	if len(d.flags) != 0 {
+		t.Errorf("flags %v set by default, want none", d.flags)
+	}
+	if d.logger.Writer() != ioutil.Discard {
+		t.Error("logging by default")
+	}
+
+	want := entryCount(f.fakeS3, "")
+	deleted, err := d.Empty(context.Background(), "demo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != want {
+		t.Errorf("deleted %d, want %d", deleted, want)
+	}
+	if left := len(f.sortedKeys("")); left != 0 || f.deleted {
+		t.Errorf("%d keys left, bucket deleted %t: want it emptied and kept", left, f.deleted)
+	}
+	if f.seen == nil {
+		t.Fatal("the bucket was never listed")
+	}
+	if wantSeen := (engineSettings{concurrency: 1000}); !reflect.DeepEqual(*f.seen, wantSeen) {
+		t.Errorf("ran with %+v, want the tool's defaults %+v", *f.seen, wantSeen)
+	}
+}
+
+func TestDeleterOptions(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      []Option
+		wantFlags map[string]string
+		wantSeen  engineSettings
+		//A prefix outside the run's, which must keep its entries
+		wantLeftUnder string
+		dryRun        bool
+	}{
+		{name: "WithConcurrency", opts: []Option{WithConcurrency(50)}, wantFlags: map[string]string{"concurrency": "50"}, wantSeen: engineSettings{concurrency: 50}},
+		{name: "WithConcurrency ignores 0", opts: []Option{WithConcurrency(0)}, wantFlags: map[string]string{}, wantSeen: engineSettings{concurrency: 1000}},
+		{name: "WithDryRun", opts: []Option{WithDryRun(true)}, wantFlags: map[string]string{"dry-run": "true"}, wantSeen: engineSettings{concurrency: 1000, dryRun: true}, dryRun: true},
+		{name: "WithRateLimit", opts: []Option{WithRateLimit(5000)}, wantFlags: map[string]string{"rate": "5000"}, wantSeen: engineSettings{concurrency: 1000, rate: 5000}},
+		{name: "WithRateLimit 0 takes it off", opts: []Option{WithRateLimit(5000), WithRateLimit(0)}, wantFlags: map[string]string{}, wantSeen: engineSettings{concurrency: 1000}},
+		{name: "WithPrefix", opts: []Option{WithPrefix("fake/001/")}, wantFlags: map[string]string{"prefix": "fake/001/"}, wantSeen: engineSettings{concurrency: 1000, prefixes: []string{"fake/001/"}}, wantLeftUnder: "fake/000/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			//Keys are spread over fake/000/ to fake/099/, so 20 keys put one each under fake/000/ to fake/019/
+			f := &settingsS3{fakeS3: newFakeS3("demo", 20)}
+			d := NewDeleter(f, tt.opts...)
+			if !reflect.DeepEqual(d.flags, tt.wantFlags) {
+				t.Errorf("flags %v, want %v", d.flags, tt.wantFlags)
+			}
+			total := entryCount(f.fakeS3, "")
+			deleted, err := d.Empty(context.Background(), "demo")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f.seen == nil || !reflect.DeepEqual(*f.seen, tt.wantSeen) {
+				t.Errorf("ran with %+v, want %+v", f.seen, tt.wantSeen)
+			}
+			left := entryCount(f.fakeS3, "")
+			switch {
+			case tt.dryRun:
+				if deleted != total || left != total {
+					t.Errorf("dry run counted %d and left %d of %d entries, want all counted and all left", deleted, left, total)
+				}
+			case tt.wantLeftUnder != "":
+				if left == 0 || left+deleted != total || len(f.sortedKeys(tt.wantLeftUnder)) == 0 {
+					t.Errorf("deleted %d and left %d of %d entries, want only the prefix deleted", deleted, left, total)
+				}
+			default:
+				if deleted != total || left != 0 {
+					t.Errorf("deleted %d and left %d of %d entries, want all deleted", deleted, left, total)
+				}
+			}
+		})
+	}
+
+	t.Run("WithLogger", func(t *testing.T) {
+		var buf bytes.Buffer
+		d := NewDeleter(newFakeS3("demo", 20), WithLogger(log.New(&buf, "", 0)), WithPrefix("fake/001/"))
+		if _, err := d.Empty(context.Background(), "demo"); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(buf.String(), `under "fake/001/"`) {
+			t.Errorf("logged %q, want the prefix's summary line", buf.String())
+		}
+		if NewDeleter(nil, WithLogger(nil)).logger.Writer() != ioutil.Discard {
+			t.Error("WithLogger(nil) replaced the default")
+		}
+	})
+}
+
+func TestDeleterRestoresEngine(t *testing.T) {
+	logger := InfoLogger
+	d := NewDeleter(newFakeS3("demo", 5), WithConcurrency(7), WithRateLimit(5000), WithPrefix("fake/"), WithLogger(log.New(ioutil.Discard, "", 0)))
+	if _, err := d.Empty(context.Background(), "demo"); err != nil {
+		t.Fatal(err)
+	}
+	if *concurrency != 1000 || *rateLimit != 0 || *keyPrefix != "" || limiter != nil || keyPrefixes != nil || InfoLogger != logger {
+		t.Error("the engine wasn't put back to how it was before the run")
+	}
+}
+
+func TestDeleterPartialFailure(t *testing.T) {
+	f := newFakeS3("demo", 5)
+	key := f.sortedKeys("")[0]
+	_, err := NewDeleter(&failingDeleteS3{fakeS3: f, key: key}).Empty(context.Background(), "demo")
+	var partial *ErrPartialFailure
+	if !errors.As(err, &partial) || len(partial.FailedKeys) != len(f.keys[key]) {
+		t.Errorf("Empty = %v, want an ErrPartialFailure for the %d entries of %s", err, len(f.keys[key]), key)
+	}
+}
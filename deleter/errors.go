@@ -0,0 +1,220 @@
+package deleter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials/ssocreds"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+//Error codes that mean no further request with the current credentials can succeed
+var fatalCodes = map[string]bool{
+	"ExpiredToken":          true,
+	"InvalidAccessKeyId":    true,
+	"InvalidToken":          true,
+	"SignatureDoesNotMatch": true,
+	"AccountProblem":        true,
+	//The aws sso login of an SSO profile is missing or has run out
+	ssocreds.ErrCodeSSOProviderInvalidToken: true,
+}
+
+//isFatal reports whether an error means the whole run should stop rather than retry
+func isFatal(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && fatalCodes[aerr.Code()]
+}
+
+//isNoSuchBucket reports whether the bucket disappeared under us, after which no request on it can succeed
+func isNoSuchBucket(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == s3.ErrCodeNoSuchBucket
+}
+
+//Cancelled by stopRun, every bucket's context derives from it
+var runCtx, cancelRun = context.WithCancel(context.Background())
+
+var (
+	runStopMu  sync.Mutex
+	runStopErr error
+)
+
+//stopRun cancels every request in every bucket after a fatal error. Only the first error is kept and reported.
+func stopRun(err error) {
+	runStopMu.Lock()
+	defer runStopMu.Unlock()
+	if runStopErr != nil {
+		return
+	}
+	runStopErr = err
+	ErrorLogger.Printf("Fatal error, stopping all deletes: %v\n", err)
+	cancelRun()
+}
+
+//runStopped returns the fatal error that stopped the run, or nil
+func runStopped() error {
+	runStopMu.Lock()
+	defer runStopMu.Unlock()
+	return runStopErr
+}
+
+//AccessDenied answers to deletes across the run, updated atomically
+var accessDeniedCount int64
+
+//checkAccessDenied counts a delete refused with AccessDenied. A few can be objects locked by policy, but more than
+//-max-access-denied means the credentials lack permissions for the run, so it is stopped instead of trying every object.
+func checkAccessDenied(err error) {
+	aerr, ok := err.(awserr.Error)
+	if !ok || (aerr.Code() != "AccessDenied" && statusCode(err) != http.StatusForbidden) {
+		return
+	}
+	n := atomic.AddInt64(&accessDeniedCount, 1)
+	if *maxAccessDenied > 0 && n > int64(*maxAccessDenied) {
+		stopRun(fmt.Errorf("insufficient permissions: more than %d deletes were denied (-max-access-denied), check the IAM policy allows s3:DeleteObject and s3:DeleteObjectVersion", *maxAccessDenied))
+	}
+}
+
+//isThrottle reports whether S3 asked us to slow down
+func isThrottle(err error) bool {
+	if request.IsErrorThrottle(err) {
+		return true
+	}
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "SlowDown" {
+		return true
+	}
+	if reqErr, ok := err.(awserr.RequestFailure); ok {
+		return reqErr.StatusCode() == http.StatusServiceUnavailable || reqErr.StatusCode() == http.StatusTooManyRequests
+	}
+	return false
+}
+
+//isRetryable reports whether an error is transient and worth another attempt
+func isRetryable(err error) bool {
+	if isThrottle(err) || request.IsErrorRetryable(err) {
+		return true
+	}
+	if reqErr, ok := err.(awserr.RequestFailure); ok {
+		return reqErr.StatusCode() >= http.StatusInternalServerError
+	}
+	return false
+}
+
+//retryDelete decides, in the backoff callback, whether a failed delete is tried again. With -retry-on-5xx=false
+//a 5xx answer that isn't throttling (503 SlowDown is) is taken as final, for backends that answer InternalError
+//to requests that will never succeed.
+func retryDelete(err error) bool {
+	if !*retryOn5xx && statusCode(err) >= http.StatusInternalServerError && !isThrottle(err) {
+		return false
+	}
+	return *retryAll || isRetryable(err)
+}
+
+func isBucketNotEmpty(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == "BucketNotEmpty"
+}
+
+//isPendingDeletion reports whether DeleteBucket ran into another operation on the bucket, such as a delete
+//already under way, which S3 answers with OperationAborted and some other stores with a bare 409
+func isPendingDeletion(err error) bool {
+	if err == nil || isBucketNotEmpty(err) {
+		return false
+	}
+	aerr, ok := err.(awserr.Error)
+	return ok && (aerr.Code() == "OperationAborted" || statusCode(err) == http.StatusConflict)
+}
+
+//statusCode returns the HTTP status of a failed request, or 0 if the error didn't come from a response
+func statusCode(err error) int {
+	if reqErr, ok := err.(awserr.RequestFailure); ok {
+		return reqErr.StatusCode()
+	}
+	return 0
+}
+
+//ErrBucketNotFound means the bucket doesn't exist, or not anywhere the credentials can see
+type ErrBucketNotFound struct {
+	Bucket string
+}
+
+func (e *ErrBucketNotFound) Error() string {
+	return fmt.Sprintf("bucket %s does not exist", e.Bucket)
+}
+
+//ErrAccessDenied means S3 refused a request on the bucket with a 403
+type ErrAccessDenied struct {
+	Bucket string
+	Err    error
+}
+
+func (e *ErrAccessDenied) Error() string {
+	return fmt.Sprintf("access denied to bucket %s: %v", e.Bucket, e.Err)
+}
+
+func (e *ErrAccessDenied) Unwrap() error {
+	return e.Err
+}
+
+//ErrBucketNotEmpty means DeleteBucket was refused because something is still in the bucket
+type ErrBucketNotEmpty struct {
+	Bucket string
+}
+
+func (e *ErrBucketNotEmpty) Error() string {
+	return fmt.Sprintf("bucket %s is not empty", e.Bucket)
+}
+
+//ErrPartialFailure means emptying finished but some entries could not be deleted
+type ErrPartialFailure struct {
+	Bucket     string
+	FailedKeys []string
+}
+
+func (e *ErrPartialFailure) Error() string {
+	return fmt.Sprintf("%d entries in %s could not be deleted", len(e.FailedKeys), e.Bucket)
+}
+
+//Exit codes for the typed errors, anything else exits 1
+const (
+	exitBucketNotFound = 2
+	exitAccessDenied   = 3
+	exitBucketNotEmpty = 4
+	exitPartialFailure = 5
+)
+
+//exitCode maps a bucket failure to the process exit status
+func exitCode(err error) int {
+	var notFound *ErrBucketNotFound
+	var denied *ErrAccessDenied
+	var notEmpty *ErrBucketNotEmpty
+	var partial *ErrPartialFailure
+	switch {
+	case errors.As(err, &notFound):
+		return exitBucketNotFound
+	case errors.As(err, &denied):
+		return exitAccessDenied
+	case errors.As(err, &notEmpty):
+		return exitBucketNotEmpty
+	case errors.As(err, &partial):
+		return exitPartialFailure
+	}
+	return 1
+}
+
+//bucketError wraps a failed bucket-level request in the matching typed error, if there is one
+func bucketError(bucket string, err error) error {
+	switch {
+	case isBucketNotEmpty(err):
+		return &ErrBucketNotEmpty{Bucket: bucket}
+	case statusCode(err) == http.StatusNotFound:
+		return &ErrBucketNotFound{Bucket: bucket}
+	case statusCode(err) == http.StatusForbidden:
+		return &ErrAccessDenied{Bucket: bucket, Err: err}
+	}
+	return err
+}
@@ -0,0 +1,65 @@
+package deleter
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"net/http"
+	"testing"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"not found", &ErrBucketNotFound{Bucket: "b"}, exitBucketNotFound},
+		{"access denied", &ErrAccessDenied{Bucket: "b", Err: errors.New("403")}, exitAccessDenied},
+		{"not empty", &ErrBucketNotEmpty{Bucket: "b"}, exitBucketNotEmpty},
+		{"partial failure", &ErrPartialFailure{Bucket: "b", FailedKeys: []string{"k"}}, exitPartialFailure},
+		{"wrapped", fmt.Errorf("unable to list versions of b: %w", &ErrBucketNotFound{Bucket: "b"}), exitBucketNotFound},
+		{"untyped", errors.New("timed out"), 1},
+		//ErrAccessDenied unwraps to what it wraps, and not found is checked first
+		{"denied wrapping not found", &ErrAccessDenied{Bucket: "b", Err: &ErrBucketNotFound{Bucket: "b"}}, exitBucketNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exitCode(tt.err); got != tt.want {
+				t.Errorf("exitCode(%v) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBucketError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"BucketNotEmpty", awserr.NewRequestFailure(awserr.New("BucketNotEmpty", "", nil), http.StatusConflict, ""), exitBucketNotEmpty},
+		{"404", awserr.NewRequestFailure(awserr.New("NoSuchBucket", "", nil), http.StatusNotFound, ""), exitBucketNotFound},
+		{"403", awserr.NewRequestFailure(awserr.New("AccessDenied", "", nil), http.StatusForbidden, ""), exitAccessDenied},
+		{"500", awserr.NewRequestFailure(awserr.New("InternalError", "", nil), http.StatusInternalServerError, ""), 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exitCode(bucketError("b", tt.err)); got != tt.want {
+				t.Errorf("exitCode(bucketError(%v)) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunExitCodeFirstFailure(t *testing.T) {
+	defer func() { failedBuckets = nil }()
+	if got := runExitCode(); got != 0 {
+		t.Fatalf("runExitCode() with no failures = %d, want 0", got)
+	}
+	recordBucketFailure("a", &ErrPartialFailure{Bucket: "a"})
+	recordBucketFailure("b", &ErrBucketNotFound{Bucket: "b"})
+	if got := runExitCode(); got != exitPartialFailure {
+		t.Errorf("runExitCode() = %d, want %d from the first failed bucket", got, exitPartialFailure)
+	}
+}
@@ -0,0 +1,92 @@
+package deleter
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"sync/atomic"
+	"time"
+)
+
+//estimateRun is -dry-run-sample: it really deletes the first -dry-run-sample entries of the bucket to measure
+//the delete rate, counts the rest with a listing-only pass, and logs how long the full teardown would take.
+//Nothing beyond the sample is deleted and the bucket is kept. It returns false if the bucket timed out.
+func (j *bucketJob) estimateRun() bool {
+	WarningLogger.Printf("PARTIAL DELETION: deleting a sample of up to %d entries from %s to estimate the run time\n", *estimateSample, j.name)
+
+	var sample []s3Entry
+	var total int64
+	listStart := time.Now()
+	err := j.svc.ListObjectVersionsPagesWithContext(j.ctx, &s3.ListObjectVersionsInput{
+		Bucket:  aws.String(j.name),
+		MaxKeys: aws.Int64(listPageSize),
+	},
+		func(page *s3.ListObjectVersionsOutput, lastPage bool) bool {
+			entries := append(markerEntries(page.DeleteMarkers), versionEntries(page.Versions)...)
+			total += int64(len(entries))
+			if want := int(*estimateSample) - len(sample); want > 0 {
+				kept := j.filterEntries(entries)
+				if len(kept) > want {
+					kept = kept[:want]
+				}
+				sample = append(sample, kept...)
+			}
+			return !lastPage
+		})
+	listTime := time.Since(listStart)
+	if j.timedOut() {
+		return false
+	}
+	if err != nil {
+		exitErrorf("Unable to count versions of %s: %v", j.name, err)
+	}
+
+	deleteStart := time.Now()
+	groupErr := j.dispatchEntries(sample).Wait()
+	deleteTime := time.Since(deleteStart)
+	if j.timedOut() {
+		return false
+	}
+	if groupErr != nil {
+		exitErrorf("Aborting %s: %v", j.name, groupErr)
+	}
+
+	deleted := atomic.LoadInt64(&j.stats.deleted)
+	if deleted == 0 || deleteTime <= 0 {
+		InfoLogger.Printf("%s has %d entries, nothing was deleted so no rate to estimate from\n", j.name, total)
+		return true
+	}
+	perSecond := float64(deleted) / deleteTime.Seconds()
+	remaining := total - deleted
+	estimate := listTime + time.Duration(float64(remaining)/perSecond*float64(time.Second))
+	InfoLogger.Printf("Sample of %d entries deleted from %s at %.0f/s with concurrency %d\n", deleted, j.name, perSecond, j.pool.Size())
+	InfoLogger.Printf("%d entries left (listing took %s), a full run would take about %s\n", remaining, listTime.Round(time.Second), estimate.Round(time.Second))
+	return true
+}
+
+//checkSizeGuard enforces -max-auto-delete-objects: a bucket holding more versions and delete markers than that
+//is probably not the one meant, so it is refused unless -force is set. Counting stops as soon as the limit is
+//passed, so a huge bucket costs at most limit/1000 listing requests. It returns an error if the bucket is refused.
+func (j *bucketJob) checkSizeGuard() error {
+	limit := *maxAutoDelete
+	var count int64
+	err := j.svc.ListObjectVersionsPagesWithContext(j.ctx, &s3.ListObjectVersionsInput{
+		Bucket:  aws.String(j.name),
+		MaxKeys: aws.Int64(listPageSize),
+	},
+		func(page *s3.ListObjectVersionsOutput, lastPage bool) bool {
+			count += int64(len(page.Versions) + len(page.DeleteMarkers))
+			return count <= limit && !lastPage
+		})
+	if err != nil {
+		return fmt.Errorf("unable to count entries for -max-auto-delete-objects: %v", err)
+	}
+	if count <= limit {
+		return nil
+	}
+	if *force {
+		WarningLogger.Printf("%s holds at least %d entries, over -max-auto-delete-objects %d, going ahead because of -force\n", j.name, count, limit)
+		return nil
+	}
+	return fmt.Errorf("holds at least %d entries, more than -max-auto-delete-objects %d, refusing without -force", count, limit)
+}
@@ -0,0 +1,491 @@
+package deleter
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//Maximum GetObjectTagging calls in flight while filtering a page by tag
+const tagLookupConcurrency = 16
+
+var (
+	tagKey   string
+	tagValue string
+
+	modifiedAfter  timeFlag
+	modifiedBefore timeFlag
+
+	//Delete markers last modified before this go with -skip-delete-markers-older-than
+	staleMarkerCutoff time.Time
+
+	//Key prefixes from -prefix and -prefix-file, deleted one after the other. Empty means the whole bucket.
+	keyPrefixes []string
+)
+
+//readPrefixFile reads a -prefix-file: one prefix per line, skipping blank lines and lines starting with #
+func readPrefixFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var prefixes []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		prefixes = append(prefixes, line)
+	}
+	return prefixes, scanner.Err()
+}
+
+//timeFlag is a timestamp flag taking RFC 3339 (2021-01-02T15:04:05Z) or a plain UTC date (2021-01-02)
+type timeFlag struct {
+	t time.Time
+}
+
+func (f *timeFlag) String() string {
+	if f.t.IsZero() {
+		return ""
+	}
+	return f.t.Format(time.RFC3339)
+}
+
+func (f *timeFlag) Set(value string) error {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		t, err = time.Parse("2006-01-02", value)
+	}
+	if err != nil {
+		return err
+	}
+	f.t = t
+	return nil
+}
+
+//filtering reports whether any filter that scopes the deletion to part of the bucket is set.
+//The bucket is never deleted while filtering, since it will not end up empty.
+func filtering() bool {
+	return tagFiltering() || sizeFiltering() || dateFiltering() || globFiltering() || len(keyPrefixes) > 0 || *keepVersions > 0 ||
+		*staleMarkerAge > 0
+}
+
+//activeFilters names the filters filtering reports, for saying why a bucket is kept
+func activeFilters() string {
+	var names []string
+	if len(keyPrefixes) > 0 {
+		names = append(names, "-prefix")
+	}
+	if globFiltering() {
+		names = append(names, "-include/-exclude")
+	}
+	if dateFiltering() {
+		names = append(names, "-modified-after/-modified-before")
+	}
+	if sizeFiltering() {
+		names = append(names, "-min-size/-max-size")
+	}
+	if tagKey != "" {
+		names = append(names, "-object-tag")
+	}
+	if *ttlTag != "" {
+		names = append(names, "-ttl-tag")
+	}
+	if *keepVersions > 0 {
+		names = append(names, "-keep-versions")
+	}
+	if *staleMarkerAge > 0 {
+		names = append(names, "-skip-delete-markers-older-than")
+	}
+	return strings.Join(names, ", ")
+}
+
+//tagFiltering reports whether entries need their tags looked up, for -object-tag or -ttl-tag
+func tagFiltering() bool {
+	return tagKey != "" || *ttlTag != ""
+}
+
+func dateFiltering() bool {
+	return !modifiedAfter.t.IsZero() || !modifiedBefore.t.IsZero()
+}
+
+func sizeFiltering() bool {
+	return *minSize > 0 || *maxSize > 0
+}
+
+//Zero-byte "folder/" placeholder versions deleted across the run, updated atomically
+var folderMarkers int64
+
+//isFolderMarker reports whether an entry is the zero-byte "prefix/" object the S3 console creates for a folder
+func isFolderMarker(entry s3Entry) bool {
+	return entry.Type != "Marker" && entry.Size == 0 && strings.HasSuffix(aws.StringValue(entry.Key), "/")
+}
+
+//filterEntries drops the entries of a page that the active filters exclude.
+//With -delete-empty-prefixes the folder markers the size, date and tag filters would keep are held back instead,
+//for deleteEmptyFolders to delete once the pass is over if nothing else in their folder was kept.
+func (j *bucketJob) filterEntries(entries []s3Entry) []s3Entry {
+	kept := entries[:0]
+	skips := map[string]int64{}
+	for _, entry := range entries {
+		//Objects left for the objects pass were already counted in the versions pass
+		counted := entry.Type != "Object"
+		if *staleMarkerAge > 0 && !j.isStaleMarker(entry) {
+			j.folders.keepBack(entry)
+			if counted {
+				skips["not a stale marker"]++
+			}
+			continue
+		}
+		if j.skipArchivedEntry(entry) {
+			j.folders.keepBack(entry)
+			if counted {
+				skips["class"]++
+			}
+			continue
+		}
+		if globFiltering() && !j.inGlobs(entry) {
+			j.folders.keepBack(entry)
+			if counted {
+				atomic.AddInt64(&j.stats.globSkipped, 1)
+				skips["include/exclude"]++
+			}
+			continue
+		}
+		if sizeFiltering() && !j.inSizeRange(entry) {
+			if !j.folders.hold(entry) && counted {
+				skips["size"]++
+			}
+			continue
+		}
+		if dateFiltering() && !j.inDateWindow(entry) {
+			if !j.folders.hold(entry) && counted {
+				skips["age"]++
+			}
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	if tagFiltering() {
+		kept = j.filterByTag(kept, skips)
+	}
+	recordFilterSkips(skips)
+	j.countFolderMarkers(kept)
+	return kept
+}
+
+//folderHold is -delete-empty-prefixes for one bucket: the folder markers held back from the filters, and every
+//folder the filters kept something in, whose marker has to stay for the console to go on showing that folder
+type folderHold struct {
+	mu       sync.Mutex
+	held     []s3Entry
+	nonEmpty map[string]bool
+}
+
+//hold takes a folder marker the filters would keep, reporting whether it did. Anything else is kept back.
+func (h *folderHold) hold(entry s3Entry) bool {
+	if !*deleteFolders {
+		return false
+	}
+	if !isFolderMarker(entry) {
+		h.keepBack(entry)
+		return false
+	}
+	h.mu.Lock()
+	h.held = append(h.held, entry)
+	h.mu.Unlock()
+	return true
+}
+
+//keepBack notes that the filters keep an entry, and so every folder above it
+func (h *folderHold) keepBack(entry s3Entry) {
+	if !*deleteFolders {
+		return
+	}
+	key := aws.StringValue(entry.Key)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.nonEmpty == nil {
+		h.nonEmpty = map[string]bool{}
+	}
+	//Up to the last character, so a folder marker doesn't keep its own folder
+	for i := 0; i < len(key)-1; i++ {
+		if key[i] == '/' {
+			h.nonEmpty[key[:i+1]] = true
+		}
+	}
+}
+
+//take returns the held folder markers of folders nothing was kept back in, and forgets every held marker
+func (h *folderHold) take() []s3Entry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var empty []s3Entry
+	for _, entry := range h.held {
+		if !h.nonEmpty[aws.StringValue(entry.Key)] {
+			empty = append(empty, entry)
+		}
+	}
+	h.held = nil
+	return empty
+}
+
+//deleteEmptyFolders ends a -delete-empty-prefixes pass over the bucket, deleting the held folder markers of the
+//folders it emptied. It returns the first fatal error, if any.
+func (j *bucketJob) deleteEmptyFolders() error {
+	empty := j.folders.take()
+	if len(empty) == 0 {
+		return nil
+	}
+	j.countFolderMarkers(empty)
+	if *dryRun {
+		return j.planEntries(empty).Wait()
+	}
+	return j.dispatchEntries(empty).Wait()
+}
+
+//Entries the filters kept back across the run, by filter, for -report-skipped
+var (
+	filterSkipsMu sync.Mutex
+	filterSkips   = map[string]int64{}
+)
+
+//recordFilterSkips adds a page's skipped entries to the run's
+func recordFilterSkips(skips map[string]int64) {
+	filterSkipsMu.Lock()
+	defer filterSkipsMu.Unlock()
+	for filter, n := range skips {
+		if n > 0 {
+			filterSkips[filter] += n
+		}
+	}
+}
+
+//filterSkipStats is a copy of the run's skip counts, nil when nothing was skipped
+func filterSkipStats() map[string]int64 {
+	filterSkipsMu.Lock()
+	defer filterSkipsMu.Unlock()
+	if len(filterSkips) == 0 {
+		return nil
+	}
+	stats := make(map[string]int64, len(filterSkips))
+	for filter, n := range filterSkips {
+		stats[filter] = n
+	}
+	return stats
+}
+
+//reportFilterSkips logs how many entries each filter kept back, most first, e.g. "120 by age, 45 by class".
+//Keys outside -prefix are never listed, so they don't show up here.
+func reportFilterSkips() {
+	skips := filterSkipStats()
+	if len(skips) == 0 {
+		InfoLogger.Printf("Skipped by filters: nothing\n")
+		return
+	}
+	filters := make([]string, 0, len(skips))
+	for filter := range skips {
+		filters = append(filters, filter)
+	}
+	sort.Slice(filters, func(a, b int) bool {
+		if skips[filters[a]] != skips[filters[b]] {
+			return skips[filters[a]] > skips[filters[b]]
+		}
+		return filters[a] < filters[b]
+	})
+	parts := make([]string, len(filters))
+	for i, filter := range filters {
+		parts[i] = fmt.Sprintf("%d by %s", skips[filter], filter)
+	}
+	InfoLogger.Printf("Skipped by filters: %s\n", strings.Join(parts, ", "))
+}
+
+//countFolderMarkers counts the folder markers about to be deleted. Objects were already counted as versions.
+func (j *bucketJob) countFolderMarkers(entries []s3Entry) {
+	for _, entry := range entries {
+		if isFolderMarker(entry) && entry.Type != "Object" {
+			atomic.AddInt64(&j.stats.folderMarkers, 1)
+			atomic.AddInt64(&folderMarkers, 1)
+		}
+	}
+}
+
+func isArchived(storageClass *string) bool {
+	switch aws.StringValue(storageClass) {
+	case s3.StorageClassGlacier, s3.StorageClassDeepArchive:
+		return true
+	}
+	return false
+}
+
+func (j *bucketJob) skipArchivedEntry(entry s3Entry) bool {
+	if !*skipArchived || !isArchived(entry.StorageClass) {
+		return false
+	}
+	//Archived objects were already counted and reported by the versions pass
+	if entry.Type != "Object" {
+		atomic.AddInt64(&j.stats.archivedSkipped, 1)
+		if *verbosity {
+			InfoLogger.Printf("Skipping archived %s (%s)\n", *entry.Key, *entry.StorageClass)
+		}
+	}
+	return true
+}
+
+//inSizeRange checks an entry against -min-size/-max-size. Delete markers have no size and never match.
+func (j *bucketJob) inSizeRange(entry s3Entry) bool {
+	if entry.Type == "Marker" {
+		return false
+	}
+	inRange := entry.Size >= *minSize && (*maxSize <= 0 || entry.Size <= *maxSize)
+	//Objects left for the objects pass were already counted in the versions pass
+	if entry.Type != "Object" {
+		if inRange {
+			atomic.AddInt64(&j.stats.sizeInRange, 1)
+			atomic.AddInt64(&j.stats.sizeInRangeBytes, entry.Size)
+		} else {
+			atomic.AddInt64(&j.stats.sizeOutOfRange, 1)
+			atomic.AddInt64(&j.stats.sizeOutOfRangeBytes, entry.Size)
+		}
+	}
+	return inRange
+}
+
+//inDateWindow checks an entry's LastModified against -modified-after (inclusive) and -modified-before (exclusive).
+//Delete markers are left alone, like with the size filter.
+func (j *bucketJob) inDateWindow(entry s3Entry) bool {
+	if entry.Type == "Marker" || entry.LastModified == nil {
+		return false
+	}
+	modified := *entry.LastModified
+	inWindow := !modified.Before(modifiedAfter.t) && (modifiedBefore.t.IsZero() || modified.Before(modifiedBefore.t))
+	if inWindow && entry.Type != "Object" {
+		atomic.AddInt64(&j.stats.inDateWindow, 1)
+	}
+	return inWindow
+}
+
+//isStaleMarker reports whether an entry is a delete marker -skip-delete-markers-older-than prunes
+func (j *bucketJob) isStaleMarker(entry s3Entry) bool {
+	if entry.Type != "Marker" || entry.LastModified == nil || !entry.LastModified.Before(staleMarkerCutoff) {
+		return false
+	}
+	atomic.AddInt64(&j.stats.staleMarkers, 1)
+	return true
+}
+
+//Why filterByTag drops an entry
+const (
+	tagKeep = iota
+	tagNoMatch
+	tagUnexpired
+)
+
+//filterByTag keeps the entries tagged with -object-tag and expired by -ttl-tag, looking the tags up once for both.
+//Delete markers carry no tags and are always dropped. What it drops is added to the page's skips.
+func (j *bucketJob) filterByTag(entries []s3Entry, skips map[string]int64) []s3Entry {
+	matches := make([]int, len(entries))
+	for i := range matches {
+		matches[i] = tagNoMatch
+	}
+	sem := make(chan struct{}, tagLookupConcurrency)
+	var wg sync.WaitGroup
+	for i, entry := range entries {
+		if entry.Type == "Marker" {
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, entry s3Entry) {
+			defer wg.Done()
+			matches[i] = j.matchTags(entry)
+			<-sem
+		}(i, entry)
+	}
+	wg.Wait()
+
+	kept := entries[:0]
+	for i, entry := range entries {
+		if matches[i] != tagKeep {
+			if j.folders.hold(entry) {
+				continue
+			}
+			//Objects left for the objects pass were already counted in the versions pass
+			if entry.Type != "Object" {
+				if matches[i] == tagUnexpired {
+					atomic.AddInt64(&j.stats.ttlUnexpired, 1)
+					skips["ttl tag"]++
+				} else {
+					atomic.AddInt64(&j.stats.tagSkipped, 1)
+					skips["tag"]++
+				}
+			}
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	return kept
+}
+
+//matchTags fetches an entry's tags and checks them against -object-tag and -ttl-tag.
+//An entry whose tags can't be read is dropped, as is one without the -ttl-tag or with one that doesn't parse.
+//The lookups count against -rate like the deletes do.
+func (j *bucketJob) matchTags(entry s3Entry) int {
+	if limiter != nil {
+		if err := limiter.Wait(j.ctx); err != nil {
+			return tagNoMatch
+		}
+	}
+	tags, err := j.svc.GetObjectTaggingWithContext(j.ctx, &s3.GetObjectTaggingInput{
+		Bucket:    aws.String(j.name),
+		Key:       entry.Key,
+		VersionId: entry.VersionId,
+	})
+	if err != nil {
+		WarningLogger.Printf("Unable to get tags for %s: %v\n", *entry.Key, err)
+		return tagNoMatch
+	}
+	if tagKey != "" && !hasTag(tags.TagSet, tagKey, tagValue) {
+		return tagNoMatch
+	}
+	if *ttlTag != "" && !j.expired(entry, tags.TagSet) {
+		return tagUnexpired
+	}
+	return tagKeep
+}
+
+func hasTag(tags []*s3.Tag, key string, value string) bool {
+	for _, tag := range tags {
+		if aws.StringValue(tag.Key) == key && aws.StringValue(tag.Value) == value {
+			return true
+		}
+	}
+	return false
+}
+
+//expired reports whether the entry's -ttl-tag timestamp is in the past. Entries without the tag never expire.
+func (j *bucketJob) expired(entry s3Entry, tags []*s3.Tag) bool {
+	for _, tag := range tags {
+		if aws.StringValue(tag.Key) != *ttlTag {
+			continue
+		}
+		expiresAt, err := time.Parse(time.RFC3339, aws.StringValue(tag.Value))
+		if err != nil {
+			WarningLogger.Printf("Keeping %s: %s tag %q is not an RFC 3339 timestamp\n", *entry.Key, *ttlTag, aws.StringValue(tag.Value))
+			return false
+		}
+		return expiresAt.Before(time.Now())
+	}
+	return false
+}
@@ -0,0 +1,222 @@
+package deleter
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"golang.org/x/time/rate"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDeleteEmptyFolders(t *testing.T) {
+	setFlags(t, map[string]string{"min-size": "10", "delete-empty-prefixes": "true"})
+	f := newFakeS3("demo", 0)
+	modified := time.Now().Add(-time.Hour)
+	for key, size := range map[string]int64{
+		"kept/":            0,
+		"kept/small.dat":   5,
+		"emptied/":         0,
+		"emptied/big.dat":  50,
+		"bare/":            0,
+		"nested/":          0,
+		"nested/in/":       0,
+		"nested/in/small":  1,
+		"nested/other/":    0,
+		"nested/other/big": 100,
+	} {
+		f.addVersion(key, fakeVersion{size: size, lastModified: modified})
+	}
+	j := newTestJob(t, f)
+	if err := j.deleteAllVersions(); err != nil {
+		t.Fatal(err)
+	}
+	left := f.sortedKeys("")
+	sort.Strings(left)
+	want := []string{"kept/", "kept/small.dat", "nested/", "nested/in/", "nested/in/small"}
+	if len(left) != len(want) {
+		t.Fatalf("left %q, want %q", left, want)
+	}
+	for i := range want {
+		if left[i] != want[i] {
+			t.Fatalf("left %q, want %q", left, want)
+		}
+	}
+	if j.stats.folderMarkers != 3 {
+		t.Errorf("%d folder markers counted, want 3", j.stats.folderMarkers)
+	}
+}
+
+//taggedS3 serves each key's tags from a map, counting the lookups
+type taggedS3 struct {
+	*fakeS3
+	tags    map[string]map[string]string
+	lookups int64
+}
+
+func (f *taggedS3) GetObjectTaggingWithContext(ctx aws.Context, input *s3.GetObjectTaggingInput, opts ...request.Option) (*s3.GetObjectTaggingOutput, error) {
+	atomic.AddInt64(&f.lookups, 1)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	out := &s3.GetObjectTaggingOutput{}
+	for key, value := range f.tags[aws.StringValue(input.Key)] {
+		out.TagSet = append(out.TagSet, &s3.Tag{Key: aws.String(key), Value: aws.String(value)})
+	}
+	return out, nil
+}
+
+func testVersion(key string, size int64, modified time.Time) s3Entry {
+	return s3Entry{Key: aws.String(key), VersionId: aws.String("v-" + key), Size: size, LastModified: &modified, Type: "Version"}
+}
+
+func testMarker(key string, modified time.Time) s3Entry {
+	return s3Entry{Key: aws.String(key), VersionId: aws.String("m-" + key), LastModified: &modified, Type: "Marker"}
+}
+
+//setObjectTag sets -object-tag key=value the way main parses it
+func setObjectTag(t *testing.T, key, value string) {
+	tagKey, tagValue = key, value
+	t.Cleanup(func() { tagKey, tagValue = "", "" })
+}
+
+//setDateWindow sets -modified-after and -modified-before, a zero time leaving that bound off
+func setDateWindow(t *testing.T, after, before time.Time) {
+	modifiedAfter.t, modifiedBefore.t = after, before
+	t.Cleanup(func() { modifiedAfter.t, modifiedBefore.t = time.Time{}, time.Time{} })
+}
+
+//setGlobs sets -include and -exclude as if each glob had been given as a flag
+func setGlobs(t *testing.T, include, exclude []string) {
+	t.Helper()
+	for _, glob := range include {
+		if err := (&globFlag{compiled: &includeGlobs}).Set(glob); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, glob := range exclude {
+		if err := (&globFlag{compiled: &excludeGlobs}).Set(glob); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() { includeGlobs, excludeGlobs = nil, nil })
+}
+
+func TestFilterEntries(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name  string
+		flags map[string]string
+		//setup sets what isn't a plain flag, undoing it with t.Cleanup
+		setup   func(t *testing.T)
+		tags    map[string]map[string]string
+		entries []s3Entry
+		want    []string
+	}{
+		{
+			name:  "object-tag",
+			setup: func(t *testing.T) { setObjectTag(t, "env", "ci") },
+			tags: map[string]map[string]string{
+				"ci":   {"env": "ci", "team": "a"},
+				"prod": {"env": "prod"},
+			},
+			entries: []s3Entry{testVersion("ci", 1, now), testVersion("prod", 1, now), testVersion("untagged", 1, now), testMarker("ci", now)},
+			want:    []string{"ci"},
+		},
+		{
+			name:  "ttl-tag",
+			flags: map[string]string{"ttl-tag": "expires"},
+			tags: map[string]map[string]string{
+				"expired":  {"expires": now.Add(-time.Hour).Format(time.RFC3339)},
+				"future":   {"expires": now.Add(time.Hour).Format(time.RFC3339)},
+				"garbled":  {"expires": "tomorrow"},
+				"other":    {"team": "a"},
+				"untagged": nil,
+			},
+			entries: []s3Entry{testVersion("expired", 1, now), testVersion("future", 1, now), testVersion("garbled", 1, now), testVersion("other", 1, now), testVersion("untagged", 1, now)},
+			want:    []string{"expired"},
+		},
+		{
+			name:    "min-size and max-size are inclusive",
+			flags:   map[string]string{"min-size": "10", "max-size": "20"},
+			entries: []s3Entry{testVersion("9", 9, now), testVersion("10", 10, now), testVersion("20", 20, now), testVersion("21", 21, now), testMarker("10", now)},
+			want:    []string{"10", "20"},
+		},
+		{
+			name:    "min-size alone",
+			flags:   map[string]string{"min-size": "1"},
+			entries: []s3Entry{testVersion("empty", 0, now), testVersion("big", 1 << 40, now)},
+			want:    []string{"big"},
+		},
+		{
+			name:  "modified-after inclusive, modified-before exclusive",
+			setup: func(t *testing.T) { setDateWindow(t, now.Add(-2*time.Hour), now) },
+			entries: []s3Entry{
+				testVersion("too old", 1, now.Add(-3*time.Hour)),
+				testVersion("at after", 1, now.Add(-2*time.Hour)),
+				testVersion("inside", 1, now.Add(-time.Hour)),
+				testVersion("at before", 1, now),
+				testMarker("inside", now.Add(-time.Hour)),
+			},
+			want: []string{"at after", "inside"},
+		},
+		{
+			name:    "modified-before alone",
+			setup:   func(t *testing.T) { setDateWindow(t, time.Time{}, now) },
+			entries: []s3Entry{testVersion("old", 1, time.Unix(0, 0)), testVersion("new", 1, now.Add(time.Minute))},
+			want:    []string{"old"},
+		},
+		{
+			name:    "include matches across /, exclude wins",
+			setup:   func(t *testing.T) { setGlobs(t, []string{"*.log", "data/??.csv"}, []string{"keep/*"}) },
+			entries: []s3Entry{testVersion("a.log", 1, now), testVersion("logs/2021/b.log", 1, now), testVersion("keep/c.log", 1, now), testVersion("c.txt", 1, now), testVersion("data/01.csv", 1, now), testVersion("data/001.csv", 1, now)},
+			want:    []string{"a.log", "logs/2021/b.log", "data/01.csv"},
+		},
+		{
+			name:    "exclude alone, with a set",
+			setup:   func(t *testing.T) { setGlobs(t, nil, []string{"tmp/[!k]*"}) },
+			entries: []s3Entry{testVersion("tmp/a", 1, now), testVersion("tmp/keep", 1, now), testVersion("other", 1, now)},
+			want:    []string{"tmp/keep", "other"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlags(t, tt.flags)
+			if tt.setup != nil {
+				tt.setup(t)
+			}
+			j := newTestJob(t, &taggedS3{fakeS3: newFakeS3("demo", 0), tags: tt.tags})
+			var got []string
+			for _, entry := range j.filterEntries(tt.entries) {
+				got = append(got, aws.StringValue(entry.Key))
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("kept %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchTagsWaitsOnLimiter(t *testing.T) {
+	setFlags(t, map[string]string{"ttl-tag": "expires"})
+	limiter = rate.NewLimiter(1, 1)
+	defer func() { limiter = nil }()
+	f := &taggedS3{fakeS3: newFakeS3("demo", 0)}
+	j := newTestJob(t, f)
+	j.cancel()
+	if got := j.matchTags(testVersion("k", 1, time.Now())); got != tagNoMatch {
+		t.Errorf("matchTags on a stopped run = %d, want tagNoMatch", got)
+	}
+	if f.lookups != 0 {
+		t.Errorf("%d GetObjectTagging calls after the limiter gave up, want 0", f.lookups)
+	}
+}
+
+func TestCompileGlobUnclosedSet(t *testing.T) {
+	if _, err := compileGlob("logs/[ab"); err == nil {
+		t.Error("compileGlob accepted an unclosed [")
+	}
+}
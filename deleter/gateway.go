@@ -0,0 +1,62 @@
+package deleter
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+//newS3Client is the client a bucket's work goes through: plain S3, or split with -gateway-url
+func newS3Client(sess *session.Session) s3iface.S3API {
+	svc := s3.New(sess)
+	if *gatewayURL == "" {
+		return svc
+	}
+	//Gateways route on the path, like the S3-compatible stores -endpoint-url is for
+	gateway := s3.New(sess, &aws.Config{
+		Endpoint:         aws.String(*gatewayURL),
+		S3ForcePathStyle: aws.Bool(true),
+	})
+	return &gatewayS3{S3API: svc, gateway: gateway}
+}
+
+//deleteClient is the client deletes go out through, which differs from svc with -gateway-url
+func deleteClient(svc s3iface.S3API) s3iface.S3API {
+	if g, ok := svc.(*gatewayS3); ok {
+		return g.gateway
+	}
+	return svc
+}
+
+//gatewayS3 sends the calls that list and delete objects and versions to -gateway-url, signed for the bucket's
+//region, and everything else, such as HeadBucket, the versioning calls and DeleteBucket, to S3 as usual.
+type gatewayS3 struct {
+	s3iface.S3API
+	gateway s3iface.S3API
+}
+
+func (g *gatewayS3) ListObjectVersionsWithContext(ctx aws.Context, input *s3.ListObjectVersionsInput, opts ...request.Option) (*s3.ListObjectVersionsOutput, error) {
+	return g.gateway.ListObjectVersionsWithContext(ctx, input, opts...)
+}
+
+func (g *gatewayS3) ListObjectVersionsPagesWithContext(ctx aws.Context, input *s3.ListObjectVersionsInput, fn func(*s3.ListObjectVersionsOutput, bool) bool, opts ...request.Option) error {
+	return g.gateway.ListObjectVersionsPagesWithContext(ctx, input, fn, opts...)
+}
+
+func (g *gatewayS3) ListObjectsV2WithContext(ctx aws.Context, input *s3.ListObjectsV2Input, opts ...request.Option) (*s3.ListObjectsV2Output, error) {
+	return g.gateway.ListObjectsV2WithContext(ctx, input, opts...)
+}
+
+func (g *gatewayS3) ListObjectsV2PagesWithContext(ctx aws.Context, input *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool, opts ...request.Option) error {
+	return g.gateway.ListObjectsV2PagesWithContext(ctx, input, fn, opts...)
+}
+
+func (g *gatewayS3) DeleteObjectWithContext(ctx aws.Context, input *s3.DeleteObjectInput, opts ...request.Option) (*s3.DeleteObjectOutput, error) {
+	return g.gateway.DeleteObjectWithContext(ctx, input, opts...)
+}
+
+func (g *gatewayS3) DeleteObjectsWithContext(ctx aws.Context, input *s3.DeleteObjectsInput, opts ...request.Option) (*s3.DeleteObjectsOutput, error) {
+	return g.gateway.DeleteObjectsWithContext(ctx, input, opts...)
+}
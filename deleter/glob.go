@@ -0,0 +1,85 @@
+package deleter
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"regexp"
+	"strings"
+)
+
+//Compiled -include and -exclude globs
+var includeGlobs, excludeGlobs []*regexp.Regexp
+
+//globFlag is a key glob flag that can be repeated. * matches any run of characters, / included, so *.log matches
+//logs at any depth; ? matches one character and [abc] one of a set.
+type globFlag struct {
+	patterns []string
+	compiled *[]*regexp.Regexp
+}
+
+func (f *globFlag) String() string {
+	return strings.Join(f.patterns, ",")
+}
+
+func (f *globFlag) Set(value string) error {
+	re, err := compileGlob(value)
+	if err != nil {
+		return err
+	}
+	f.patterns = append(f.patterns, value)
+	*f.compiled = append(*f.compiled, re)
+	return nil
+}
+
+//compileGlob turns a key glob into an anchored regexp
+func compileGlob(glob string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed [ in %q", glob)
+			}
+			set := glob[i+1 : i+1+end]
+			if strings.HasPrefix(set, "!") {
+				set = "^" + set[1:]
+			}
+			b.WriteString("[" + strings.Replace(set, `\`, `\\`, -1) + "]")
+			i += end + 1
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+func globFiltering() bool {
+	return len(includeGlobs) > 0 || len(excludeGlobs) > 0
+}
+
+//inGlobs reports whether an entry's key is selected by -include and -exclude: it has to match one -include
+//glob, when any are given, and no -exclude glob. Exclude wins when a key matches both.
+func (j *bucketJob) inGlobs(entry s3Entry) bool {
+	key := aws.StringValue(entry.Key)
+	for _, re := range excludeGlobs {
+		if re.MatchString(key) {
+			return false
+		}
+	}
+	if len(includeGlobs) == 0 {
+		return true
+	}
+	for _, re := range includeGlobs {
+		if re.MatchString(key) {
+			return true
+		}
+	}
+	return false
+}
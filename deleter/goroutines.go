@@ -0,0 +1,58 @@
+package deleter
+
+import (
+	"runtime"
+	"sync/atomic"
+)
+
+//Slots for -max-goroutines, nil when there is no ceiling
+var goroutineSlots chan struct{}
+
+//Delete goroutines alive now and at most, and the most goroutines in the process seen, updated atomically
+var (
+	liveDeletes     int64
+	peakDeletes     int64
+	peakGoroutines  int64
+	goroutineCapHit int32
+)
+
+//acquireGoroutine is called before starting a goroutine that deletes, and blocks while -max-goroutines of them
+//are alive. It is taken before a -workers-per-page or pool slot, in that order everywhere, so nothing waits on
+//a goroutine slot while holding a worker. The pool normally keeps the count well below the ceiling; hitting it
+//means -concurrency is set higher than the host can take, and is warned about once.
+func acquireGoroutine() {
+	if goroutineSlots != nil {
+		select {
+		case goroutineSlots <- struct{}{}:
+		default:
+			if atomic.CompareAndSwapInt32(&goroutineCapHit, 0, 1) {
+				WarningLogger.Printf("Reached -max-goroutines %d, holding back further deletes until some finish\n", cap(goroutineSlots))
+			}
+			goroutineSlots <- struct{}{}
+		}
+	}
+	raisePeak(&peakDeletes, atomic.AddInt64(&liveDeletes, 1))
+	raisePeak(&peakGoroutines, int64(runtime.NumGoroutine()))
+}
+
+//releaseGoroutine is deferred in the goroutine acquireGoroutine was called for
+func releaseGoroutine() {
+	atomic.AddInt64(&liveDeletes, -1)
+	if goroutineSlots != nil {
+		<-goroutineSlots
+	}
+}
+
+func raisePeak(peak *int64, n int64) {
+	for {
+		old := atomic.LoadInt64(peak)
+		if n <= old || atomic.CompareAndSwapInt64(peak, old, n) {
+			return
+		}
+	}
+}
+
+//reportGoroutines logs the peaks for -v
+func reportGoroutines() {
+	InfoLogger.Printf("Peak of %d delete goroutines, %d goroutines in all\n", atomic.LoadInt64(&peakDeletes), atomic.LoadInt64(&peakGoroutines))
+}
@@ -0,0 +1,72 @@
+package deleter
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"sync/atomic"
+)
+
+//Upper bounds of the -histogram size buckets, the last bucket takes everything larger
+var sizeBounds = []int64{1 << 10, 1 << 20, 100 << 20}
+var sizeLabels = []string{"< 1KB", "< 1MB", "< 100MB", ">= 100MB"}
+
+//Upper bounds of the -histogram versions-per-key buckets
+var versionBounds = []int{1, 5, 10, 100}
+var versionLabels = []string{"1", "2-5", "6-10", "11-100", "> 100"}
+
+//Counts across the run, updated atomically
+var (
+	sizeHistogram    [4]int64
+	versionHistogram [5]int64
+)
+
+//keyVersions counts the versions of the key the listing is on. Listings are in key order, so a key is
+//complete once a different one shows up and only one count is held at a time, however big the bucket.
+type keyVersions struct {
+	key   string
+	count int
+}
+
+//countVersions adds a listing page's versions to the -histogram counts
+func (j *bucketJob) countVersions(versions []*s3.ObjectVersion) {
+	for _, v := range versions {
+		size := aws.Int64Value(v.Size)
+		i := 0
+		for i < len(sizeBounds) && size >= sizeBounds[i] {
+			i++
+		}
+		atomic.AddInt64(&sizeHistogram[i], 1)
+
+		key := aws.StringValue(v.Key)
+		if key != j.keyVersions.key {
+			j.flushKeyVersions()
+			j.keyVersions.key = key
+		}
+		j.keyVersions.count++
+	}
+}
+
+//flushKeyVersions counts the key the listing was on, at the end of the listing or when it moves on
+func (j *bucketJob) flushKeyVersions() {
+	if j.keyVersions.count == 0 {
+		return
+	}
+	i := 0
+	for i < len(versionBounds) && j.keyVersions.count > versionBounds[i] {
+		i++
+	}
+	atomic.AddInt64(&versionHistogram[i], 1)
+	j.keyVersions = keyVersions{}
+}
+
+//reportHistograms logs the -histogram counts with the summary
+func reportHistograms() {
+	InfoLogger.Print("Version sizes:\n")
+	for i, label := range sizeLabels {
+		InfoLogger.Printf("  %-9s %d\n", label, atomic.LoadInt64(&sizeHistogram[i]))
+	}
+	InfoLogger.Print("Versions per key:\n")
+	for i, label := range versionLabels {
+		InfoLogger.Printf("  %-9s %d\n", label, atomic.LoadInt64(&versionHistogram[i]))
+	}
+}
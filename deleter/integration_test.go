@@ -0,0 +1,117 @@
+//go:build integration
+// +build integration
+
+package deleter
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+//The integration tests run against the S3-compatible endpoint in DELETES3BUCKET_ENDPOINT_URL, LocalStack's by default
+const defaultIntegrationEndpoint = "http://localhost:4566"
+
+//integrationClient is an S3 client for the endpoint, built by newSession the way a -endpoint-url run builds one
+func integrationClient(t *testing.T) s3iface.S3API {
+	t.Helper()
+	endpoint := os.Getenv("DELETES3BUCKET_ENDPOINT_URL")
+	if endpoint == "" {
+		endpoint = defaultIntegrationEndpoint
+	}
+	setFlags(t, map[string]string{"endpoint-url": endpoint})
+	//LocalStack takes any credentials, but the SDK wants some
+	for name, value := range map[string]string{"AWS_ACCESS_KEY_ID": "test", "AWS_SECRET_ACCESS_KEY": "test"} {
+		if _, set := os.LookupEnv(name); !set {
+			os.Setenv(name, value)
+			name := name
+			t.Cleanup(func() { os.Unsetenv(name) })
+		}
+	}
+	sess, err := newSession("us-east-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newS3Client(sess)
+}
+
+//createVersionedBucket creates a versioned bucket holding keys objects, each with versions versions and every
+//other one deleted, so delete markers are left too
+func createVersionedBucket(t *testing.T, svc s3iface.S3API, keys, versions int) string {
+	t.Helper()
+	bucket := fmt.Sprintf("deletes3bucket-it-%d", time.Now().UnixNano())
+	if _, err := svc.CreateBucket(&s3.CreateBucketInput{Bucket: aws.String(bucket)}); err != nil {
+		t.Fatalf("unable to create %s, is %s up? %v", bucket, *endpointURL, err)
+	}
+	_, err := svc.PutBucketVersioning(&s3.PutBucketVersioningInput{
+		Bucket:                  aws.String(bucket),
+		VersioningConfiguration: &s3.VersioningConfiguration{Status: aws.String(s3.BucketVersioningStatusEnabled)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < keys; i++ {
+		key := fmt.Sprintf("dir/%03d/key-%d", i%10, i)
+		for v := 0; v < versions; v++ {
+			_, err := svc.PutObject(&s3.PutObjectInput{
+				Bucket: aws.String(bucket),
+				Key:    aws.String(key),
+				Body:   bytes.NewReader([]byte(fmt.Sprintf("%s version %d", key, v))),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if i%2 == 0 {
+			if _, err := svc.DeleteObject(&s3.DeleteObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return bucket
+}
+
+func TestIntegrationDeleteBucket(t *testing.T) {
+	tests := []struct {
+		name  string
+		flags map[string]string
+	}{
+		{"one delete per version", nil},
+		{"batch", map[string]string{"batch": "true", "include-versioned-batch": "true"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlags(t, tt.flags)
+			svc := integrationClient(t)
+			bucket := createVersionedBucket(t, svc, 25, 3)
+			defer func() { failedBuckets = nil }()
+
+			j := newTestJob(t, svc)
+			j.name, j.region = bucket, "us-east-1"
+			if err := j.preflight(); err != nil {
+				t.Fatal(err)
+			}
+			if err := j.deleteAllVersions(); err != nil {
+				t.Fatal(err)
+			}
+			j.finishEmptied()
+			if len(failedBuckets) > 0 {
+				t.Fatalf("%s failed: %v", bucket, failedBuckets)
+			}
+			if deleted := j.stats.deleted; deleted != 25*3+13 {
+				t.Errorf("deleted %d versions and markers, want %d", deleted, 25*3+13)
+			}
+			_, err := svc.HeadBucket(&s3.HeadBucketInput{Bucket: aws.String(bucket)})
+			if failure, ok := err.(awserr.RequestFailure); !ok || failure.StatusCode() != http.StatusNotFound {
+				t.Errorf("HeadBucket of %s after the run = %v, want a 404", bucket, err)
+			}
+		})
+	}
+}
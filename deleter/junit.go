@@ -0,0 +1,71 @@
+package deleter
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+//junitSuite is the JUnit XML -junit-out writes: one test case per bucket, which CI dashboards show like any test run
+type junitSuite struct {
+	XMLName  xml.Name    `xml:"testsuite"`
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Skipped  int         `xml:"skipped,attr"`
+	Time     float64     `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+//writeJUnit writes the run summary to path as JUnit XML. A bucket passes when it had no errors, fails with them
+//as the message otherwise, and is skipped when it was left alone on purpose. A run stopped by a fatal error gets
+//a failing case of its own, so it can't show as green when no bucket got far enough to fail.
+func writeJUnit(path string, start time.Time) error {
+	stats := summaryStats(start)
+	suite := junitSuite{Name: "deleteS3bucket", Time: stats.ElapsedSeconds}
+	for _, bucket := range stats.Buckets {
+		c := junitCase{
+			Name:      bucket.Name,
+			ClassName: "deleteS3bucket." + bucket.Region,
+			SystemOut: fmt.Sprintf("deleted %d, failed keys %d, bucket deleted %t", bucket.Deleted, bucket.FailedKeys, bucket.BucketDeleted),
+		}
+		switch {
+		case len(bucket.Errors) > 0:
+			c.Failure = &junitMessage{Message: bucket.Errors[0], Text: strings.Join(bucket.Errors, "\n")}
+			suite.Failures++
+		case bucket.Skipped != "":
+			c.Skipped = &junitMessage{Message: bucket.Skipped}
+			suite.Skipped++
+		}
+		suite.Cases = append(suite.Cases, c)
+	}
+	if stats.Error != "" {
+		suite.Cases = append(suite.Cases, junitCase{
+			Name:      "run",
+			ClassName: "deleteS3bucket",
+			Failure:   &junitMessage{Message: stats.Error, Text: stats.Error},
+		})
+		suite.Failures++
+	}
+	suite.Tests = len(suite.Cases)
+	data, err := xml.MarshalIndent(suite, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append([]byte(xml.Header), append(data, '\n')...), 0644)
+}
@@ -0,0 +1,82 @@
+package deleter
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"golang.org/x/sync/errgroup"
+	"sort"
+)
+
+//perKeyOrder reports whether -order sequences each key's deletes rather than the page's
+func perKeyOrder() bool {
+	return *order == "per-key-versions-first" || *order == "per-key-markers-first"
+}
+
+//groupByKey splits entries into one group per key, in the order the keys first appear, with each key's versions
+//before its delete markers for per-key-versions-first and after them for per-key-markers-first. Versions keep
+//their listing order, newest first, within the group.
+func groupByKey(entries []s3Entry) [][]s3Entry {
+	index := make(map[string]int)
+	var groups [][]s3Entry
+	for _, entry := range entries {
+		key := aws.StringValue(entry.Key)
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], entry)
+	}
+	markersFirst := *order == "per-key-markers-first"
+	for _, group := range groups {
+		sort.SliceStable(group, func(a, b int) bool {
+			aMarker, bMarker := group[a].Type == "Marker", group[b].Type == "Marker"
+			if markersFirst {
+				return aMarker && !bMarker
+			}
+			return !aMarker && bMarker
+		})
+	}
+	return groups
+}
+
+//dispatchPerKey is dispatchEntries for the per-key orders: different keys are deleted concurrently as usual,
+//but each key's deletes are made one after another by a single worker, so none starts before the one before it
+//has finished. A delete that fails doesn't stop the rest of its key; the failure is recorded as usual.
+func (j *bucketJob) dispatchPerKey(entries []s3Entry) *errgroup.Group {
+	g, ctx := errgroup.WithContext(j.ctx)
+	perPage := newPageLimit()
+	for _, group := range groupByKey(entries) {
+		if ctx.Err() != nil {
+			break
+		}
+		group := group
+		acquireGoroutine()
+		perPage.acquire()
+		g.Go(func() error {
+			remaining := group
+			defer j.recoverRemaining(&remaining)
+			defer releaseGoroutine()
+			defer perPage.release()
+			for _, entry := range group {
+				if ctx.Err() != nil {
+					return nil
+				}
+				input := s3.DeleteObjectInput{
+					Key:       entry.Key,
+					VersionId: entry.VersionId,
+					Bucket:    aws.String(j.name),
+					MFA:       j.mfa,
+				}
+				j.pool.acquire()
+				if err := j.deleteS3Object(ctx, input, entry); err != nil {
+					return err
+				}
+				remaining = remaining[1:]
+			}
+			return nil
+		})
+	}
+	return g
+}
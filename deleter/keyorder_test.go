@@ -0,0 +1,95 @@
+package deleter
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGroupByKey(t *testing.T) {
+	now := time.Now()
+	//Listing order: newest first within each key, keys interleaved the way versions and markers pages mix them
+	entries := []s3Entry{
+		testMarker("a", now),
+		testVersion("b", 1, now),
+		testVersion("a", 1, now.Add(-time.Minute)),
+		testVersion("a", 1, now.Add(-2*time.Minute)),
+		testMarker("b", now.Add(-time.Hour)),
+		testVersion("c", 1, now),
+	}
+	entries[3].VersionId = aws.String("v-a-older")
+	tests := []struct {
+		order string
+		want  [][]string
+	}{
+		{"per-key-versions-first", [][]string{{"v-a", "v-a-older", "m-a"}, {"v-b", "m-b"}, {"v-c"}}},
+		{"per-key-markers-first", [][]string{{"m-a", "v-a", "v-a-older"}, {"m-b", "v-b"}, {"v-c"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.order, func(t *testing.T) {
+			setFlags(t, map[string]string{"order": tt.order})
+			var got [][]string
+			for _, group := range groupByKey(append([]s3Entry(nil), entries...)) {
+				var ids []string
+				for _, entry := range group {
+					ids = append(ids, *entry.VersionId)
+				}
+				got = append(got, ids)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("groups %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+//panickingS3 is the fake bucket, panicking in the delete of one version
+type panickingS3 struct {
+	*fakeS3
+	versionId string
+}
+
+func (f *panickingS3) DeleteObjectWithContext(ctx aws.Context, input *s3.DeleteObjectInput, opts ...request.Option) (*s3.DeleteObjectOutput, error) {
+	if aws.StringValue(input.VersionId) == f.versionId {
+		panic("delete of " + f.versionId)
+	}
+	return f.fakeS3.DeleteObjectWithContext(ctx, input, opts...)
+}
+
+func TestDispatchPerKeyPanic(t *testing.T) {
+	setFlags(t, map[string]string{"order": "per-key-versions-first"})
+	//The first key has three versions, newest first, and a delete marker on top
+	f := newFakeS3("demo", 1)
+	key := f.sortedKeys("")[0]
+	var entries []s3Entry
+	for _, v := range f.keys[key] {
+		entry := s3Entry{Key: aws.String(key), VersionId: aws.String(v.id), Type: "Version"}
+		if v.marker {
+			entry.Type = "Marker"
+		}
+		entries = append(entries, entry)
+	}
+	group := groupByKey(entries)[0]
+	j := newTestJob(t, &panickingS3{fakeS3: f, versionId: *group[1].VersionId})
+
+	if err := j.dispatchPerKey(entries).Wait(); err != nil {
+		t.Fatal(err)
+	}
+	var failed []string
+	for _, entry := range j.failed {
+		failed = append(failed, *entry.VersionId)
+	}
+	var want []string
+	for _, entry := range group[1:] {
+		want = append(want, *entry.VersionId)
+	}
+	if !reflect.DeepEqual(failed, want) {
+		t.Errorf("recorded %q as failed, want the panicking delete and the ones after it, %q", failed, want)
+	}
+	if j.stats.deleted != 1 {
+		t.Errorf("%d deleted, want the 1 before the panic", j.stats.deleted)
+	}
+}
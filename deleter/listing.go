@@ -0,0 +1,109 @@
+package deleter
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/cenkalti/backoff/v4"
+	"time"
+)
+
+//listVersionPages is ListObjectVersionsPagesWithContext with -listing-error applied to failed page requests.
+//A retried listing carries on from the markers of the last page it delivered, so no page is handed to fn twice.
+//With -page-stats each page is logged with its counts, the listing's running totals and its last key.
+func (j *bucketJob) listVersionPages(input *s3.ListObjectVersionsInput, fn func(*s3.ListObjectVersionsOutput, bool) bool) error {
+	resume := *input
+	var pages, versions, markers int64
+	return j.handleListingError("versions", func() error {
+		return j.svc.ListObjectVersionsPagesWithContext(j.ctx, &resume, func(page *s3.ListObjectVersionsOutput, lastPage bool) bool {
+			resume.KeyMarker, resume.VersionIdMarker = page.NextKeyMarker, page.NextVersionIdMarker
+			if *pageStats {
+				pages++
+				versions += int64(len(page.Versions))
+				markers += int64(len(page.DeleteMarkers))
+				last := ""
+				if n := len(page.Versions); n > 0 {
+					last = aws.StringValue(page.Versions[n-1].Key)
+				}
+				if n := len(page.DeleteMarkers); n > 0 && aws.StringValue(page.DeleteMarkers[n-1].Key) > last {
+					last = aws.StringValue(page.DeleteMarkers[n-1].Key)
+				}
+				InfoLogger.Printf("Versions page %d of %s: %d versions and %d markers, %d and %d so far, last key %q\n",
+					pages, j.name, len(page.Versions), len(page.DeleteMarkers), versions, markers, last)
+			}
+			return fn(page, lastPage)
+		}, listOptions()...)
+	})
+}
+
+//listObjectPages is ListObjectsV2PagesWithContext with -listing-error applied, like listVersionPages
+func (j *bucketJob) listObjectPages(input *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool) error {
+	resume := *input
+	var pages, objects int64
+	return j.handleListingError("objects", func() error {
+		return j.svc.ListObjectsV2PagesWithContext(j.ctx, &resume, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+			resume.ContinuationToken = page.NextContinuationToken
+			if *pageStats {
+				pages++
+				objects += int64(len(page.Contents))
+				last := ""
+				if n := len(page.Contents); n > 0 {
+					last = aws.StringValue(page.Contents[n-1].Key)
+				}
+				InfoLogger.Printf("Objects page %d of %s: %d objects, %d so far, last key %q\n", pages, j.name, len(page.Contents), objects, last)
+			}
+			return fn(page, lastPage)
+		}, listOptions()...)
+	})
+}
+
+//listOptions are the request options of listing calls: with -list-rate each request, retries included, waits
+//its turn on listLimiter before it is signed and sent
+func listOptions() []request.Option {
+	if listLimiter == nil {
+		return nil
+	}
+	return []request.Option{func(r *request.Request) {
+		r.Handlers.Sign.PushFront(func(r *request.Request) {
+			if err := listLimiter.Wait(r.Context()); err != nil {
+				r.Error = err
+			}
+		})
+	}}
+}
+
+//handleListingError runs a listing under -listing-error. Only transient errors, such as throttling, 5xx answers
+//and dropped connections, are handled: anything else, like AccessDenied or a redirect, is returned as before.
+//S3 can't hand out the page after one that failed, so skip-page gives up on the rest of the listing instead.
+//A listing stopped by expired credentials carries on once, whatever -listing-error says, if refreshing renews them.
+func (j *bucketJob) handleListingError(listing string, list func() error) error {
+	listOnce := list
+	list = func() error {
+		err := listOnce()
+		if err != nil && refreshExpiredCredentials(err) {
+			err = listOnce()
+		}
+		return err
+	}
+	switch *listingError {
+	case "retry":
+		return backoff.RetryNotify(func() error {
+			err := list()
+			if err != nil && !isRetryable(err) {
+				return backoff.Permanent(err)
+			}
+			return err
+		}, backoff.WithContext(newListBackOff(), j.ctx), func(err error, wait time.Duration) {
+			WarningLogger.Printf("Listing %s of %s failed, retrying in %s: %v\n", listing, j.name, wait.Round(time.Millisecond), err)
+		})
+	case "skip-page":
+		err := list()
+		if err != nil && isRetryable(err) && j.ctx.Err() == nil {
+			WarningLogger.Printf("Skipping the rest of the %s listing of %s: %v\n", listing, j.name, err)
+			j.listingSkipped++
+			return nil
+		}
+		return err
+	}
+	return list()
+}
@@ -0,0 +1,2376 @@
+package deleter
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials/ssocreds"
+	"github.com/aws/aws-sdk-go/aws/defaults"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go/service/s3control"
+	"github.com/cenkalti/backoff/v4"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/time/rate"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	WarningLogger        *log.Logger
+	InfoLogger           *log.Logger
+	ErrorLogger          *log.Logger
+	verbosity            *bool
+	pageStats            *bool
+	skipArchived         *bool
+	objectTag            *string
+	ttlTag               *string
+	showConfig           *bool
+	purgeConfig          *bool
+	listingError         *string
+	gatewayURL           *string
+	requireBucketTag     *string
+	roleChain            *string
+	statusAddr           *string
+	batchSize            *int
+	batchMaxBytes        *int
+	verboseBatch         *bool
+	strictReplication    *bool
+	deleteAccessPoints   *bool
+	keysFromS3           *string
+	manifestBuckets      *bool
+	reportPerPrefix      *bool
+	verifyDeletes        *bool
+	bucketDeleteGrace    *time.Duration
+	keyMarker            *string
+	versionIDMarker      *string
+	continuationToken    *string
+	warmUp               *bool
+	reportByClass        *bool
+	reportSkipped        *bool
+	listRetryInitial     *time.Duration
+	listRetryMaxInterval *time.Duration
+	listRetryMaxElapsed  *time.Duration
+	confirmBucketDelete  *bool
+	bucketDeleteDelay    *time.Duration
+	minSize              *int64
+	maxSize              *int64
+
+	suspendVersion    *bool
+	removePolicyFirst *bool
+	concurrency       *int
+	adaptive          *bool
+	adaptiveMin       *int
+	adaptiveMax       *int
+	maxIdleConns      *int
+	maxConnsPerHost   *int
+	maxGoroutines     *int
+	throughputReport  *bool
+	throughputCSV     *string
+
+	force               *bool
+	safe                *bool
+	fast                *bool
+	dryRun              *bool
+	probe               *bool
+	planOutPath         *string
+	planDiffPath        *string
+	planDiffOut         *string
+	planInPath          *string
+	auditDir            *string
+	runID               *string
+	objectKey           *string
+	reportBytes         *bool
+	maxBandwidth        *int64
+	sampleKeys          *int64
+	perBucketTimeout    *time.Duration
+	order               *string
+	endpointURL         *string
+	retryJitter         *float64
+	retryAll            *bool
+	retryOn5xx          *bool
+	backoffStrategy     *string
+	retryInterval       *time.Duration
+	retryBudget         *time.Duration
+	crossAccount        *bool
+	batchDeletes        *bool
+	lowMemory           *bool
+	versionedBatch      *bool
+	onError             *string
+	abortTimeout        *time.Duration
+	retryPasses         *int
+	workersPerPage      *int
+	parallelPages       *int
+	backupTo            *string
+	deleteIfFailed      *bool
+	summaryJSON         *string
+	metricsJSONOut      *string
+	deletedARNsOut      *string
+	junitOut            *string
+	resultsOut          *string
+	deleteFolders       *bool
+	uploadsOlderThan    *time.Duration
+	showProgress        *bool
+	showDashboard       *bool
+	heartbeatEvery      *time.Duration
+	deadlinePer10k      *time.Duration
+	paceAfter           *time.Duration
+	paceAbort           *bool
+	expectedObjects     *int64
+	partitionPlan       *bool
+	keepOnDenied        *bool
+	disableChecksum     *bool
+	estimateSample      *int64
+	profile             *string
+	credentialsFile     *string
+	objectTimeout       *time.Duration
+	quiet               *bool
+	listUploadsOnly     *bool
+	retentionReport     *string
+	listRegionsOnly     *bool
+	bucketListPath      *string
+	uploadsPrefix       *string
+	maxAutoDelete       *int64
+	seed                *int64
+	shuffleWithinPage   *bool
+	regionMapPath       *string
+	ignoreMissing       *bool
+	rampUp              *time.Duration
+	otelEndpoint        *string
+	keyPrefix           *string
+	prefixFile          *string
+	deleteOrder         *string
+	keepVersions        *int
+	forceBucketDelete   *bool
+	staleMarkerAge      *time.Duration
+	maxAccessDenied     *int
+	fakeKeys            *int
+	perBucketPools      *bool
+	summaryEvery        *time.Duration
+	cloudWatchNamespace *string
+	statsInterval       *time.Duration
+	mfa                 *string
+	autoRetryFailures   *int
+	histogram           *bool
+	deleteBucketOnly    *bool
+	noEmptyFallback     *bool
+	verify              *bool
+	skipVerify          *bool
+	verifyPasses        *int
+	verifyDelay         *time.Duration
+	namePrefix          *string
+	nameSuffix          *string
+	matchRegex          *string
+
+	bucketConcurrency *int
+	interBucketDelay  *time.Duration
+	rateLimit         *float64
+	capConcurrency    *bool
+	listRate          *float64
+
+	limiter *rate.Limiter
+	//The FlagSet defineFlags set up, which holds the settings the engine runs with
+	engineFlags *flag.FlagSet
+	//-list-rate's limiter for listing requests, nil without it
+	listLimiter *rate.Limiter
+	//Keys requested per listing page, S3's maximum unless -low-memory shrinks it
+	listPageSize int64 = 1000
+	//Successful deletes across the run, updated atomically
+	deletedCount int64
+)
+
+//bucketStats holds the counters for one bucket
+type bucketStats struct {
+	//Successful deletes in this bucket, updated atomically
+	deleted int64
+	//The same by kind, and their bytes, for -results-out, also updated atomically
+	objectsDeleted  int64
+	versionsDeleted int64
+	markersDeleted  int64
+	bytesDeleted    int64
+
+	//Entries a dry run would have deleted, updated atomically
+	planned int64
+
+	//What the filters kept back or let through, updated atomically since -parallel-pages filters pages at once
+	archivedSkipped     int64
+	tagSkipped          int64
+	ttlUnexpired        int64
+	sizeInRange         int64
+	sizeInRangeBytes    int64
+	sizeOutOfRange      int64
+	sizeOutOfRangeBytes int64
+	inDateWindow        int64
+	staleMarkers        int64
+	globSkipped         int64
+	folderMarkers       int64
+
+	bucketDeleted bool
+	//Why the bucket was deliberately left alone, for the summary
+	skipped string
+}
+
+//bucketJob is the state of one bucket being emptied and deleted
+type bucketJob struct {
+	stats   bucketStats
+	ctx     context.Context
+	name    string
+	region  string
+	svc     s3iface.S3API
+	pool    *workerPool
+	started time.Time
+
+	//Stops every request for this bucket, see stop
+	cancel   context.CancelFunc
+	stopOnce sync.Once
+
+	//Entries that still failed after retrying
+	failedMu sync.Mutex
+	failed   []s3Entry
+
+	//The bucket's -otel-endpoint span, nil when tracing is off
+	span *span
+
+	//-mfa, set when the bucket has MFA Delete enabled and version deletes have to carry it
+	mfa *string
+
+	//Whether the bucket has a replication configuration, which -purge-config removes
+	replicated bool
+
+	//Access points attached to the bucket, with the S3 Control client and account ID to delete them
+	accessPoints []string
+	control      *s3control.S3Control
+	accountID    *string
+
+	//-histogram state, only the first emptying pass is counted
+	keyVersions keyVersions
+	counted     bool
+
+	//Listings -listing-error=skip-page cut short, which keeps the bucket
+	listingSkipped int
+
+	//Folder markers -delete-empty-prefixes deletes once the filters have been through their folders
+	folders folderHold
+
+	//The entry -probe deletes once the dry run has listed the bucket
+	probeMu    sync.Mutex
+	probeEntry *s3Entry
+}
+
+//stop gives up on the bucket after an error no further request on it can get past: the error is recorded
+//once, in-flight deletes are cancelled and no new ones start. An error that rules out every bucket stops the whole run.
+func (j *bucketJob) stop(err error) {
+	if isFatal(err) {
+		stopRun(err)
+		return
+	}
+	j.stopOnce.Do(func() {
+		ErrorLogger.Printf("Stopping %s: %v\n", j.name, err)
+		recordBucketFailure(j.name, err)
+		j.cancel()
+	})
+}
+
+//stopIfFatal calls stop for errors that doom every other delete in the bucket, returning whether it did
+func (j *bucketJob) stopIfFatal(err error) bool {
+	switch {
+	case isFatal(err):
+		j.stop(err)
+	case isNoSuchBucket(err):
+		j.stop(&ErrBucketNotFound{Bucket: j.name})
+	default:
+		return false
+	}
+	return true
+}
+
+//recordFailed remembers an entry that couldn't be deleted, for the bucket's ErrPartialFailure
+func (j *bucketJob) recordFailed(entry s3Entry) {
+	j.failedMu.Lock()
+	j.failed = append(j.failed, entry)
+	j.failedMu.Unlock()
+}
+
+//takeFailed returns the failed entries and forgets them, before they are tried again
+func (j *bucketJob) takeFailed() []s3Entry {
+	j.failedMu.Lock()
+	defer j.failedMu.Unlock()
+	failed := j.failed
+	j.failed = nil
+	return failed
+}
+
+//resetFailed forgets the failed entries before another pass, returning how many there were
+func (j *bucketJob) resetFailed() int {
+	return len(j.takeFailed())
+}
+
+//retryFailed deletes just the entries that failed, again, for up to -auto-retry-failures rounds,
+//without re-listing the bucket. It returns why it stopped early, if it did.
+func (j *bucketJob) retryFailed() error {
+	for round := 1; round <= *autoRetryFailures; round++ {
+		failed := j.takeFailed()
+		if len(failed) == 0 {
+			break
+		}
+		WarningLogger.Printf("%d deletes failed in %s, retrying just those (round %d of %d)\n", len(failed), j.name, round, *autoRetryFailures)
+		for start := 0; start < len(failed); start += int(listPageSize) {
+			end := start + int(listPageSize)
+			if end > len(failed) {
+				end = len(failed)
+			}
+			err := j.dispatchEntries(failed[start:end]).Wait()
+			if j.timedOut() {
+				return j.ctx.Err()
+			}
+			if err != nil {
+				return err
+			}
+		}
+		j.failedMu.Lock()
+		left := len(j.failed)
+		j.failedMu.Unlock()
+		if left == 0 {
+			InfoLogger.Printf("All %d failed deletes in %s succeeded when retried\n", len(failed), j.name)
+		}
+	}
+	return nil
+}
+
+//partialFailure returns an ErrPartialFailure listing every entry that failed, or nil if none did
+func (j *bucketJob) partialFailure() error {
+	j.failedMu.Lock()
+	defer j.failedMu.Unlock()
+	if len(j.failed) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(j.failed))
+	for _, entry := range j.failed {
+		key := aws.StringValue(entry.Key)
+		if entry.VersionId != nil {
+			key += " (" + aws.StringValue(entry.VersionId) + ")"
+		}
+		keys = append(keys, key)
+	}
+	return &ErrPartialFailure{Bucket: j.name, FailedKeys: keys}
+}
+
+//newDeleteLimiter is -rate's limiter, nil without it
+func newDeleteLimiter() *rate.Limiter {
+	if *rateLimit <= 0 {
+		return nil
+	}
+	burst := int(*rateLimit)
+	if burst < 1 {
+		burst = 1
+	}
+	return rate.NewLimiter(rate.Limit(*rateLimit), burst)
+}
+
+//applyLowMemory turns on what -low-memory stands for: batch deletes, small listing pages and few requests in flight,
+//so a bucket holds no more than a few pages of entries at a time however big it is
+func applyLowMemory() {
+	*batchDeletes = true
+	listPageSize = 100
+	if *concurrency > 8 {
+		*concurrency = 8
+	}
+	if *adaptiveMax > 8 {
+		*adaptiveMax = 8
+	}
+}
+
+//defineFlags sets up every command-line flag in fs and points the flag globals at them. The bucket name and
+//-concurrency are Main's alone, so they are returned instead.
+func defineFlags(fs *flag.FlagSet) (bucketName *string, concurrencyValue *concurrencyFlag) {
+	engineFlags = fs
+	bucketName = fs.String("b", "unknown", "Bucket name")
+	namePrefix = fs.String("name-prefix", "", "Delete every bucket whose name starts with this prefix")
+	nameSuffix = fs.String("name-suffix", "", "Delete every bucket whose name ends with this suffix")
+	matchRegex = fs.String("match-regex", "", "Delete every bucket whose name matches this Go regular expression, e.g. '^ci-[0-9]+-(tmp|scratch)$'")
+	force = fs.Bool("force", false, "Don't ask for confirmation")
+	fast = fs.Bool("fast", false, "Turn on the settings for the most throughput together, see the README; flags given explicitly still win")
+	safe = fs.Bool("safe", false, "Turn on the safety guard rails together, see the README; flags given explicitly still win")
+	probe = fs.Bool("probe", false, "With -dry-run, really delete one entry the run would delete, after confirming it, to check the delete permissions")
+	dryRun = fs.Bool("dry-run", false, "List what would be deleted without deleting anything")
+	estimateSample = fs.Int64("dry-run-sample", 0, "Delete only the first N entries of each bucket to measure the rate, then estimate the full run time (needs -force)")
+	planDiffPath = fs.String("plan-diff", "", "With -dry-run, compare what would be deleted with this earlier -plan-out plan")
+	planDiffOut = fs.String("plan-diff-out", "", "CSV report -plan-diff writes, one new, unchanged or gone row per entry")
+	planOutPath = fs.String("plan-out", "", "With -dry-run, write every entry that would be deleted to this CSV plan file")
+	partitionPlan = fs.Bool("partition-plan", false, "Write -plan-out as a directory with one file per bucket and top-level prefix")
+	histogram = fs.Bool("histogram", false, "Print histograms of version sizes and versions per key with the summary")
+	autoRetryFailures = fs.Int("auto-retry-failures", 0, "After emptying, delete just the entries that failed again, up to this many rounds")
+	mfa = fs.String("mfa", "", "\"serial code\" of the MFA device, for buckets with MFA Delete enabled")
+	cloudWatchNamespace = fs.String("cloudwatch-namespace", "", "Publish each bucket's ObjectsDeleted, Failures and DeletionRate as CloudWatch metrics in this namespace during the run")
+	statsInterval = fs.Duration("stats-interval", time.Minute, "How often -cloudwatch-namespace publishes")
+	summaryEvery = fs.Duration("summary-every", 0, "Log the running summary as JSON at this interval (default 0, only at the end)")
+	perBucketPools = fs.Bool("per-bucket-pools", false, "With several buckets, give each bucket being emptied its own -concurrency workers instead of sharing -concurrency between them")
+	fakeKeys = fs.Int("fake", 0, "")
+	listingError = fs.String("listing-error", "retry", "What a transient listing error does: retry the page with backoff, skip-page to give up on the rest of that listing and keep the bucket, or abort the run")
+	purgeConfig = fs.Bool("purge-config", false, "Remove the bucket's analytics, metrics and inventory configurations before deleting it")
+	showConfig = fs.Bool("print-config", false, "Log every setting the run will use, with credentials redacted, before starting")
+	maxAccessDenied = fs.Int("max-access-denied", 50, "Stop the run once more than this many deletes were refused with AccessDenied (0 never stops)")
+	staleMarkerAge = fs.Duration("skip-delete-markers-older-than", 0, "Delete only the delete markers last modified longer ago than this, pruning stale tombstones and leaving every object and version. The bucket is kept")
+	keepVersions = fs.Int("keep-versions", 0, "Keep the N newest versions of every key and delete older versions and all delete markers. The bucket is kept")
+	deleteOrder = fs.String("delete-order", "key-asc", "Order each page's entries are handed to the workers in: key-asc (listing order), modified-desc (newest first) or modified-asc")
+	forceBucketDelete = fs.Bool("force-bucket-delete", false, "Delete the bucket even with a filter set, if nothing is left in it once the filtered entries are gone")
+	keyPrefix = fs.String("prefix", "", "Only delete keys starting with this prefix. The bucket is kept")
+	prefixFile = fs.String("prefix-file", "", "Only delete keys under the prefixes in this file, one per line, one prefix after the other. The bucket is kept")
+	otelEndpoint = fs.String("otel-endpoint", "", "Send OpenTelemetry spans for the run, each bucket and each listing page to this OTLP/HTTP collector, e.g. http://localhost:4318")
+	rampUp = fs.Duration("ramp-up", 0, "Grow each bucket's workers from 1 to -concurrency over this long instead of starting at full size")
+	ignoreMissing = fs.Bool("ignore-missing", false, "Treat a bucket that doesn't exist as already deleted instead of an error")
+	regionMapPath = fs.String("region-map", "", "CSV file of bucket,region lines, used instead of looking up those buckets' regions")
+	planInPath = fs.String("plan-in", "", "Delete exactly the entries in a plan file written by -plan-out")
+	auditDir = fs.String("audit-dir", "", "With -dry-run, schedule a run's deletes in this directory; without, delete the schedule of -run-id, logging every outcome to a hash-chained audit log there")
+	runID = fs.String("run-id", "", "ID of the -audit-dir run to schedule or delete, generated for a -dry-run when not given")
+	maxAutoDelete = fs.Int64("max-auto-delete-objects", 0, "Refuse to empty a bucket holding more versions than this unless -force is set (default 0, no limit)")
+	sampleKeys = fs.Int64("sample-keys", 0, "Print the first N versions in each bucket and ask before deleting")
+	backupTo = fs.String("backup-to", "", "Copy every version and object to s3://bucket/prefix before deleting it")
+	objectKey = fs.String("key", "", "Delete only the -version-id versions of this key, keeping the bucket")
+	fs.Var(&versionIds, "version-id", "Version ID of -key to delete, repeat for several")
+	crossAccount = fs.Bool("allow-cross-account", false, "Let -name-prefix/-name-suffix delete buckets that can't be confirmed as owned by the caller's account")
+	quiet = fs.Bool("q", false, "Quiet: only errors during the run, then one OK/FAILED line on stderr")
+	verbosity = fs.Bool("v", false, "Set to verbose logging")
+	pageStats = fs.Bool("page-stats", false, "Log each listing page's version, marker or object count, the running totals and the last key, without logging every delete")
+	profile = fs.String("profile", "", "Shared config profile to use instead of AWS_PROFILE or default")
+	credentialsFile = fs.String("credentials-file", "", "Shared credentials file to use instead of ~/.aws/credentials")
+	disableChecksum = fs.Bool("disable-checksum", false, "Don't compute or validate checksums or send Expect: 100-continue, for older S3-compatible stores")
+	statusAddr = fs.String("status-addr", "", "Serve the running summary as JSON at /status and a health check at /healthz on this address, e.g. localhost:8080")
+	roleChain = fs.String("role-chain", "", "Comma-separated role ARNs to assume one after the other, each with the previous one's credentials, before talking to S3")
+	requireBucketTag = fs.String("require-bucket-tag", "", "Only touch buckets tagged key=value, e.g. created-by=our-tool, and skip the rest unless -force is given")
+	gatewayURL = fs.String("gateway-url", "", "Send the calls that list and delete objects through this S3-compatible gateway, and everything else to S3 or -endpoint-url")
+	endpointURL = fs.String("endpoint-url", "", "Send requests to this S3-compatible endpoint instead of AWS (e.g. http://localhost:4566 for LocalStack)")
+	skipArchived = fs.Bool("skip-archived", false, "Skip GLACIER/DEEP_ARCHIVE versions and objects instead of deleting them")
+	minSize = fs.Int64("min-size", 0, "Only delete versions and objects of at least this many bytes")
+	maxSize = fs.Int64("max-size", 0, "Only delete versions and objects of at most this many bytes (0 for no limit)")
+	listRegionsOnly = fs.Bool("list-regions-of-buckets", false, "Only print the region of each bucket given with -b, -bucket-list or -name-prefix/-name-suffix, deleting nothing")
+	bucketListPath = fs.String("bucket-list", "", "With -list-regions-of-buckets, read the buckets from this file, one per line, or - for stdin")
+	listUploadsOnly = fs.Bool("list-incomplete-uploads", false, "Only print the incomplete multipart uploads (key, upload ID, initiated, initiator), deleting nothing")
+	retentionReport = fs.String("version-retention-report", "", "Only write a CSV of each key's versions, delete markers, noncurrent bytes and oldest version to this file, or to stdout with -, deleting nothing")
+	uploadsPrefix = fs.String("uploads-prefix", "", "With -list-incomplete-uploads, only list uploads of keys starting with this")
+	uploadsOlderThan = fs.Duration("abort-uploads-older-than", 0, "Only abort incomplete multipart uploads started longer ago than this (default 0, abort all)")
+	deleteFolders = fs.Bool("delete-empty-prefixes", false, "Delete zero-byte \"folder/\" placeholder objects even when filters would keep them, unless the filters keep something else in the folder")
+	fs.Var(&globFlag{compiled: &includeGlobs}, "include", "Only delete keys matching this glob (* also matches /), repeat for several")
+	fs.Var(&globFlag{compiled: &excludeGlobs}, "exclude", "Never delete keys matching this glob, repeat for several; wins over -include")
+	fs.Var(&modifiedAfter, "modified-after", "Only delete versions and objects last modified at or after this time (RFC 3339 or YYYY-MM-DD)")
+	fs.Var(&modifiedBefore, "modified-before", "Only delete versions and objects last modified before this time (RFC 3339 or YYYY-MM-DD)")
+	concurrencyValue = &concurrencyFlag{n: 1000}
+	concurrency = &concurrencyValue.n
+	fs.Var(&totalRetryBudget, "total-retry-budget", "Stop retrying deletes anywhere in the run after this many retries, or this long spent waiting between them, e.g. 5000 or 30m")
+	fs.Var(concurrencyValue, "concurrency", "Maximum number of deletes in flight per bucket, or \"auto\" to size it from CPU count and -rate")
+	perBucketTimeout = fs.Duration("per-bucket-timeout", 0, "Give up on a bucket that takes longer than this and move on to the next (0 for no limit)")
+	parallelPages = fs.Int("parallel-pages", 1, "Listing pages deleted at the same time while the listing carries on, up to 16")
+	workersPerPage = fs.Int("workers-per-page", 0, "Cap on deletes in flight for one listing page, and list the next page while it is deleted (default 0, off)")
+	bucketConcurrency = fs.Int("bucket-concurrency", 1, "Number of buckets processed at the same time")
+	interBucketDelay = fs.Duration("inter-bucket-delay", 0, "Wait this long before starting each bucket after the first, to let the account's request rate recover")
+	rateLimit = fs.Float64("rate", 0, "Maximum delete requests per second across all buckets (0 for no limit)")
+	listRate = fs.Float64("list-rate", 0, "Maximum listing requests per second across all buckets, on top of -rate for the deletes (0 for no limit)")
+	capConcurrency = fs.Bool("cap-concurrency", false, "Lower -concurrency to as many workers as -rate keeps busy, instead of warning")
+	adaptive = fs.Bool("adaptive", false, "Adjust concurrency automatically, backing off when S3 throttles")
+	adaptiveMin = fs.Int("adaptive-min", 1, "Lowest concurrency -adaptive or SIGUSR2 will go down to")
+	maxIdleConns = fs.Int("max-idle-conns", 0, "Idle connections kept open per host for reuse (0 for as many as deletes can be in flight)")
+	maxGoroutines = fs.Int("max-goroutines", 0, "Most delete goroutines alive at once across the run, a backstop for a -concurrency too high for the host (0 for no limit)")
+	maxConnsPerHost = fs.Int("max-conns-per-host", 0, "Most connections open to one host at a time, requests beyond it wait for one (0 for no limit)")
+	adaptiveMax = fs.Int("adaptive-max", 1000, "Highest concurrency -adaptive or SIGUSR1 will go up to")
+	shuffleWithinPage = fs.Bool("shuffle-within-page", false, "Put each page's keys in random order before deleting them, to spread the deletes over S3's key partitions")
+	seed = fs.Int64("seed", 0, "Seed for -order shuffled and -shuffle-within-page, to make a run's order reproducible (default 0, a new order every run)")
+	order = fs.String("order", "markers-first", "How each page's delete markers and versions are scheduled: markers-first, versions-first, interleaved, shuffled, per-key-versions-first or per-key-markers-first")
+	batchSize = fs.Int("batch-size", maxBatchSize, "Keys per DeleteObjects request with -batch, 1 to 1000")
+	batchMaxBytes = fs.Int("batch-max-bytes", defaultBatchMaxBytes, "Soft cap on a DeleteObjects request body with -batch: a batch is sent early rather than grow past it")
+	reportSkipped = fs.Bool("report-skipped", false, "Log how many entries each filter kept back in the summary, e.g. \"skipped: 120 by age, 45 by class\"")
+	reportByClass = fs.Bool("report-by-class", false, "Break the summary down by the storage class of the deleted versions and objects")
+	warmUp = fs.Bool("warm-up", true, "Send one HeadBucket right before the deletes start, so DNS, TLS and the connection are ready for the burst")
+	keyMarker = fs.String("key-marker", "", "Start the versions listing after this key, to resume where an earlier run stopped")
+	versionIDMarker = fs.String("version-id-marker", "", "With -key-marker, start the versions listing after this version of that key")
+	continuationToken = fs.String("continuation-token", "", "Start the objects listing from this ListObjectsV2 continuation token, to resume where an earlier run stopped")
+	bucketDeleteGrace = fs.Duration("bucket-delete-grace", time.Second, "Wait this long after emptying and re-list before DeleteBucket, for stores slow to notice a bucket is empty. 0 disables it")
+	verifyDeletes = fs.Bool("verify-deletes", false, "Confirm every delete, -batch ones included, with a HeadObject expecting 404, and delete again what is still there (twice the requests)")
+	reportPerPrefix = fs.Bool("report-per-prefix", false, "Break the summary down by the top-level prefix (first path segment) of the deleted keys")
+	manifestBuckets = fs.Bool("manifest-buckets", false, "The -keys-from-s3 manifest has a bucket column, bucket,key[,versionId], and drives deletes across all the buckets it names instead of -b")
+	keysFromS3 = fs.String("keys-from-s3", "", "Delete only the key[,versionId] lines of this s3://bucket/key manifest from the -b bucket, and keep the bucket")
+	deleteAccessPoints = fs.Bool("delete-access-points", false, "Delete the access points attached to a bucket before deleting it, rather than only reporting them")
+	strictReplication = fs.Bool("strict-replication", false, "Refuse to delete buckets that replicate to other buckets unless -force is given, instead of only warning")
+	verboseBatch = fs.Bool("verbose-batch", false, "Have DeleteObjects list every deleted key in its response, not just the failures (implied by -v)")
+	batchDeletes = fs.Bool("batch", false, "Delete current objects with DeleteObjects requests of up to 1000 keys instead of one request per key")
+	versionedBatch = fs.Bool("include-versioned-batch", false, "With -batch, also delete versions and delete markers with DeleteObjects")
+	lowMemory = fs.Bool("low-memory", false, "Keep memory use small: batch deletes, small listing pages and at most 8 requests in flight per bucket")
+	backoffStrategy = fs.String("backoff-strategy", "exponential", "Delay between delete retries: exponential, constant or linear")
+	retryInterval = fs.Duration("retry-interval", backoff.DefaultInitialInterval, "First retry delay for exponential, the fixed delay for constant, the step for linear")
+	keepOnDenied = fs.Bool("allow-keep-bucket-on-denied", false, "If DeleteBucket is denied after emptying, warn and keep the bucket instead of failing")
+	deleteIfFailed = fs.Bool("delete-even-if-failed", false, "Still try to delete the bucket when some objects couldn't be deleted")
+	abortTimeout = fs.Duration("abort-timeout", 0, "Give up aborting a bucket's multipart uploads after this long and carry on, or stop the bucket with -on-error=abort (0 for no limit)")
+	onError = fs.String("on-error", "continue", "What a delete that fails for good does: continue (log it and carry on) or abort the run")
+	retryPasses = fs.Int("retry-failed-passes", 0, "If any deletes fail, run the whole emptying pass again up to this many times before giving up")
+	retryAll = fs.Bool("retry-all-errors", false, "Retry every failed delete, not just throttling, 5xx and network errors")
+	retryOn5xx = fs.Bool("retry-on-5xx", true, "Retry deletes that fail with a 500-class error other than throttling; false gives up on them straight away, even with -retry-all-errors")
+	objectTimeout = fs.Duration("per-object-timeout", 0, "Cancel and retry a single delete request that takes longer than this (default 0, no limit)")
+	retryBudget = fs.Duration("object-retry-budget", backoff.DefaultMaxElapsedTime, "Stop retrying a single object after this long and record it as failed")
+	listRetryInitial = fs.Duration("list-retry-initial", backoff.DefaultInitialInterval, "First delay before retrying a listing page with -listing-error=retry")
+	listRetryMaxInterval = fs.Duration("list-retry-max-interval", backoff.DefaultMaxInterval, "Longest delay between retries of a listing page")
+	listRetryMaxElapsed = fs.Duration("list-retry-max-elapsed", backoff.DefaultMaxElapsedTime, "How long a listing page is retried before the run gives up on the bucket")
+	retryJitter = fs.Float64("retry-jitter", backoff.DefaultRandomizationFactor, "Randomization factor (0-1) applied to each retry delay")
+	removePolicyFirst = fs.Bool("remove-policy-first", false, "Delete the bucket policy before emptying, so a policy that denies deletes can't block the teardown")
+	suspendVersion = fs.Bool("suspend-versioning", false, "Suspend bucket versioning before emptying so new writes stop adding versions")
+	deadlinePer10k = fs.Duration("deadline-per-10k", 0, "Expected time to delete 10,000 entries; warn when the run is slower than that (default 0, no check)")
+	paceAfter = fs.Duration("pace-after", time.Minute, "How long the run gets to get up to speed before -deadline-per-10k and -expected-objects look at its rate")
+	paceAbort = fs.Bool("pace-abort", false, "Stop the run, rather than warn, when it is slower than -deadline-per-10k")
+	expectedObjects = fs.Int64("expected-objects", 0, "Roughly how many entries the run will delete, to log a projected completion time after -pace-after")
+	heartbeatEvery = fs.Duration("heartbeat", 0, "Log a line with deletes so far and the delete rate to stderr at this interval, terminal or not (default 0, off)")
+	showDashboard = fs.Bool("tui", false, "Show a full-screen live dashboard on stderr instead of the log lines, when it is a terminal")
+	showProgress = fs.Bool("progress", false, "Show a live line with deletes and retries per second on stderr, when it is a terminal")
+	throughputReport = fs.Bool("throughput-report", false, "Sample the deletion rate and report min/avg/max objects per second")
+	reportBytes = fs.Bool("report-bandwidth", false, "Report the bytes freed and the effective bytes per second at the end")
+	maxBandwidth = fs.Int64("max-bandwidth", 0, "Soft cap on bytes freed per second, slowing deletes of large objects (default 0, no limit)")
+	summaryJSON = fs.String("summary-json-out", "", "Write the final summary as JSON to this file")
+	metricsJSONOut = fs.String("metrics-json-out", "", "Append one line of JSON metrics for the run to this file when it ends, or write it to stdout with -")
+	deletedARNsOut = fs.String("deleted-arns-out", "", "Write the ARN, region and account of every bucket deleted to this file when the run ends, one JSON line each, or to stdout with -")
+	resultsOut = fs.String("results-out", "", "Write one record per bucket (counts by kind, bytes, duration, status) to this file, as CSV if it ends in .csv and JSON otherwise")
+	junitOut = fs.String("junit-out", "", "Write the final summary as a JUnit XML report to this file, one test case per bucket")
+	throughputCSV = fs.String("throughput-csv", "", "Write the sampled deletion rate time series to this CSV file")
+	deleteBucketOnly = fs.Bool("delete-bucket-only", false, "Skip listing and go straight to deleting the bucket, for buckets that are already empty")
+	confirmBucketDelete = fs.Bool("confirm-before-bucket-delete", false, "Once a bucket is empty, ask again before deleting it, or wait -bucket-delete-delay when not run from a terminal")
+	bucketDeleteDelay = fs.Duration("bucket-delete-delay", time.Minute, "How long -confirm-before-bucket-delete waits before DeleteBucket when there is no terminal to ask on")
+	noEmptyFallback = fs.Bool("no-empty-fallback", false, "With -delete-bucket-only, fail instead of emptying a bucket that turns out not to be empty")
+	verify = fs.Bool("verify", false, "Re-list after emptying and delete anything that reappeared before deleting the bucket")
+	skipVerify = fs.Bool("skip-verify", false, "Delete the bucket straight after emptying it, even with -verify, and only run the verify passes if S3 says it isn't empty")
+	verifyPasses = fs.Int("verify-passes", 3, "How many times -verify re-lists and deletes before giving up")
+	verifyDelay = fs.Duration("verify-delay", 5*time.Second, "How long -verify waits before each re-list")
+	objectTag = fs.String("object-tag", "", "Only delete versions and objects tagged key=value (one extra GetObjectTagging call per entry)")
+	ttlTag = fs.String("ttl-tag", "", "Only delete versions and objects whose RFC 3339 timestamp in this tag is in the past (one extra GetObjectTagging call per entry)")
+	return bucketName, concurrencyValue
+}
+
+//Main is the deleteS3bucket command: it parses the command line, runs and exits with the run's exit code
+func Main() {
+	bucketName, concurrencyValue := defineFlags(flag.CommandLine)
+	flag.Usage = usage
+	flag.Parse()
+
+	InfoLogger = log.New(os.Stdout, "INFO: ", log.Ldate|log.Ltime)
+	WarningLogger = log.New(os.Stdout, "WARN: ", log.Ldate|log.Ltime)
+	ErrorLogger = log.New(os.Stderr, "ERROR: ", log.Ldate|log.Ltime)
+	if *quiet {
+		if *verbosity {
+			exitErrorf("-q and -v can't be combined")
+		}
+		InfoLogger.SetOutput(ioutil.Discard)
+		WarningLogger.SetOutput(ioutil.Discard)
+	}
+	if *safe && *fast {
+		exitErrorf("-safe and -fast can't be combined")
+	}
+	if *safe {
+		applyPreset("safe", safePreset)
+	}
+	if *fast {
+		applyPreset("fast", fastPreset)
+	}
+
+	if concurrencyValue.auto {
+		*concurrency = autoConcurrency(*rateLimit)
+		InfoLogger.Printf("Using -concurrency %d\n", *concurrency)
+	}
+
+	discovering := *namePrefix != "" || *nameSuffix != "" || *matchRegex != ""
+	if *matchRegex != "" {
+		var err error
+		if bucketNameRegex, err = regexp.Compile(*matchRegex); err != nil {
+			exitErrorf("-match-regex %v", err)
+		}
+	}
+	if *credentialsFile != "" {
+		if _, err := os.Stat(*credentialsFile); err != nil {
+			exitErrorf("Unable to use -credentials-file: %v", err)
+		}
+	}
+	if *estimateSample < 0 {
+		exitErrorf("-dry-run-sample can't be negative")
+	}
+	if *estimateSample > 0 && (!*force || *dryRun) {
+		exitErrorf("-dry-run-sample really deletes its sample, so it needs -force and can't be combined with -dry-run")
+	}
+	if *auditDir != "" {
+		if !*dryRun && *bucketName != "unknown" {
+			exitErrorf("-audit-dir takes the buckets to delete from the run's schedule, so it can't be combined with -b")
+		}
+		setupAudit()
+	} else if *runID != "" {
+		exitErrorf("-run-id needs -audit-dir")
+	}
+	if *partitionPlan && *planOutPath == "" {
+		exitErrorf("-partition-plan needs -plan-out")
+	}
+	if (*planDiffPath != "") != (*planDiffOut != "") {
+		exitErrorf("-plan-diff and -plan-diff-out go together")
+	}
+	if *planDiffPath != "" && (!*dryRun || *planInPath != "") {
+		exitErrorf("-plan-diff compares a -dry-run with an earlier plan, without -plan-in")
+	}
+	if *probe && (!*dryRun || *objectKey != "" || *keysFromS3 != "") {
+		exitErrorf("-probe goes with a listing -dry-run, without -key or -keys-from-s3")
+	}
+	if *planOutPath != "" && !*dryRun {
+		exitErrorf("-plan-out needs -dry-run")
+	}
+	if *planInPath != "" && (*dryRun || discovering || *bucketName != "unknown") {
+		exitErrorf("-plan-in takes its buckets from the plan and can't be combined with -dry-run, -b or -name-prefix/-name-suffix")
+	}
+	if (*objectKey == "") != (len(versionIds) == 0) {
+		exitErrorf("-key and -version-id go together")
+	}
+	if *keyPrefix != "" {
+		keyPrefixes = append(keyPrefixes, *keyPrefix)
+	}
+	if *prefixFile != "" {
+		prefixes, err := readPrefixFile(*prefixFile)
+		if err != nil {
+			exitErrorf("Unable to read prefix file %s: %v", *prefixFile, err)
+		}
+		if len(prefixes) == 0 {
+			exitErrorf("Prefix file %s has no prefixes", *prefixFile)
+		}
+		seen := map[string]bool{}
+		for _, prefix := range append(keyPrefixes, prefixes...) {
+			if seen[prefix] {
+				exitErrorf("Prefix %q is given more than once", prefix)
+			}
+			seen[prefix] = true
+		}
+		keyPrefixes = append(keyPrefixes, prefixes...)
+	}
+	if *mfa != "" && !mfaValue.MatchString(*mfa) {
+		exitErrorf("-mfa must be the device serial number or ARN and the current code, separated by a space")
+	}
+	if *cloudWatchNamespace != "" && *statsInterval <= 0 {
+		exitErrorf("-stats-interval must be positive")
+	}
+	if *cloudWatchNamespace != "" && *endpointURL != "" {
+		exitErrorf("-cloudwatch-namespace needs AWS, it can't be combined with -endpoint-url")
+	}
+	if *summaryEvery < 0 {
+		exitErrorf("-summary-every can't be negative")
+	}
+	if *fakeKeys < 0 {
+		exitErrorf("-fake can't be negative")
+	}
+	if *fakeKeys > 0 {
+		if discovering || *planInPath != "" || *backupTo != "" || *endpointURL != "" || *gatewayURL != "" {
+			exitErrorf("-fake works on a single bucket given with -b, without -plan-in, -backup-to, -endpoint-url or -gateway-url")
+		}
+		WarningLogger.Printf("FAKE MODE: %s is an in-memory bucket of %d keys, nothing is sent to S3\n", *bucketName, *fakeKeys)
+		regionCache[*bucketName] = fakeRegion
+	}
+	if *maxAccessDenied < 0 {
+		exitErrorf("-max-access-denied can't be negative")
+	}
+	if *keepVersions < 0 {
+		exitErrorf("-keep-versions can't be negative")
+	}
+	if *staleMarkerAge < 0 {
+		exitErrorf("-skip-delete-markers-older-than can't be negative")
+	}
+	if *staleMarkerAge > 0 && (*keepVersions > 0 || *objectKey != "" || *planInPath != "") {
+		exitErrorf("-skip-delete-markers-older-than can't be combined with -keep-versions, -key or -plan-in")
+	}
+	staleMarkerCutoff = time.Now().Add(-*staleMarkerAge)
+	if *keepVersions > 0 && (*objectKey != "" || *planInPath != "") {
+		exitErrorf("-keep-versions can't be combined with -key or -plan-in")
+	}
+	if len(keyPrefixes) > 0 && (*objectKey != "" || *planInPath != "") {
+		exitErrorf("-prefix and -prefix-file can't be combined with -key or -plan-in")
+	}
+	if *keysFromS3 != "" {
+		if _, _, err := parseManifestURL(*keysFromS3); err != nil {
+			exitErrorf("-keys-from-s3 %v", err)
+		}
+		if discovering || *planInPath != "" || *objectKey != "" || *fakeKeys > 0 || filtering() {
+			exitErrorf("-keys-from-s3 works on a single bucket given with -b, without -plan-in, -key, -fake or filters")
+		}
+		if *manifestBuckets && *bucketName != "unknown" {
+			exitErrorf("-manifest-buckets takes its buckets from the manifest, not -b")
+		}
+	} else if *manifestBuckets {
+		exitErrorf("-manifest-buckets needs a -keys-from-s3 manifest")
+	}
+	if *objectKey != "" && (discovering || *planInPath != "") {
+		exitErrorf("-key works on a single bucket given with -b")
+	}
+	if *bucketListPath != "" {
+		if !*listRegionsOnly {
+			exitErrorf("-bucket-list only works with -list-regions-of-buckets")
+		}
+		if *bucketName != "unknown" || discovering {
+			exitErrorf("-bucket-list can't be combined with -b or -name-prefix/-name-suffix")
+		}
+	}
+	if *listRegionsOnly && (*planInPath != "" || *fakeKeys > 0) {
+		exitErrorf("-list-regions-of-buckets takes buckets from -b, -bucket-list or -name-prefix/-name-suffix")
+	}
+	if *keyMarker != "" || *versionIDMarker != "" || *continuationToken != "" {
+		if *versionIDMarker != "" && *keyMarker == "" {
+			exitErrorf("-version-id-marker needs the -key-marker it is a version of")
+		}
+		if discovering || *planInPath != "" || *objectKey != "" || *keysFromS3 != "" || *keepVersions > 0 {
+			exitErrorf("-key-marker, -version-id-marker and -continuation-token resume a single bucket's listing given with -b, without -plan-in, -key, -keys-from-s3 or -keep-versions")
+		}
+	}
+	if *bucketName == "unknown" && !discovering && *planInPath == "" && *bucketListPath == "" && !*manifestBuckets {
+		exitErrorf("You must specify a bucket name with -b, or -name-prefix/-name-suffix/-match-regex")
+	}
+	if *bucketName != "unknown" && discovering {
+		exitErrorf("-b can't be combined with -name-prefix/-name-suffix/-match-regex")
+	}
+	if *lowMemory {
+		applyLowMemory()
+	}
+	switch *deleteOrder {
+	case "key-asc", "modified-desc", "modified-asc":
+	default:
+		exitErrorf("-delete-order must be key-asc, modified-desc or modified-asc")
+	}
+	switch *order {
+	case "markers-first", "versions-first", "interleaved", "shuffled", "per-key-versions-first", "per-key-markers-first":
+	default:
+		exitErrorf("-order must be markers-first, versions-first, interleaved, shuffled, per-key-versions-first or per-key-markers-first")
+	}
+	if perKeyOrder() && *versionedBatch {
+		exitErrorf("-order %s can't be combined with -include-versioned-batch, which sends a key's versions and markers in one request", *order)
+	}
+	if *order == "shuffled" && *deleteOrder != "key-asc" {
+		exitErrorf("-order shuffled can't be combined with -delete-order")
+	}
+	if *shuffleWithinPage && *deleteOrder != "key-asc" {
+		exitErrorf("-shuffle-within-page can't be combined with -delete-order")
+	}
+	switch *listingError {
+	case "retry", "skip-page", "abort":
+	default:
+		exitErrorf("-listing-error must be retry, skip-page or abort")
+	}
+	switch *backoffStrategy {
+	case "exponential", "constant", "linear":
+	default:
+		exitErrorf("-backoff-strategy must be exponential, constant or linear")
+	}
+	if *objectTimeout < 0 {
+		exitErrorf("-per-object-timeout can't be negative")
+	}
+	if *retryBudget <= 0 {
+		exitErrorf("-object-retry-budget must be positive")
+	}
+	if *retryInterval <= 0 {
+		exitErrorf("-retry-interval must be positive")
+	}
+	if *listRetryInitial <= 0 || *listRetryMaxInterval <= 0 || *listRetryMaxElapsed <= 0 {
+		exitErrorf("-list-retry-initial, -list-retry-max-interval and -list-retry-max-elapsed must be positive")
+	}
+	if *retryJitter < 0 || *retryJitter > 1 {
+		exitErrorf("-retry-jitter must be between 0 and 1")
+	}
+	if *retentionReport != "" && (*listUploadsOnly || *planInPath != "" || *objectKey != "" || *keysFromS3 != "") {
+		exitErrorf("-version-retention-report can't be combined with -list-incomplete-uploads, -plan-in, -key or -keys-from-s3")
+	}
+	if *uploadsPrefix != "" && !*listUploadsOnly {
+		exitErrorf("-uploads-prefix only applies to -list-incomplete-uploads")
+	}
+	if *uploadsOlderThan < 0 {
+		exitErrorf("-abort-uploads-older-than can't be negative")
+	}
+	if *autoRetryFailures < 0 {
+		exitErrorf("-auto-retry-failures can't be negative")
+	}
+	if *retryPasses < 0 {
+		exitErrorf("-retry-failed-passes can't be negative")
+	}
+	if *onError != "continue" && *onError != "abort" {
+		exitErrorf("-on-error must be continue or abort")
+	}
+	if *abortTimeout < 0 {
+		exitErrorf("-abort-timeout can't be negative")
+	}
+	if *batchSize < 1 || *batchSize > maxBatchSize {
+		clamped := 1
+		if *batchSize > maxBatchSize {
+			clamped = maxBatchSize
+		}
+		WarningLogger.Printf("-batch-size must be between 1 and %d, using %d\n", maxBatchSize, clamped)
+		*batchSize = clamped
+	}
+	if *batchMaxBytes < 1 {
+		exitErrorf("-batch-max-bytes must be at least 1")
+	}
+	if *versionedBatch && !*batchDeletes {
+		exitErrorf("-include-versioned-batch needs -batch")
+	}
+	if *concurrency < 1 {
+		exitErrorf("-concurrency must be at least 1")
+	}
+	if *parallelPages < 1 || *parallelPages > maxParallelPages {
+		exitErrorf("-parallel-pages must be between 1 and %d", maxParallelPages)
+	}
+	if *workersPerPage < 0 {
+		exitErrorf("-workers-per-page can't be negative")
+	}
+	if *interBucketDelay < 0 {
+		exitErrorf("-inter-bucket-delay can't be negative")
+	}
+	if *bucketConcurrency < 1 {
+		exitErrorf("-bucket-concurrency must be at least 1")
+	}
+	if *rampUp < 0 {
+		exitErrorf("-ramp-up can't be negative")
+	}
+	if *rampUp > 0 && *adaptive {
+		exitErrorf("-ramp-up can't be combined with -adaptive, which already starts low")
+	}
+	if *bucketDeleteGrace < 0 {
+		exitErrorf("-bucket-delete-grace can't be negative")
+	}
+	if *bucketDeleteDelay < 0 {
+		exitErrorf("-bucket-delete-delay can't be negative")
+	}
+	if *confirmBucketDelete && *deleteBucketOnly {
+		exitErrorf("-confirm-before-bucket-delete can't be combined with -delete-bucket-only, which has no emptying to review")
+	}
+	if *deadlinePer10k < 0 || *paceAfter < 0 || *expectedObjects < 0 {
+		exitErrorf("-deadline-per-10k, -pace-after and -expected-objects can't be negative")
+	}
+	if *paceAbort && *deadlinePer10k == 0 {
+		exitErrorf("-pace-abort needs -deadline-per-10k")
+	}
+	if (*deadlinePer10k > 0 || *expectedObjects > 0) && *dryRun {
+		exitErrorf("-deadline-per-10k and -expected-objects pace real deletes, not a -dry-run")
+	}
+	if *showDashboard && (*quiet || *showProgress) {
+		exitErrorf("-tui can't be combined with -q or -progress")
+	}
+	if *heartbeatEvery < 0 {
+		exitErrorf("-heartbeat can't be negative")
+	}
+	if *maxAutoDelete < 0 {
+		exitErrorf("-max-auto-delete-objects can't be negative")
+	}
+	if *sampleKeys < 0 || *sampleKeys > 1000 {
+		exitErrorf("-sample-keys must be between 0 and 1000")
+	}
+	if *maxBandwidth < 0 {
+		exitErrorf("-max-bandwidth can't be negative")
+	}
+	if *rateLimit < 0 {
+		exitErrorf("-rate can't be negative")
+	}
+	if *listRate < 0 {
+		exitErrorf("-list-rate can't be negative")
+	}
+	if *capConcurrency && *rateLimit == 0 {
+		exitErrorf("-cap-concurrency needs -rate")
+	}
+	checkIdleWorkers()
+	if *maxIdleConns < 0 || *maxConnsPerHost < 0 {
+		exitErrorf("-max-idle-conns and -max-conns-per-host can't be negative")
+	}
+	if *maxGoroutines < 0 {
+		exitErrorf("-max-goroutines can't be negative")
+	}
+	if *maxGoroutines > 0 {
+		goroutineSlots = make(chan struct{}, *maxGoroutines)
+	}
+	if *adaptiveMin < 1 || *adaptiveMin > *adaptiveMax {
+		exitErrorf("-adaptive-min must be at least 1 and no larger than -adaptive-max")
+	}
+	if *maxSize > 0 && *minSize > *maxSize {
+		exitErrorf("-min-size must not be larger than -max-size")
+	}
+	if !modifiedBefore.t.IsZero() && !modifiedAfter.t.Before(modifiedBefore.t) {
+		exitErrorf("-modified-after must be earlier than -modified-before")
+	}
+	if *objectTag != "" {
+		parts := strings.SplitN(*objectTag, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			exitErrorf("-object-tag must be in the form key=value")
+		}
+		tagKey, tagValue = parts[0], parts[1]
+	}
+	if *requireBucketTag != "" {
+		parts := strings.SplitN(*requireBucketTag, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			exitErrorf("-require-bucket-tag must be in the form key=value")
+		}
+		ownerTagKey, ownerTagValue = parts[0], parts[1]
+	}
+	if *ttlTag != "" {
+		InfoLogger.Printf("-ttl-tag reads the %s tag of every listed version, one GetObjectTagging request each\n", *ttlTag)
+	}
+	if *roleChain != "" {
+		arns, err := parseRoleChain(*roleChain)
+		if err != nil {
+			exitErrorf("-role-chain: %v", err)
+		}
+		if *fakeKeys == 0 {
+			if roleCredentials, err = assumeRoleChain(arns); err != nil {
+				exitErrorf("Unable to assume -role-chain: %v", err)
+			}
+		}
+	}
+	if *regionMapPath != "" {
+		n, err := loadRegionMap(*regionMapPath)
+		if err != nil {
+			exitErrorf("Unable to read region map %s: %v", *regionMapPath, err)
+		}
+		InfoLogger.Printf("Region map %s covers %d buckets\n", *regionMapPath, n)
+	}
+	if *listRegionsOnly {
+		buckets := []string{*bucketName}
+		switch {
+		case *bucketListPath != "":
+			var err error
+			if buckets, err = readBucketList(*bucketListPath); err != nil {
+				exitErrorf("Unable to read bucket list %s: %v", *bucketListPath, err)
+			}
+		case discovering:
+			buckets = discoverBuckets()
+		}
+		os.Exit(listBucketRegions(buckets))
+	}
+	buckets := []string{*bucketName}
+	if *manifestBuckets {
+		buckets = nil
+	}
+	if *planInPath != "" {
+		var err error
+		if loadedPlan, err = readPlan(*planInPath); err != nil {
+			exitErrorf("Unable to read plan %s: %v", *planInPath, err)
+		}
+		buckets = loadedPlan.buckets
+		InfoLogger.Printf("Plan %s covers %d buckets\n", *planInPath, len(buckets))
+	}
+	if *planDiffPath != "" {
+		var err error
+		if planDiff, err = newPlanDiffer(*planDiffPath, *planDiffOut); err != nil {
+			exitErrorf("Unable to use -plan-diff %s: %v", *planDiffPath, err)
+		}
+	}
+	if *planOutPath != "" {
+		var err error
+		if planOut, err = createPlan(*planOutPath); err != nil {
+			exitErrorf("Unable to create plan %s: %v", *planOutPath, err)
+		}
+	}
+	if discovering {
+		buckets = discoverBuckets()
+		resolveRegions(buckets)
+		if !*crossAccount {
+			buckets = checkOwnership(buckets)
+		}
+		if len(buckets) == 0 {
+			exitErrorf("No buckets matched")
+		}
+	} else if *safe && !*crossAccount && loadedPlan == nil && *fakeKeys == 0 && *endpointURL == "" && !readOnly() {
+		if len(checkOwnership(buckets)) == 0 && !(*ignoreMissing && bucketMissing(*bucketName)) {
+			exitErrorf("-safe: %s can't be confirmed as owned by this account, nothing was deleted (-allow-cross-account skips the check)", *bucketName)
+		}
+	}
+	if *showConfig {
+		printConfig(buckets)
+	}
+	if discovering {
+		confirmBuckets(buckets)
+	} else if *manifestBuckets {
+		if !*dryRun {
+			confirmManifestBuckets()
+		}
+	} else if loadedPlan == nil && !*dryRun && !readOnly() {
+		confirmBucket(*bucketName)
+	}
+
+	if *backupTo != "" {
+		var err error
+		if backup, err = newBackupTarget(*backupTo); err != nil {
+			exitErrorf("Unable to use -backup-to %s: %v", *backupTo, err)
+		}
+		for _, bucket := range buckets {
+			if bucket == backup.bucket {
+				exitErrorf("-backup-to can't point into %s, which is being deleted", bucket)
+			}
+		}
+	}
+
+	seedShuffle(*seed)
+
+	limiter = newDeleteLimiter()
+	if *listRate > 0 {
+		//Pages are fetched one after another, so there is no burst to allow for
+		listLimiter = rate.NewLimiter(rate.Limit(*listRate), 1)
+	}
+
+	if *maxBandwidth > 0 {
+		bandwidthLimiter = newBandwidthLimiter(*maxBandwidth)
+	}
+
+	watchConcurrencySignals()
+	start := time.Now()
+	var sampler *throughputSampler
+	if *throughputReport || *throughputCSV != "" {
+		sampler = startThroughputSampler()
+	}
+	var progress *progressLine
+	if *showProgress {
+		progress = startProgress()
+	}
+	if *showDashboard {
+		dashboard = startDashboard(start)
+	}
+	var beat *heartbeat
+	if *heartbeatEvery > 0 {
+		beat = startHeartbeat(*heartbeatEvery)
+	}
+	stopPace := func() {}
+	if *deadlinePer10k > 0 || *expectedObjects > 0 {
+		stopPace = startPaceWatch(start)
+	}
+	stopMetrics := func() {}
+	if *cloudWatchNamespace != "" {
+		stopMetrics = startMetricsPush(*statsInterval)
+	}
+	stopSummary := func() {}
+	if *summaryEvery > 0 {
+		stopSummary = startPeriodicSummary(*summaryEvery, start)
+	}
+	stopStatus := func() {}
+	if *statusAddr != "" {
+		var err error
+		if stopStatus, err = startStatusServer(*statusAddr, start); err != nil {
+			exitErrorf("Unable to serve -status-addr: %v", err)
+		}
+	}
+	if *otelEndpoint != "" {
+		tracer = newTraceExporter(*otelEndpoint)
+		runSpan = startSpan("delete run", nil)
+		runSpan.setInt("s3.buckets", int64(len(buckets)))
+	}
+	if (len(buckets) > 1 || *manifestBuckets) && !*perBucketPools {
+		var stopPool func()
+		sharedPool, stopPool = startPool("all buckets")
+		defer stopPool()
+	}
+	var wg sync.WaitGroup
+	running := make(chan struct{}, *bucketConcurrency)
+	for i, bucket := range buckets {
+		wg.Add(1)
+		running <- struct{}{}
+		if i > 0 && *interBucketDelay > 0 && runCtx.Err() == nil {
+			if *verbosity {
+				InfoLogger.Printf("Waiting %s before starting %s\n", *interBucketDelay, bucket)
+			}
+			select {
+			case <-time.After(*interBucketDelay):
+			case <-runCtx.Done():
+			}
+		}
+		go func(bucket string) {
+			defer wg.Done()
+			processBucket(bucket)
+			<-running
+		}(bucket)
+	}
+	wg.Wait()
+	if *manifestBuckets {
+		deleteManifestAcrossBuckets()
+	}
+	if progress != nil {
+		progress.stop()
+	}
+	if dashboard != nil {
+		dashboard.stop()
+	}
+	if beat != nil {
+		beat.stop()
+	}
+	stopPace()
+	stopMetrics()
+	stopSummary()
+	stopStatus()
+	if planOut != nil {
+		if err := planOut.close(); err != nil {
+			exitErrorf("Unable to write plan %s: %v", *planOutPath, err)
+		}
+		InfoLogger.Printf("Wrote plan to %s\n", *planOutPath)
+		if *auditDir != "" {
+			finishSchedule()
+		}
+	}
+	if auditLog != nil {
+		if err := auditLog.close(); err != nil {
+			ErrorLogger.Printf("Unable to write the audit log of run %s: %v\n", *runID, err)
+		}
+	}
+	if planDiff != nil {
+		if err := planDiff.close(*planDiffPath, *planDiffOut); err != nil {
+			ErrorLogger.Printf("Unable to write plan diff %s: %v\n", *planDiffOut, err)
+		}
+	}
+	if sampler != nil {
+		sampler.stop()
+		sampler.report()
+		if *throughputCSV != "" {
+			if err := sampler.writeCSV(*throughputCSV); err != nil {
+				WarningLogger.Printf("Unable to write throughput CSV %s: %v\n", *throughputCSV, err)
+			}
+		}
+	}
+	endRunSpan()
+	printSummary(start)
+	if *summaryJSON != "" {
+		if err := writeSummaryJSON(*summaryJSON, start); err != nil {
+			ErrorLogger.Printf("Unable to write summary JSON %s: %v\n", *summaryJSON, err)
+		}
+	}
+	if *metricsJSONOut != "" {
+		if err := writeMetricsLine(*metricsJSONOut, start); err != nil {
+			ErrorLogger.Printf("Unable to write metrics line %s: %v\n", *metricsJSONOut, err)
+		}
+	}
+	if *retentionReport != "" {
+		if err := writeRetentionReport(*retentionReport); err != nil {
+			ErrorLogger.Printf("Unable to write version retention report %s: %v\n", *retentionReport, err)
+		}
+	}
+	if *deletedARNsOut != "" {
+		if err := writeDeletedARNs(*deletedARNsOut); err != nil {
+			ErrorLogger.Printf("Unable to write deleted bucket ARNs %s: %v\n", *deletedARNsOut, err)
+		}
+	}
+	if *resultsOut != "" {
+		if err := writeResults(*resultsOut, start); err != nil {
+			ErrorLogger.Printf("Unable to write results %s: %v\n", *resultsOut, err)
+		}
+	}
+	if *junitOut != "" {
+		if err := writeJUnit(*junitOut, start); err != nil {
+			ErrorLogger.Printf("Unable to write JUnit report %s: %v\n", *junitOut, err)
+		}
+	}
+	if *reportBytes {
+		reportBandwidth(time.Since(start))
+	}
+	if backup != nil {
+		reportBackup()
+	}
+	if *quiet {
+		printResultLine(start)
+	}
+	if err := runStopped(); err != nil {
+		ErrorLogger.Printf("Run stopped early by a fatal error: %v\n", err)
+		os.Exit(1)
+	}
+	if code := runExitCode(); code != 0 {
+		os.Exit(code)
+	}
+}
+
+//usage is -h's flag list. -fake is left out of it: it is for demos and testing the CLI, not real runs.
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s:\n", os.Args[0])
+	flag.VisitAll(func(f *flag.Flag) {
+		if f.Name == "fake" {
+			return
+		}
+		name, usage := flag.UnquoteUsage(f)
+		switch {
+		case f.DefValue == "" || f.DefValue == "0" || f.DefValue == "false" || f.DefValue == "0s":
+		case name == "string":
+			usage += fmt.Sprintf(" (default %q)", f.DefValue)
+		default:
+			usage += fmt.Sprintf(" (default %v)", f.DefValue)
+		}
+		fmt.Fprintf(flag.CommandLine.Output(), "  -%s %s\n    \t%s\n", f.Name, name, strings.ReplaceAll(usage, "\n", "\n    \t"))
+	})
+}
+
+//readOnly reports whether the run only reports on the buckets, so nothing needs confirming or checking first
+func readOnly() bool {
+	return *listUploadsOnly || *retentionReport != ""
+}
+
+//processBucket empties one bucket and then deletes it, unless something means it has to be kept
+func processBucket(bucketName string) {
+	bucketRegion := getRegion(bucketName)
+	if bucketRegion == "unknown" {
+		if *ignoreMissing && bucketMissing(bucketName) {
+			InfoLogger.Printf("Bucket %s doesn't exist, already deleted\n", bucketName)
+			return
+		}
+		if *namePrefix == "" && *nameSuffix == "" && *matchRegex == "" && loadedPlan == nil {
+			exitErrorf("Unable to find bucket for %s\n", bucketName)
+		}
+		//One bucket of many whose region can't be found shouldn't stop the others
+		err := fmt.Errorf("unable to find the region of %s", bucketName)
+		ErrorLogger.Printf("%v\n", err)
+		recordBucketFailure(bucketName, err)
+		return
+	}
+	InfoLogger.Printf("Bucket %s was found in %s\n", bucketName, bucketRegion)
+	if *verbosity && *fakeKeys == 0 {
+		logWhoami(bucketName, bucketRegion)
+	}
+
+	sess, err := newSession(bucketRegion)
+	if err != nil {
+		exitErrorf("Unable to setup s3 connection: %v", err)
+	}
+	svc := newS3Client(sess)
+	if *fakeKeys > 0 {
+		svc = newFakeS3(bucketName, *fakeKeys)
+	}
+
+	ctx, cancel := context.WithCancel(runCtx)
+	defer cancel()
+	if *perBucketTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *perBucketTimeout)
+		defer cancel()
+	}
+
+	j := &bucketJob{
+		ctx:     ctx,
+		cancel:  cancel,
+		name:    bucketName,
+		region:  bucketRegion,
+		svc:     svc,
+		pool:    sharedPool,
+		started: time.Now(),
+	}
+	defer recordBucketResult(j)
+	trackMetrics(j)
+	defer untrackMetrics(j)
+	j.span = startSpan("bucket "+bucketName, runSpan)
+	j.span.setString("s3.bucket", bucketName)
+	j.span.setString("s3.region", bucketRegion)
+	defer j.endBucketSpan()
+	if j.pool == nil {
+		var stopPool func()
+		j.pool, stopPool = startPool(bucketName)
+		defer stopPool()
+	}
+
+	if err := j.preflight(); err != nil {
+		if _, missing := err.(*ErrBucketNotFound); missing && *ignoreMissing {
+			InfoLogger.Printf("Bucket %s doesn't exist, already deleted\n", bucketName)
+			return
+		}
+		ErrorLogger.Printf("Preflight failed: %v\n", err)
+		recordBucketFailure(bucketName, err)
+		return
+	}
+
+	if !readOnly() {
+		if err := j.checkMFADelete(); err != nil {
+			if j.timedOut() {
+				j.failTimeout()
+				return
+			}
+			ErrorLogger.Printf("Not emptying %s: %v\n", bucketName, err)
+			recordBucketFailure(bucketName, err)
+			return
+		}
+		if err := j.checkReplication(); err != nil {
+			ErrorLogger.Printf("Not emptying %s: %v\n", bucketName, err)
+			recordBucketFailure(bucketName, err)
+			return
+		}
+		//S3-compatible stores and the fake bucket have no S3 Control API to ask
+		if *endpointURL == "" && *fakeKeys == 0 {
+			j.checkAccessPoints()
+		}
+	}
+
+	if ownerTagKey != "" && !readOnly() && !j.checkOwnerTag() {
+		return
+	}
+
+	if *listUploadsOnly {
+		if !j.listUploads() {
+			j.failTimeout()
+		}
+		return
+	}
+	if *retentionReport != "" {
+		if !j.reportRetention() {
+			j.failTimeout()
+		}
+		return
+	}
+
+	if *sampleKeys > 0 && !*dryRun && !j.sampleAndConfirm() {
+		return
+	}
+
+	if loadedPlan != nil {
+		if err := j.executePlan(); err != nil {
+			j.fail(err)
+		}
+		return
+	}
+
+	if *objectKey != "" {
+		if !j.deleteKeyVersions() {
+			j.failTimeout()
+		}
+		return
+	}
+
+	if *keysFromS3 != "" {
+		if !j.deleteManifestKeys() {
+			j.failTimeout()
+		}
+		return
+	}
+
+	if *dryRun {
+		j.dryRun()
+		return
+	}
+
+	if *estimateSample > 0 {
+		if !j.estimateRun() {
+			j.failTimeout()
+		}
+		return
+	}
+
+	if *deleteBucketOnly {
+		err := j.removeBucket()
+		if err == nil {
+			j.stats.bucketDeleted = true
+			InfoLogger.Printf("Deleted bucket %s", bucketName)
+			return
+		}
+		if j.timedOut() {
+			j.failTimeout()
+			return
+		}
+		if !isBucketNotEmpty(err) || *noEmptyFallback {
+			ErrorLogger.Printf("Unable to delete bucket %s: %v\n", bucketName, err)
+			recordBucketFailure(bucketName, bucketError(bucketName, err))
+			return
+		}
+		WarningLogger.Printf("Bucket %s is not empty, emptying it first\n", bucketName)
+	}
+
+	if *maxAutoDelete > 0 {
+		if err := j.checkSizeGuard(); err != nil {
+			if j.timedOut() {
+				j.failTimeout()
+				return
+			}
+			ErrorLogger.Printf("Not emptying %s: %v\n", bucketName, err)
+			recordBucketFailure(bucketName, err)
+			return
+		}
+	}
+
+	if *removePolicyFirst {
+		j.removeBucketPolicy()
+	}
+	if *suspendVersion {
+		if err := j.suspendVersioning(); err != nil {
+			j.fail(err)
+			return
+		}
+	}
+
+	if !filtering() && !j.abortUploads() {
+		j.failTimeout()
+		return
+	}
+
+	if *warmUp {
+		j.warmUp()
+	}
+	if err := j.deleteAllVersions(); err != nil {
+		j.fail(err)
+		return
+	}
+	for pass := 1; pass <= *retryPasses; pass++ {
+		failed := j.resetFailed()
+		if failed == 0 {
+			break
+		}
+		WarningLogger.Printf("%d deletes failed in %s, emptying it again (retry pass %d of %d)\n", failed, bucketName, pass, *retryPasses)
+		if err := j.deleteAllVersions(); err != nil {
+			j.fail(err)
+			return
+		}
+	}
+	if err := j.retryFailed(); err != nil {
+		j.fail(err)
+		return
+	}
+	j.finishEmptied()
+}
+
+//finishEmptied is the gate between emptying a bucket and deleting it, for a full run and a -plan-in one alike:
+//deletes that failed or listings cut short keep the bucket, as do filters that left anything behind, and with
+//-verify or -bucket-delete-grace the bucket has to be seen empty before it is deleted
+func (j *bucketJob) finishEmptied() {
+	bucketName := j.name
+	if err := j.partialFailure(); err != nil {
+		recordBucketFailure(bucketName, err)
+		if !*deleteIfFailed {
+			ErrorLogger.Printf("Bucket %s not deleted: %d objects failed\n", bucketName, len(err.(*ErrPartialFailure).FailedKeys))
+			return
+		}
+		ErrorLogger.Printf("%v\n", err)
+	}
+	if j.listingSkipped > 0 {
+		err := fmt.Errorf("bucket %s may not be empty, %d listings were cut short by -listing-error=skip-page", bucketName, j.listingSkipped)
+		ErrorLogger.Printf("Not deleting bucket %s: %v\n", bucketName, err)
+		recordBucketFailure(bucketName, err)
+		return
+	}
+	if archived := atomic.LoadInt64(&j.stats.archivedSkipped); archived > 0 {
+		WarningLogger.Printf("Skipped %d archived objects, not deleting bucket %s\n", archived, bucketName)
+		return
+	}
+	if filtering() {
+		if force, err := j.forceBucketDelete(); !force {
+			if err != nil {
+				j.fail(err)
+			}
+			return
+		}
+	}
+	if *verify && !*skipVerify {
+		if err := j.verifyEmpty(); err != nil {
+			j.fail(err)
+			return
+		}
+	}
+	if *bucketDeleteGrace > 0 && !*skipVerify {
+		if err := j.graceWait(); err != nil {
+			j.fail(err)
+			return
+		}
+	}
+	j.deleteBucket()
+}
+
+//graceWait is -bucket-delete-grace: on eventually consistent stores DeleteBucket straight after the last delete
+//can fail with BucketNotEmpty, so it waits, re-lists, and runs the verify passes if anything is still listed.
+//It returns why the bucket can't be deleted, if it can't.
+func (j *bucketJob) graceWait() error {
+	if *verbosity {
+		InfoLogger.Printf("Waiting %s before deleting bucket %s\n", *bucketDeleteGrace, j.name)
+	}
+	timer := time.NewTimer(*bucketDeleteGrace)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-j.ctx.Done():
+		return j.ctx.Err()
+	}
+	empty, err := j.isBucketEmpty()
+	if err != nil || empty {
+		return err
+	}
+	WarningLogger.Printf("%s still lists entries after emptying, verifying before deleting it\n", j.name)
+	return j.verifyEmpty()
+}
+
+//preflight confirms the bucket exists and is accessible before anything destructive happens
+func (j *bucketJob) preflight() error {
+	_, err := j.svc.HeadBucketWithContext(j.ctx, &s3.HeadBucketInput{
+		Bucket: aws.String(j.name),
+	})
+	if err != nil && j.followRedirect(err) {
+		return j.preflight()
+	}
+	if err != nil {
+		err = bucketError(j.name, err)
+		if _, denied := err.(*ErrAccessDenied); denied {
+			ErrorLogger.Printf("Access denied to bucket %s, check the credentials in use have s3:ListBucket on it\n", j.name)
+		}
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == ssocreds.ErrCodeSSOProviderInvalidToken {
+			ErrorLogger.Printf("The SSO login of the profile has expired or is missing, run aws sso login again\n")
+		}
+		return err
+	}
+	if *verbosity {
+		InfoLogger.Printf("Preflight passed for %s\n", j.name)
+	}
+	return nil
+}
+
+//warmUp sends one HeadBucket through the client the deletes will use, just before the worker pool starts, so the
+//DNS lookup, TLS handshake and a pooled connection are in place instead of every worker setting them up at once.
+//Preflight did the same, but the checks since then can take long enough for that connection to have gone idle.
+//A failure changes nothing: the deletes set up their own connections as they would have anyway.
+func (j *bucketJob) warmUp() {
+	started := time.Now()
+	_, err := deleteClient(j.svc).HeadBucketWithContext(j.ctx, &s3.HeadBucketInput{
+		Bucket: aws.String(j.name),
+	})
+	if !*verbosity {
+		return
+	}
+	if err != nil {
+		WarningLogger.Printf("Warm-up HeadBucket on %s failed after %s: %v\n", j.name, time.Since(started).Round(time.Millisecond), err)
+		return
+	}
+	InfoLogger.Printf("Warmed up the connection to %s in %s\n", j.name, time.Since(started).Round(time.Millisecond))
+}
+
+//dryRun lists the bucket like a real run would and reports what would happen to it without changing anything
+func (j *bucketJob) dryRun() {
+	if planDiff != nil {
+		planDiff.check(j.name)
+	}
+	if *removePolicyFirst {
+		InfoLogger.Printf("Would remove the bucket policy of %s\n", j.name)
+	}
+	if *suspendVersion {
+		InfoLogger.Printf("Would suspend versioning on %s\n", j.name)
+	}
+	if !filtering() && !j.abortUploads() {
+		j.failTimeout()
+		return
+	}
+	if err := j.deleteAllVersions(); err != nil {
+		j.fail(err)
+		return
+	}
+	InfoLogger.Printf("Would delete %d entries from %s\n", atomic.LoadInt64(&j.stats.planned), j.name)
+	if *probe {
+		j.probeDelete()
+	}
+	if atomic.LoadInt64(&j.stats.archivedSkipped) > 0 || filtering() {
+		if filtering() && *forceBucketDelete {
+			InfoLogger.Printf("Would keep bucket %s, unless %s match everything in it (-force-bucket-delete)\n", j.name, activeFilters())
+			return
+		}
+		InfoLogger.Printf("Would keep bucket %s\n", j.name)
+		return
+	}
+	InfoLogger.Printf("Would delete bucket %s\n", j.name)
+	if planOut != nil {
+		planOut.add(j.name, planBucketRow, "", "")
+	}
+}
+
+//forceBucketDelete decides about a bucket emptied with filters set, which is kept unless -force-bucket-delete
+//is set and the filters turn out to have matched everything in it. Incomplete multipart uploads, left alone while
+//filtering, are aborted before it goes. It reports whether to go on deleting the bucket, and the error if
+//finding out failed.
+func (j *bucketJob) forceBucketDelete() (bool, error) {
+	if !*forceBucketDelete {
+		InfoLogger.Printf("Filters are active (%s), not deleting bucket %s\n", activeFilters(), j.name)
+		return false, nil
+	}
+	empty, err := j.isBucketEmpty()
+	if err != nil {
+		return false, err
+	}
+	if !empty {
+		InfoLogger.Printf("Not deleting bucket %s: it still holds entries %s didn't match\n", j.name, activeFilters())
+		return false, nil
+	}
+	if !j.abortUploads() {
+		return false, j.ctx.Err()
+	}
+	InfoLogger.Printf("%s matched everything in %s, deleting the bucket because of -force-bucket-delete\n", activeFilters(), j.name)
+	return true, nil
+}
+
+//timedOut reports whether the bucket's work is over early: its -per-bucket-timeout ran out, or it was stopped
+//by a fatal error. Either way everything in flight is winding down.
+func (j *bucketJob) timedOut() bool {
+	return j.ctx.Err() != nil
+}
+
+//failTimeout records that the bucket ran out of -per-bucket-timeout, so the run can move on to the next one.
+//A bucket that was stopped instead has already reported why.
+func (j *bucketJob) failTimeout() {
+	if j.ctx.Err() == context.Canceled {
+		return
+	}
+	ErrorLogger.Printf("Bucket %s timed out after %s, moving on\n", j.name, *perBucketTimeout)
+	recordBucketFailure(j.name, errors.New("timed out"))
+}
+
+//fail records why the bucket's work stopped before it was done and moves on to the next bucket. Running out of
+//time is failTimeout's to report; anything else is logged and recorded, for exitCode to map to the exit status.
+func (j *bucketJob) fail(err error) {
+	if j.timedOut() {
+		j.failTimeout()
+		return
+	}
+	ErrorLogger.Printf("Giving up on %s: %v\n", j.name, err)
+	recordBucketFailure(j.name, err)
+}
+
+//newSession builds an AWS session for a region using the shared config and credentials
+func newSession(region string) (*session.Session, error) {
+	config := aws.Config{
+		Region:     aws.String(region),
+		HTTPClient: sharedHTTPClient(),
+	}
+	if roleCredentials != nil {
+		config.Credentials = roleCredentials
+	}
+	if *endpointURL != "" {
+		//S3-compatible stores and emulators generally don't do virtual-hosted buckets
+		config.Endpoint = aws.String(*endpointURL)
+		config.S3ForcePathStyle = aws.Bool(true)
+	}
+	if *disableChecksum {
+		//For older S3-compatible stores that choke on what the SDK adds to requests or checks on responses
+		config.DisableComputeChecksums = aws.Bool(true)
+		config.S3DisableContentMD5Validation = aws.Bool(true)
+		config.S3Disable100Continue = aws.Bool(true)
+	}
+	options := session.Options{
+		Config:            config,
+		Profile:           *profile,
+		SharedConfigState: session.SharedConfigEnable,
+	}
+	if *credentialsFile != "" {
+		//Replaces the credentials file only, profiles in the config file still apply
+		configFile := os.Getenv("AWS_CONFIG_FILE")
+		if configFile == "" {
+			configFile = defaults.SharedConfigFilename()
+		}
+		options.SharedConfigFiles = []string{*credentialsFile, configFile}
+	}
+	sess, err := session.NewSessionWithOptions(options)
+	if err != nil {
+		return nil, err
+	}
+	trackCredentials(sess.Config.Credentials)
+	return sess, nil
+}
+
+var (
+	regionCacheMu sync.Mutex
+	regionCache   = map[string]string{}
+	//Buckets getRegion was told don't exist
+	missingBuckets = map[string]bool{}
+)
+
+//bucketMissing reports whether getRegion found that the bucket doesn't exist, as opposed to failing to look it up
+func bucketMissing(bucketName string) bool {
+	regionCacheMu.Lock()
+	defer regionCacheMu.Unlock()
+	return missingBuckets[bucketName]
+}
+
+//getRegion looks up a bucket's region, remembering the answer for the rest of the run
+func getRegion(bucketName string) string {
+	regionCacheMu.Lock()
+	region, ok := regionCache[bucketName]
+	regionCacheMu.Unlock()
+	if ok {
+		return region
+	}
+
+	sess := session.Must(newSession("us-west-2"))
+	ctx := context.Background()
+	region, err := s3manager.GetBucketRegion(ctx, sess, bucketName, "us-west-2")
+	if err != nil {
+		region = regionFromHeadBucket(sess, bucketName)
+		if region == "" {
+			if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "NotFound" {
+				regionCacheMu.Lock()
+				missingBuckets[bucketName] = true
+				regionCacheMu.Unlock()
+			}
+			return "unknown"
+		}
+		if *verbosity {
+			InfoLogger.Printf("GetBucketRegion failed for %s (%v), found %s from a signed HeadBucket\n", bucketName, err, region)
+		}
+	}
+	regionCacheMu.Lock()
+	regionCache[bucketName] = region
+	regionCacheMu.Unlock()
+	return region
+}
+
+//deleteS3Object deletes one entry, retrying with backoff. Failures are logged and swallowed,
+//except fatal ones (see isFatal) which are returned to cancel the rest of the page.
+func (j *bucketJob) deleteS3Object(ctx context.Context, s3Object s3.DeleteObjectInput, entry s3Entry) error {
+	defer j.pool.release()
+	deleteType, size := entry.Type, entry.Size
+	if ctx.Err() != nil {
+		return nil
+	}
+	if backup != nil {
+		copied, err := j.backupEntries(ctx, []s3Entry{{Key: s3Object.Key, VersionId: s3Object.VersionId, Size: size, Type: deleteType}})
+		if err != nil || len(copied) == 0 {
+			return err
+		}
+	}
+	waitBandwidth(ctx, size)
+
+	attempt := 1
+	policy := &retryAfterBackOff{BackOff: newDeleteBackOff()}
+	err := backoff.RetryNotify(func() error {
+		if limiter != nil {
+			//Cancelled, or no token comes before the context's deadline: deleting anyway would overrun -rate
+			if err := limiter.Wait(ctx); err != nil {
+				return backoff.Permanent(err)
+			}
+		}
+		attemptCtx, cancel := attemptContext(ctx)
+		_, err := j.svc.DeleteObjectWithContext(attemptCtx, &s3Object, policy.capture())
+		hung := attemptTimedOut(ctx, attemptCtx, err)
+		cancel()
+		if *verbosity {
+			InfoLogger.Printf("RT: %d Deleting %s: %s\n", attempt, aws.StringValue(s3Object.Key), aws.StringValue(s3Object.VersionId))
+		}
+		if hung {
+			tallyError(attemptTimeoutCode, hintNetwork)
+			WarningLogger.Printf("RT: %d Delete of %s %s: %s got no answer within %s, retrying\n", attempt, deleteType, aws.StringValue(s3Object.Key), aws.StringValue(s3Object.VersionId), *objectTimeout)
+			attempt++
+			return err
+		}
+		if err != nil {
+			recordError(err)
+			checkAccessDenied(err)
+			if refreshExpiredCredentials(err) {
+				attempt++
+				return err
+			}
+			if isFatal(err) || isNoSuchBucket(err) {
+				return backoff.Permanent(err)
+			}
+			if isThrottle(err) {
+				j.pool.recordThrottle()
+			}
+			if *verbosity {
+				WarningLogger.Printf("RT: %d Unable to delete %s %s: %s: %s\n", attempt, deleteType, aws.StringValue(s3Object.Key), aws.StringValue(s3Object.VersionId), attemptFields(err))
+			}
+			if !retryDelete(err) {
+				return backoff.Permanent(err)
+			}
+			attempt++
+			return err
+		} else {
+			atomic.AddInt64(&deletedCount, 1)
+			atomic.AddInt64(&j.stats.deleted, 1)
+			recordFreed(size)
+			j.recordDeleted(entry)
+			if *verbosity {
+				InfoLogger.Printf("RT: %d Deleted %s: %s\n", attempt, aws.StringValue(s3Object.Key), aws.StringValue(s3Object.VersionId))
+			}
+			return nil
+		}
+
+	}, backoff.WithContext(policy, ctx), countRetry)
+	if err != nil {
+		if j.stopIfFatal(err) {
+			return err
+		}
+		//Cancelled because another delete hit a fatal error, which is what gets reported
+		if ctx.Err() != nil {
+			return nil
+		}
+		ErrorLogger.Printf("Unable to delete after %d attempts: %s %s: %s: %v\n", attempt, deleteType, aws.StringValue(s3Object.Key), aws.StringValue(s3Object.VersionId), err)
+		j.recordFailed(s3Entry{Key: s3Object.Key, VersionId: s3Object.VersionId, Size: size, Type: deleteType})
+		if *onError == "abort" {
+			err = fmt.Errorf("%s %s %s failed with -on-error=abort: %w", deleteType, aws.StringValue(s3Object.Key), aws.StringValue(s3Object.VersionId), err)
+			stopRun(err)
+			return err
+		}
+		return nil
+	}
+	if *verifyDeletes {
+		j.verifyDeleted(ctx, s3Object, deleteType, size)
+	}
+	return nil
+}
+
+//s3Entry is the common shape of a listed delete marker, version or object
+type s3Entry struct {
+	Key          *string
+	VersionId    *string
+	Size         int64
+	StorageClass *string
+	LastModified *time.Time
+	Type         string
+}
+
+func markerEntries(deleteMarkers []*s3.DeleteMarkerEntry) []s3Entry {
+	entries := make([]s3Entry, 0, len(deleteMarkers))
+	for _, deleteMarker := range deleteMarkers {
+		entries = append(entries, s3Entry{
+			Key:          deleteMarker.Key,
+			VersionId:    deleteMarker.VersionId,
+			LastModified: deleteMarker.LastModified,
+			Type:         "Marker",
+		})
+	}
+	return entries
+}
+
+func versionEntries(versions []*s3.ObjectVersion) []s3Entry {
+	entries := make([]s3Entry, 0, len(versions))
+	for _, version := range versions {
+		entries = append(entries, s3Entry{
+			Key:          version.Key,
+			VersionId:    version.VersionId,
+			Size:         aws.Int64Value(version.Size),
+			StorageClass: version.StorageClass,
+			LastModified: version.LastModified,
+			Type:         "Version",
+		})
+	}
+	return entries
+}
+
+func objectEntries(objects []*s3.Object) []s3Entry {
+	entries := make([]s3Entry, 0, len(objects))
+	for _, content := range objects {
+		entries = append(entries, s3Entry{
+			Key:          content.Key,
+			Size:         aws.Int64Value(content.Size),
+			StorageClass: content.StorageClass,
+			LastModified: content.LastModified,
+			Type:         "Object",
+		})
+	}
+	return entries
+}
+
+//deleteEntries starts deleting the entries of a page that pass the filters
+func (j *bucketJob) deleteEntries(entries []s3Entry) *errgroup.Group {
+	kept := orderPage(j.filterEntries(entries))
+	if *dryRun {
+		return j.planEntries(kept)
+	}
+	if perKeyOrder() {
+		return j.dispatchPerKey(kept)
+	}
+	return j.dispatchEntries(kept)
+}
+
+//dispatchEntries hands entries to the worker pool one delete each. Waiting on the group returns the first fatal error, if any.
+func (j *bucketJob) dispatchEntries(entries []s3Entry) *errgroup.Group {
+	g, ctx := errgroup.WithContext(j.ctx)
+	perPage := newPageLimit()
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			break
+		}
+		input := s3.DeleteObjectInput{
+			Key:       entry.Key,
+			VersionId: entry.VersionId,
+			Bucket:    aws.String(j.name),
+			MFA:       j.mfa,
+		}
+		entry := entry
+		acquireGoroutine()
+		perPage.acquire()
+		j.pool.acquire()
+		g.Go(func() error {
+			defer j.recoverDelete(entry)
+			defer releaseGoroutine()
+			defer perPage.release()
+			return j.deleteS3Object(ctx, input, entry)
+		})
+	}
+	return g
+}
+
+func (j *bucketJob) deleteMarkers(deleteMarkers []*s3.DeleteMarkerEntry) *errgroup.Group {
+	InfoLogger.Print("Deleting Delete Markers...")
+	return j.deleteVersionEntries(markerEntries(deleteMarkers))
+}
+
+func (j *bucketJob) deleteVersions(deleteVersions []*s3.ObjectVersion) *errgroup.Group {
+	InfoLogger.Print("Deleting Versions...")
+	return j.deleteVersionEntries(versionEntries(deleteVersions))
+}
+
+//deleteVersionEntries deletes delete markers and versions, batched when -include-versioned-batch is set
+func (j *bucketJob) deleteVersionEntries(entries []s3Entry) *errgroup.Group {
+	if *versionedBatch {
+		return j.batchDeleteEntries(entries)
+	}
+	return j.deleteEntries(entries)
+}
+
+func (j *bucketJob) deleteObjects(deleteObjectsList []*s3.Object) *errgroup.Group {
+	InfoLogger.Print("Deleting Objects...")
+	if *batchDeletes {
+		return j.batchDeleteEntries(objectEntries(deleteObjectsList))
+	}
+	return j.deleteEntries(objectEntries(deleteObjectsList))
+}
+
+//deleteVersionsPage deletes a page of delete markers and versions in the -order requested
+func (j *bucketJob) deleteVersionsPage(page *s3.ListObjectVersionsOutput) error {
+	switch *order {
+	case "shuffled":
+		InfoLogger.Print("Deleting Delete Markers and Versions in random order...")
+		entries := shuffleEntries(append(markerEntries(page.DeleteMarkers), versionEntries(page.Versions)...))
+		return j.deleteVersionEntries(entries).Wait()
+	case "interleaved":
+		InfoLogger.Print("Deleting Delete Markers and Versions...")
+		entries := append(markerEntries(page.DeleteMarkers), versionEntries(page.Versions)...)
+		return j.deleteVersionEntries(entries).Wait()
+	case "per-key-versions-first", "per-key-markers-first":
+		InfoLogger.Print("Deleting Delete Markers and Versions key by key...")
+		entries := append(markerEntries(page.DeleteMarkers), versionEntries(page.Versions)...)
+		return j.deleteEntries(entries).Wait()
+	case "versions-first":
+		if err := j.deleteVersions(page.Versions).Wait(); err != nil {
+			return err
+		}
+		return j.deleteMarkers(page.DeleteMarkers).Wait()
+	}
+	if err := j.deleteMarkers(page.DeleteMarkers).Wait(); err != nil {
+		return err
+	}
+	return j.deleteVersions(page.Versions).Wait()
+}
+
+//deleteAllVersions runs both emptying passes over the bucket, or over each of -prefix/-prefix-file in turn.
+//The first time, a bucket that one-key listings show is already empty, as on a re-run, isn't paged through.
+//It returns why it stopped part way, if it did.
+func (j *bucketJob) deleteAllVersions() error {
+	defer func() { j.counted = true }()
+	if !j.counted && j.alreadyEmpty() {
+		if *verbosity {
+			InfoLogger.Printf("Bucket %s is already empty, skipping the listing\n", j.name)
+		}
+		return nil
+	}
+	if len(keyPrefixes) == 0 {
+		if err := j.deleteUnder(""); err != nil {
+			return err
+		}
+		j.reportFilters()
+		return nil
+	}
+	for _, prefix := range keyPrefixes {
+		before := j.deletedSoFar()
+		if err := j.deleteUnder(prefix); err != nil {
+			return err
+		}
+		n := j.deletedSoFar() - before
+		recordPrefixDeleted(prefix, n)
+		if *dryRun {
+			InfoLogger.Printf("Would delete %d entries under %q in %s\n", n, prefix, j.name)
+		} else {
+			InfoLogger.Printf("Deleted %d entries under %q in %s\n", n, prefix, j.name)
+		}
+	}
+	j.reportFilters()
+	return nil
+}
+
+//deletedSoFar is the bucket's deletes, or in a dry run the deletes it would have made
+func (j *bucketJob) deletedSoFar() int64 {
+	if *dryRun {
+		return atomic.LoadInt64(&j.stats.planned)
+	}
+	return atomic.LoadInt64(&j.stats.deleted)
+}
+
+//deleteUnder empties the part of the bucket whose keys start with prefix, all of it when prefix is empty.
+//It returns why it stopped early, if it did.
+func (j *bucketJob) deleteUnder(prefix string) error {
+	bucketName := j.name
+	var listPrefix *string
+	if prefix != "" {
+		listPrefix = aws.String(prefix)
+	}
+	if *keepVersions > 0 {
+		return j.keepNewestVersions(listPrefix)
+	}
+	var fatalErr error
+	var pipeline pagePipeline
+	listStart := time.Now()
+	versionsInput := s3.ListObjectVersionsInput{
+		Bucket:  aws.String(bucketName),
+		Prefix:  listPrefix,
+		MaxKeys: aws.Int64(listPageSize),
+	}
+	if *keyMarker != "" {
+		InfoLogger.Printf("Resuming the versions listing of %s after %q %q\n", bucketName, *keyMarker, *versionIDMarker)
+		versionsInput.KeyMarker = keyMarker
+		if *versionIDMarker != "" {
+			versionsInput.VersionIdMarker = versionIDMarker
+		}
+	}
+	//Go through all pages of Object Versions and delete them
+	err := j.listVersionPages(&versionsInput,
+		func(page *s3.ListObjectVersionsOutput, lastPage bool) bool {
+			if *histogram && !j.counted {
+				j.countVersions(page.Versions)
+			}
+			fatalErr = pipeline.run(j.tracePage("versions", listStart, len(page.DeleteMarkers)+len(page.Versions), func() error {
+				return j.deleteVersionsPage(page)
+			}))
+			listStart = time.Now()
+			return fatalErr == nil && !lastPage
+		})
+	if fatalErr == nil {
+		fatalErr = pipeline.wait()
+	}
+	j.flushKeyVersions()
+	if err != nil && fatalErr == nil && j.followRedirect(err) {
+		return j.deleteUnder(prefix)
+	}
+	if j.timedOut() {
+		return j.ctx.Err()
+	}
+	if fatalErr != nil {
+		return fatalErr
+	}
+	if err != nil {
+		return fmt.Errorf("unable to list versions of %s: %w", bucketName, bucketError(bucketName, err))
+	}
+	if err := j.deleteEmptyFolders(); err != nil {
+		return err
+	}
+
+	//Every current object was also listed as a version, so a dry run has nothing more to find
+	if *dryRun {
+		return nil
+	}
+
+	InfoLogger.Print("Deleting all Objects...")
+	listStart = time.Now()
+	//Go through all pages of Objects and delete them
+	//TODO: Move the inner function outside like we did above
+	objectsInput := s3.ListObjectsV2Input{
+		Bucket:  aws.String(bucketName),
+		Prefix:  listPrefix,
+		MaxKeys: aws.Int64(listPageSize),
+	}
+	if *continuationToken != "" {
+		InfoLogger.Printf("Resuming the objects listing of %s from continuation token %s\n", bucketName, *continuationToken)
+		objectsInput.ContinuationToken = continuationToken
+	}
+	err = j.listObjectPages(&objectsInput,
+		func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+			fatalErr = pipeline.run(j.tracePage("objects", listStart, len(page.Contents), func() error {
+				return j.deleteObjects(page.Contents).Wait()
+			}))
+			listStart = time.Now()
+			return fatalErr == nil
+		})
+	if fatalErr == nil {
+		fatalErr = pipeline.wait()
+	}
+	if j.timedOut() {
+		return j.ctx.Err()
+	}
+	if fatalErr != nil {
+		return fatalErr
+	}
+	if err != nil {
+		return fmt.Errorf("unable to list objects of %s: %w", bucketName, bucketError(bucketName, err))
+	}
+	return j.deleteEmptyFolders()
+}
+
+//reportFilters logs what the active filters kept back in this bucket
+func (j *bucketJob) reportFilters() {
+	s := &j.stats
+	if archived := atomic.LoadInt64(&s.archivedSkipped); archived > 0 {
+		InfoLogger.Printf("Skipped %d archived objects\n", archived)
+	}
+	if sizeFiltering() {
+		InfoLogger.Printf("%d entries (%d bytes) in size range, %d entries (%d bytes) out of range\n",
+			atomic.LoadInt64(&s.sizeInRange), atomic.LoadInt64(&s.sizeInRangeBytes),
+			atomic.LoadInt64(&s.sizeOutOfRange), atomic.LoadInt64(&s.sizeOutOfRangeBytes))
+	}
+	if folders := atomic.LoadInt64(&s.folderMarkers); folders > 0 {
+		InfoLogger.Printf("Included %d folder markers\n", folders)
+	}
+	if dateFiltering() {
+		InfoLogger.Printf("%d entries last modified inside the date window\n", atomic.LoadInt64(&s.inDateWindow))
+	}
+	if *staleMarkerAge > 0 {
+		InfoLogger.Printf("Pruned %d of the %d delete markers older than %s, leaving everything else\n",
+			atomic.LoadInt64(&s.markersDeleted), atomic.LoadInt64(&s.staleMarkers), *staleMarkerAge)
+	}
+	if globFiltering() {
+		InfoLogger.Printf("Skipped %d entries outside -include/-exclude\n", atomic.LoadInt64(&s.globSkipped))
+	}
+	if tagKey != "" {
+		InfoLogger.Printf("Skipped %d entries not tagged %s=%s\n", atomic.LoadInt64(&s.tagSkipped), tagKey, tagValue)
+	}
+	if *ttlTag != "" {
+		InfoLogger.Printf("Skipped %d entries whose %s tag is missing or not yet in the past\n", atomic.LoadInt64(&s.ttlUnexpired), *ttlTag)
+	}
+}
+
+//suspendVersioning stops the bucket from accumulating new versions and delete markers while it is emptied.
+//This is a lasting change to the bucket if it ends up not being deleted.
+func (j *bucketJob) suspendVersioning() error {
+	bucketName := j.name
+	_, err := j.svc.PutBucketVersioning(&s3.PutBucketVersioningInput{
+		Bucket: aws.String(bucketName),
+		VersioningConfiguration: &s3.VersioningConfiguration{
+			Status: aws.String(s3.BucketVersioningStatusSuspended),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("unable to suspend versioning on %s: %w", bucketName, bucketError(bucketName, err))
+	}
+	InfoLogger.Printf("Suspended versioning on %s\n", bucketName)
+	return nil
+}
+
+//removeBucketPolicy is -remove-policy-first: it deletes the bucket policy before emptying, since one with an
+//explicit Deny on deletes would otherwise lock the tool out. Like suspendVersioning this is a lasting change if
+//the bucket is kept. A bucket without a policy is fine; any other failure is a warning and emptying goes ahead.
+func (j *bucketJob) removeBucketPolicy() {
+	WarningLogger.Printf("Removing the bucket policy of %s before emptying it, which stays removed even if the bucket is kept\n", j.name)
+	_, err := j.svc.DeleteBucketPolicyWithContext(j.ctx, &s3.DeleteBucketPolicyInput{
+		Bucket: aws.String(j.name),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "NoSuchBucketPolicy" {
+		InfoLogger.Printf("%s has no bucket policy\n", j.name)
+		return
+	}
+	if err != nil {
+		WarningLogger.Printf("Unable to remove the bucket policy of %s, emptying it anyway: %v\n", j.name, err)
+		return
+	}
+	InfoLogger.Printf("Removed the bucket policy of %s\n", j.name)
+}
+
+//isBucketEmpty checks for any remaining version, delete marker or object with a single-key listing of each
+func (j *bucketJob) isBucketEmpty() (bool, error) {
+	bucketName, svc := j.name, j.svc
+	versions, err := svc.ListObjectVersionsWithContext(j.ctx, &s3.ListObjectVersionsInput{
+		Bucket:  aws.String(bucketName),
+		MaxKeys: aws.Int64(1),
+	}, listOptions()...)
+	if err != nil {
+		if j.timedOut() {
+			return false, j.ctx.Err()
+		}
+		return false, fmt.Errorf("unable to list versions of %s: %w", bucketName, bucketError(bucketName, err))
+	}
+	if len(versions.Versions) > 0 || len(versions.DeleteMarkers) > 0 {
+		return false, nil
+	}
+	objects, err := svc.ListObjectsV2WithContext(j.ctx, &s3.ListObjectsV2Input{
+		Bucket:  aws.String(bucketName),
+		MaxKeys: aws.Int64(1),
+	}, listOptions()...)
+	if err != nil {
+		if j.timedOut() {
+			return false, j.ctx.Err()
+		}
+		return false, fmt.Errorf("unable to list objects of %s: %w", bucketName, bucketError(bucketName, err))
+	}
+	return len(objects.Contents) == 0, nil
+}
+
+//alreadyEmpty is isBucketEmpty for before the listing starts. Errors only mean the bucket isn't known to be
+//empty: the full listing then runs, and handles them, redirects and -listing-error included, as usual.
+func (j *bucketJob) alreadyEmpty() bool {
+	versions, err := j.svc.ListObjectVersionsWithContext(j.ctx, &s3.ListObjectVersionsInput{
+		Bucket:  aws.String(j.name),
+		MaxKeys: aws.Int64(1),
+	}, listOptions()...)
+	if err != nil || len(versions.Versions) > 0 || len(versions.DeleteMarkers) > 0 {
+		return false
+	}
+	objects, err := j.svc.ListObjectsV2WithContext(j.ctx, &s3.ListObjectsV2Input{
+		Bucket:  aws.String(j.name),
+		MaxKeys: aws.Int64(1),
+	}, listOptions()...)
+	return err == nil && len(objects.Contents) == 0
+}
+
+//verifyEmpty re-lists the bucket after emptying, for stores where deleted keys can briefly reappear,
+//and deletes whatever shows up until a listing comes back clean or -verify-passes runs out. A -plan-in run deletes
+//nothing beyond its plan, so it only waits for the listing to come back clean.
+//It returns an ErrBucketNotEmpty if the bucket is still not empty after that, or why verifying stopped early.
+func (j *bucketJob) verifyEmpty() error {
+	bucketName := j.name
+	var reappeared int64
+	for pass := 1; pass <= *verifyPasses; pass++ {
+		if err := j.verifyWait(); err != nil {
+			return err
+		}
+		empty, err := j.isBucketEmpty()
+		if err != nil {
+			return err
+		}
+		if empty {
+			if reappeared > 0 {
+				InfoLogger.Printf("Cleaned up %d objects that reappeared in %s\n", reappeared, bucketName)
+			} else if *verbosity {
+				InfoLogger.Printf("Verified %s is empty\n", bucketName)
+			}
+			return nil
+		}
+		//A plan only covers the entries it lists, so nothing else is deleted: the listing has to catch up by itself
+		if loadedPlan != nil {
+			InfoLogger.Printf("Verify pass %d: %s still lists entries, waiting for them to go\n", pass, bucketName)
+			continue
+		}
+		InfoLogger.Printf("Verify pass %d: %s is not empty yet, deleting again\n", pass, bucketName)
+		before := atomic.LoadInt64(&j.stats.deleted)
+		if err := j.deleteAllVersions(); err != nil {
+			return err
+		}
+		reappeared += atomic.LoadInt64(&j.stats.deleted) - before
+	}
+	if err := j.verifyWait(); err != nil {
+		return err
+	}
+	empty, err := j.isBucketEmpty()
+	if err != nil {
+		return err
+	}
+	if !empty {
+		ErrorLogger.Printf("%s still isn't empty after %d verify passes (%d reappeared objects deleted), not deleting it\n", bucketName, *verifyPasses, reappeared)
+		return &ErrBucketNotEmpty{Bucket: bucketName}
+	}
+	InfoLogger.Printf("Cleaned up %d objects that reappeared in %s\n", reappeared, bucketName)
+	return nil
+}
+
+//verifyWait waits -verify-delay before a verify listing, giving up when the run stops
+func (j *bucketJob) verifyWait() error {
+	select {
+	case <-time.After(*verifyDelay):
+		return nil
+	case <-j.ctx.Done():
+		return j.ctx.Err()
+	}
+}
+
+func (j *bucketJob) deleteBucket() bool {
+	bucketName := j.name
+	if *verbosity {
+		InfoLogger.Printf("Deleting bucket %s....", bucketName)
+	}
+
+	if *confirmBucketDelete && !j.confirmBucketDelete() {
+		if j.timedOut() {
+			j.failTimeout()
+			return false
+		}
+		WarningLogger.Printf("Emptied %s but keeping the bucket, as answered\n", bucketName)
+		return true
+	}
+	if *purgeConfig {
+		j.purgeConfig()
+	}
+	if *deleteAccessPoints {
+		j.removeAccessPoints()
+	}
+
+	err := j.removeBucket()
+	//A plan only covers the entries it lists, so anything else found in the bucket is left alone
+	if err != nil && *skipVerify && loadedPlan == nil && isBucketNotEmpty(err) && !j.timedOut() {
+		WarningLogger.Printf("Bucket %s isn't empty after all, verifying before trying again\n", bucketName)
+		if err := j.verifyEmpty(); err != nil {
+			j.fail(err)
+			return false
+		}
+		err = j.removeBucket()
+	}
+	if err != nil {
+		if j.timedOut() {
+			j.failTimeout()
+			return false
+		}
+		if *keepOnDenied && statusCode(err) == http.StatusForbidden {
+			WarningLogger.Printf("Emptied %s but not allowed to delete it, keeping the bucket: %v\n", bucketName, err)
+			return true
+		}
+		ErrorLogger.Printf("Unable to delete bucket %s: %v\n", bucketName, err)
+		recordBucketFailure(bucketName, bucketError(bucketName, err))
+		return false
+	}
+	j.stats.bucketDeleted = true
+	InfoLogger.Printf("Deleted bucket %s", bucketName)
+	return true
+}
+
+//removeBucket issues DeleteBucket, retrying transient failures. Anything else, such as BucketNotEmpty, is returned straight away.
+//A conflict, from another run or actor deleting the bucket at the same time, is retried too; if the bucket is
+//then gone, so much the better, and that counts as deleted.
+func (j *bucketJob) removeBucket() error {
+	pending := false
+	err := backoff.Retry(func() error {
+		_, err := j.svc.DeleteBucketWithContext(j.ctx, &s3.DeleteBucketInput{
+			Bucket: aws.String(j.name),
+		})
+		if pending && isNoSuchBucket(err) {
+			return nil
+		}
+		if isPendingDeletion(err) {
+			if !pending {
+				WarningLogger.Printf("Bucket %s has an operation in progress, likely a delete by someone else, retrying: %v\n", j.name, err)
+				pending = true
+			}
+			return err
+		}
+		if err != nil && !isRetryable(err) {
+			return backoff.Permanent(err)
+		}
+		return err
+	}, backoff.WithContext(backoff.NewExponentialBackOff(), j.ctx))
+	if pending {
+		if err == nil {
+			InfoLogger.Printf("Bucket %s is gone, whichever delete got there first\n", j.name)
+		} else {
+			ErrorLogger.Printf("Bucket %s still had an operation in progress when retrying gave up\n", j.name)
+		}
+	}
+	return err
+}
+
+//regionFromHeadBucket is the fallback for when GetBucketRegion's anonymous request is refused.
+//A signed HeadBucket sent to the wrong region still gets a redirect carrying the
+//x-amz-bucket-region header, as long as the caller is allowed to list the bucket.
+func regionFromHeadBucket(sess *session.Session, bucketName string) string {
+	req, _ := s3.New(sess).HeadBucketRequest(&s3.HeadBucketInput{
+		Bucket: aws.String(bucketName),
+	})
+	req.DisableFollowRedirects = true
+	var region string
+	req.Handlers.Send.PushBack(func(r *request.Request) {
+		if r.HTTPResponse != nil {
+			region = r.HTTPResponse.Header.Get("X-Amz-Bucket-Region")
+		}
+	})
+	req.Send()
+	if region == "" {
+		return ""
+	}
+	return s3.NormalizeBucketLocation(region)
+}
+
+func exitErrorf(msg string, args ...interface{}) {
+	if dashboard != nil {
+		dashboard.stop()
+	}
+	ErrorLogger.Printf(msg+"\n", args...)
+	os.Exit(1)
+}
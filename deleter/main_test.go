@@ -0,0 +1,184 @@
+package deleter
+
+import (
+	"context"
+	"flag"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"golang.org/x/time/rate"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+//testFlags holds the flags at their defaults, as a run without arguments would have them
+var testFlags = flag.NewFlagSet("deleteS3bucket", flag.ContinueOnError)
+
+func TestMain(m *testing.M) {
+	defineFlags(testFlags)
+	InfoLogger = log.New(ioutil.Discard, "", 0)
+	WarningLogger = log.New(ioutil.Discard, "", 0)
+	ErrorLogger = log.New(ioutil.Discard, "", 0)
+	os.Exit(m.Run())
+}
+
+//setFlags sets flags as if given on the command line, putting them back to their defaults when the test ends
+func setFlags(t *testing.T, values map[string]string) {
+	t.Helper()
+	for name, value := range values {
+		if err := testFlags.Set(name, value); err != nil {
+			t.Fatalf("-%s=%s: %v", name, value, err)
+		}
+		name := name
+		t.Cleanup(func() {
+			testFlags.Set(name, testFlags.Lookup(name).DefValue)
+		})
+	}
+}
+
+//newTestJob is a bucketJob for bucket demo on svc, with a worker pool of its own
+func newTestJob(t *testing.T, svc s3iface.S3API) *bucketJob {
+	ctx, cancel := context.WithCancel(context.Background())
+	j := &bucketJob{
+		ctx:     ctx,
+		cancel:  cancel,
+		name:    "demo",
+		region:  fakeRegion,
+		svc:     svc,
+		started: time.Now(),
+	}
+	var stopPool func()
+	j.pool, stopPool = startPool(j.name)
+	t.Cleanup(func() {
+		stopPool()
+		cancel()
+	})
+	return j
+}
+
+func TestVerifyEmptyStopsWithRun(t *testing.T) {
+	setFlags(t, map[string]string{"verify-delay": "1h", "verify-passes": "3"})
+	j := newTestJob(t, newFakeS3("demo", 10))
+	j.cancel()
+	done := make(chan error, 1)
+	go func() { done <- j.verifyEmpty() }()
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Errorf("verifyEmpty on a stopped run = %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("verifyEmpty slept through -verify-delay after the run stopped")
+	}
+}
+
+//useExhaustedLimiter sets a -rate limiter whose next token is an hour away
+func useExhaustedLimiter(t *testing.T) {
+	limiter = rate.NewLimiter(rate.Every(time.Hour), 1)
+	limiter.Allow()
+	t.Cleanup(func() { limiter = nil })
+}
+
+//firstVersion is the newest version of the fake bucket's first key
+func firstVersion(f *fakeS3) s3Entry {
+	key := f.sortedKeys("")[0]
+	return s3Entry{Key: aws.String(key), VersionId: aws.String(f.keys[key][0].id), Type: "Version"}
+}
+
+func TestDeleteS3ObjectWaitsOnLimiter(t *testing.T) {
+	useExhaustedLimiter(t)
+	f := newFakeS3("demo", 1)
+	j := newTestJob(t, f)
+	entry := firstVersion(f)
+	ctx, cancel := context.WithTimeout(j.ctx, time.Minute)
+	defer cancel()
+
+	j.pool.acquire()
+	if err := j.deleteS3Object(ctx, s3.DeleteObjectInput{Bucket: aws.String(j.name), Key: entry.Key, VersionId: entry.VersionId}, entry); err != nil {
+		t.Fatal(err)
+	}
+	if j.stats.deleted != 0 {
+		t.Error("deleted without a -rate token")
+	}
+	if len(j.failed) != 1 {
+		t.Errorf("%d deletes recorded as failed, want 1", len(j.failed))
+	}
+}
+
+//bufferingS3 is the fake bucket, tracking the most entries that had been listed but not deleted yet
+type bufferingS3 struct {
+	*fakeS3
+	mu       sync.Mutex
+	buffered int
+	peak     int
+}
+
+func (f *bufferingS3) add(n int) {
+	f.mu.Lock()
+	f.buffered += n
+	if f.buffered > f.peak {
+		f.peak = f.buffered
+	}
+	f.mu.Unlock()
+}
+
+func (f *bufferingS3) ListObjectVersionsPagesWithContext(ctx aws.Context, input *s3.ListObjectVersionsInput, fn func(*s3.ListObjectVersionsOutput, bool) bool, opts ...request.Option) error {
+	return f.fakeS3.ListObjectVersionsPagesWithContext(ctx, input, func(page *s3.ListObjectVersionsOutput, last bool) bool {
+		f.add(len(page.Versions) + len(page.DeleteMarkers))
+		return fn(page, last)
+	}, opts...)
+}
+
+func (f *bufferingS3) DeleteObjectWithContext(ctx aws.Context, input *s3.DeleteObjectInput, opts ...request.Option) (*s3.DeleteObjectOutput, error) {
+	out, err := f.fakeS3.DeleteObjectWithContext(ctx, input, opts...)
+	if err == nil {
+		f.add(-1)
+	}
+	return out, err
+}
+
+func (f *bufferingS3) DeleteObjectsWithContext(ctx aws.Context, input *s3.DeleteObjectsInput, opts ...request.Option) (*s3.DeleteObjectsOutput, error) {
+	out, err := f.fakeS3.DeleteObjectsWithContext(ctx, input, opts...)
+	if err == nil {
+		f.add(-len(input.Delete.Objects))
+	}
+	return out, err
+}
+
+//peakBuffered empties a fake bucket of n keys with -low-memory, returning the most entries it held at once
+func peakBuffered(t *testing.T, n int) int {
+	//Listing the flags -low-memory changes puts them back to their defaults afterwards
+	setFlags(t, map[string]string{"low-memory": "true", "batch": "false", "concurrency": "1000", "adaptive-max": "1000"})
+	pageSize := listPageSize
+	defer func() { listPageSize = pageSize }()
+	applyLowMemory()
+
+	f := &bufferingS3{fakeS3: newFakeS3("demo", n)}
+	j := newTestJob(t, f)
+	if err := j.deleteAllVersions(); err != nil {
+		t.Fatal(err)
+	}
+	if left := len(f.sortedKeys("")); left != 0 {
+		t.Fatalf("%d keys left after emptying", left)
+	}
+	return f.peak
+}
+
+func TestLowMemoryBuffersBoundedEntries(t *testing.T) {
+	if testing.Short() {
+		t.Skip("lists and deletes a 3000-key fake bucket")
+	}
+	small, large := peakBuffered(t, 300), peakBuffered(t, 3000)
+	//A page is deleted before the next one is listed, so about one -low-memory page is held whatever the bucket's size
+	if bound := 2 * 100; large > bound {
+		t.Errorf("-low-memory held up to %d listed entries of a 3000-key bucket, want at most %d", large, bound)
+	}
+	if large > 2*small {
+		t.Errorf("-low-memory held up to %d entries of a 3000-key bucket against %d of a 300-key one, want them close", large, small)
+	}
+}
@@ -0,0 +1,133 @@
+package deleter
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"golang.org/x/sync/errgroup"
+	"io"
+	"net/url"
+	"strings"
+)
+
+//parseManifestURL splits -keys-from-s3's s3://bucket/key
+func parseManifestURL(raw string) (bucket string, key string, err error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", "", err
+	}
+	key = strings.TrimPrefix(u.Path, "/")
+	if u.Scheme != "s3" || u.Host == "" || key == "" {
+		return "", "", errors.New("must look like s3://bucket/key")
+	}
+	return u.Host, key, nil
+}
+
+//parseManifestLine reads one key[,versionId] line. Version IDs never contain a comma, so the line is split at
+//its last one; a key that contains commas itself needs a trailing comma when it has no version ID.
+func parseManifestLine(line string) s3Entry {
+	i := strings.LastIndex(line, ",")
+	if i < 0 {
+		return s3Entry{Key: aws.String(line), Type: "Object"}
+	}
+	entry := s3Entry{Key: aws.String(line[:i]), Type: "Object"}
+	if versionId := line[i+1:]; versionId != "" {
+		entry.VersionId = aws.String(versionId)
+		entry.Type = "Version"
+	}
+	return entry
+}
+
+//openManifest starts downloading the -keys-from-s3 manifest, from its own bucket's region
+func openManifest(ctx context.Context) (io.ReadCloser, error) {
+	bucket, key, _ := parseManifestURL(*keysFromS3)
+	region := getRegion(bucket)
+	if region == "unknown" {
+		return nil, fmt.Errorf("manifest bucket %s not found", bucket)
+	}
+	sess, err := newSession(region)
+	if err != nil {
+		exitErrorf("Unable to setup s3 connection: %v", err)
+	}
+	out, err := s3.New(sess).GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+//newManifestScanner reads a manifest line by line
+func newManifestScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	//Keys are at most 1024 bytes, with room to spare for the bucket and version ID
+	scanner.Buffer(make([]byte, 4096), 4096)
+	return scanner
+}
+
+//deleteManifestKeys deletes the entries listed in the -keys-from-s3 manifest and nothing else, leaving the bucket.
+//The manifest is streamed, a listing page's worth of lines at a time, so its size doesn't matter.
+//It returns false if the bucket timed out.
+func (j *bucketJob) deleteManifestKeys() bool {
+	body, err := openManifest(j.ctx)
+	if err != nil {
+		if j.timedOut() {
+			return false
+		}
+		ErrorLogger.Printf("Unable to download manifest %s: %v\n", *keysFromS3, err)
+		recordBucketFailure(j.name, err)
+		return true
+	}
+	defer body.Close()
+
+	InfoLogger.Printf("Deleting the entries listed in %s from %s\n", *keysFromS3, j.name)
+	var entries []s3Entry
+	listed := 0
+	flush := func() bool {
+		var g *errgroup.Group
+		if *dryRun {
+			g = j.planEntries(entries)
+		} else {
+			g = j.dispatchEntries(entries)
+		}
+		err := g.Wait()
+		entries = entries[:0]
+		if j.timedOut() {
+			return false
+		}
+		if err != nil {
+			exitErrorf("Aborting %s: %v", j.name, err)
+		}
+		return true
+	}
+	scanner := newManifestScanner(body)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		entries = append(entries, parseManifestLine(line))
+		listed++
+		if len(entries) == int(listPageSize) && !flush() {
+			return false
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if j.timedOut() {
+			return false
+		}
+		ErrorLogger.Printf("Unable to read manifest %s after %d lines: %v\n", *keysFromS3, listed, err)
+		recordBucketFailure(j.name, err)
+		return true
+	}
+	if !flush() {
+		return false
+	}
+	InfoLogger.Printf("The manifest listed %d entries\n", listed)
+	return true
+}
@@ -0,0 +1,179 @@
+package deleter
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+//confirmManifestBuckets asks before a -manifest-buckets run, whose buckets are only known as the manifest is read
+func confirmManifestBuckets() {
+	if *force {
+		return
+	}
+	fmt.Printf("Type \"yes\" to delete every entry listed in %s, from whichever buckets it names: ", *keysFromS3)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	if strings.TrimSpace(answer) != "yes" {
+		exitErrorf("Aborted, nothing was deleted")
+	}
+}
+
+//parseManifestBucketLine reads one bucket,key[,versionId] line of a -manifest-buckets manifest.
+//Bucket names can't contain a comma, so the bucket is everything before the first one.
+func parseManifestBucketLine(line string) (string, s3Entry, error) {
+	i := strings.Index(line, ",")
+	if i <= 0 || i == len(line)-1 {
+		return "", s3Entry{}, errors.New("want bucket,key[,versionId]")
+	}
+	return line[:i], parseManifestLine(line[i+1:]), nil
+}
+
+//manifestBucket is one bucket named in a -manifest-buckets manifest, with the rows waiting to be deleted from it.
+//job is nil when the bucket couldn't be used, and its rows are only counted.
+type manifestBucket struct {
+	job      *bucketJob
+	pending  []s3Entry
+	skipped  int
+	stopPool func()
+}
+
+//newManifestJob sets up a bucket named in the manifest the first time it turns up: its region (cached for the
+//run), a client in that region and a preflight HeadBucket. A bucket that fails any of them is reported once.
+func newManifestJob(bucket string) *bucketJob {
+	region := getRegion(bucket)
+	if region == "unknown" {
+		err := fmt.Errorf("unable to find the region of %s", bucket)
+		ErrorLogger.Printf("Skipping the manifest rows for %s: %v\n", bucket, err)
+		recordBucketFailure(bucket, err)
+		return nil
+	}
+	sess, err := newSession(region)
+	if err != nil {
+		exitErrorf("Unable to setup s3 connection: %v", err)
+	}
+	ctx, cancel := context.WithCancel(runCtx)
+	j := &bucketJob{
+		ctx:     ctx,
+		cancel:  cancel,
+		name:    bucket,
+		region:  region,
+		svc:     newS3Client(sess),
+		pool:    sharedPool,
+		started: time.Now(),
+	}
+	if err := j.preflight(); err != nil {
+		ErrorLogger.Printf("Skipping the manifest rows for %s: %v\n", bucket, err)
+		recordBucketFailure(bucket, err)
+		cancel()
+		return nil
+	}
+	trackMetrics(j)
+	return j
+}
+
+//deleteManifestAcrossBuckets is -manifest-buckets: it streams the -keys-from-s3 manifest and deletes each row from
+//the bucket it names, a listing page's worth of a bucket's rows at a time, through the shared worker pool. Like
+//-keys-from-s3 on one bucket, nothing else is deleted and every bucket is kept. The per-bucket counts are logged.
+func deleteManifestAcrossBuckets() {
+	body, err := openManifest(runCtx)
+	if err != nil {
+		exitErrorf("Unable to download manifest %s, nothing was deleted: %v", *keysFromS3, err)
+	}
+	defer body.Close()
+
+	buckets := map[string]*manifestBucket{}
+	flush := func(b *manifestBucket) {
+		entries := b.pending
+		b.pending = nil
+		if b.job == nil || len(entries) == 0 {
+			b.skipped += len(entries)
+			return
+		}
+		if b.job.timedOut() {
+			b.skipped += len(entries)
+			return
+		}
+		var err error
+		if *dryRun {
+			err = b.job.planEntries(entries).Wait()
+		} else {
+			err = b.job.dispatchEntries(entries).Wait()
+		}
+		if err != nil && !b.job.timedOut() {
+			exitErrorf("Aborting %s: %v", b.job.name, err)
+		}
+	}
+
+	InfoLogger.Printf("Deleting the entries listed in %s from the buckets it names\n", *keysFromS3)
+	scanner := newManifestScanner(body)
+	lineNo, malformed := 0, 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		name, entry, err := parseManifestBucketLine(line)
+		if err != nil {
+			WarningLogger.Printf("Skipping line %d of %s: %v\n", lineNo, *keysFromS3, err)
+			malformed++
+			continue
+		}
+		b, ok := buckets[name]
+		if !ok {
+			b = &manifestBucket{job: newManifestJob(name)}
+			if b.job != nil && b.job.pool == nil {
+				b.job.pool, b.stopPool = startPool(name)
+			}
+			buckets[name] = b
+		}
+		b.pending = append(b.pending, entry)
+		if len(b.pending) == int(listPageSize) {
+			flush(b)
+		}
+	}
+	readErr := scanner.Err()
+	names := make([]string, 0, len(buckets))
+	for name, b := range buckets {
+		flush(b)
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	verb := "Deleted"
+	if *dryRun {
+		verb = "Would delete"
+	}
+	for _, name := range names {
+		b := buckets[name]
+		if b.job != nil {
+			InfoLogger.Printf("%s %d entries from %s\n", verb, b.job.deletedSoFar(), name)
+			if err := b.job.partialFailure(); err != nil {
+				ErrorLogger.Printf("%v\n", err)
+				recordBucketFailure(name, err)
+			}
+			recordBucketResult(b.job)
+			untrackMetrics(b.job)
+			b.job.cancel()
+		}
+		if b.skipped > 0 {
+			WarningLogger.Printf("Skipped %d manifest entries for %s\n", b.skipped, name)
+		}
+		if b.stopPool != nil {
+			b.stopPool()
+		}
+	}
+	InfoLogger.Printf("The manifest listed %d lines across %d buckets\n", lineNo, len(buckets))
+	if malformed > 0 {
+		WarningLogger.Printf("%d manifest lines weren't bucket,key[,versionId] and were skipped\n", malformed)
+	}
+	if readErr != nil {
+		ErrorLogger.Printf("Unable to read manifest %s after %d lines: %v\n", *keysFromS3, lineNo, readErr)
+		recordBucketFailure(*keysFromS3, readErr)
+	}
+}
@@ -0,0 +1,70 @@
+package deleter
+
+import (
+	"encoding/json"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+//MetricsLine is the one line -metrics-json-out appends when a run ends, flat so any log shipper can index it
+type MetricsLine struct {
+	Time            string   `json:"time"`
+	Buckets         []string `json:"buckets"`
+	Deleted         int64    `json:"deleted"`
+	Objects         int64    `json:"objects_deleted"`
+	Versions        int64    `json:"versions_deleted"`
+	Markers         int64    `json:"markers_deleted"`
+	FreedBytes      int64    `json:"freed_bytes"`
+	FailedKeys      int      `json:"failed_keys"`
+	FailedBuckets   int      `json:"failed_buckets"`
+	Retries         int64    `json:"retries"`
+	DurationSeconds float64  `json:"duration_seconds"`
+	PerSecond       float64  `json:"deletes_per_second"`
+	Success         bool     `json:"success"`
+}
+
+//writeMetricsLine appends the run's MetricsLine as a single line of JSON to path, or writes it to stdout for "-"
+func writeMetricsLine(path string, start time.Time) error {
+	stats := summaryStats(start)
+	line := MetricsLine{
+		Time:            time.Now().UTC().Format(time.RFC3339),
+		Buckets:         []string{},
+		Deleted:         stats.Deleted,
+		Objects:         atomic.LoadInt64(&runObjectsDeleted),
+		Versions:        atomic.LoadInt64(&runVersionsDeleted),
+		Markers:         atomic.LoadInt64(&runMarkersDeleted),
+		FreedBytes:      stats.FreedBytes,
+		Retries:         stats.Retries,
+		DurationSeconds: stats.ElapsedSeconds,
+		Success:         stats.Success,
+	}
+	if stats.ElapsedSeconds > 0 {
+		line.PerSecond = float64(stats.Deleted) / stats.ElapsedSeconds
+	}
+	for _, bucket := range stats.Buckets {
+		line.Buckets = append(line.Buckets, bucket.Name)
+		line.FailedKeys += bucket.FailedKeys
+		if len(bucket.Errors) > 0 {
+			line.FailedBuckets++
+		}
+	}
+	data, err := json.Marshal(line)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if path == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
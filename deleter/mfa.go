@@ -0,0 +1,44 @@
+package deleter
+
+import (
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"regexp"
+)
+
+//What -mfa has to look like: the device's serial number or ARN, a space, and the current code
+var mfaValue = regexp.MustCompile(`^\S+ [0-9]{6}$`)
+
+//errMFARequired explains the AccessDenied every version delete would otherwise fail with
+var errMFARequired = errors.New("MFA Delete is enabled, so deleting versions needs -mfa \"serial code\" with the bucket owner's MFA device, " +
+	"or MFA Delete has to be disabled first (PutBucketVersioning with the root user's MFA)")
+
+//checkMFADelete looks at the bucket's versioning before anything is deleted. With MFA Delete enabled S3 refuses
+//every version delete without an MFA header, with a plain AccessDenied that doesn't say why, so the bucket is
+//failed up front instead unless -mfa is given, in which case the deletes carry it.
+func (j *bucketJob) checkMFADelete() error {
+	out, err := j.svc.GetBucketVersioningWithContext(j.ctx, &s3.GetBucketVersioningInput{
+		Bucket: aws.String(j.name),
+	})
+	if err != nil {
+		//Not being allowed to read the versioning config doesn't mean MFA Delete is on, the deletes will tell
+		if *verbosity {
+			WarningLogger.Printf("Unable to check %s for MFA Delete: %v\n", j.name, err)
+		}
+		return nil
+	}
+	if aws.StringValue(out.MFADelete) != s3.MFADeleteStatusEnabled {
+		return nil
+	}
+	if *mfa == "" {
+		if *dryRun {
+			WarningLogger.Printf("%s has MFA Delete enabled, a real run needs -mfa\n", j.name)
+			return nil
+		}
+		return errMFARequired
+	}
+	InfoLogger.Printf("%s has MFA Delete enabled, sending -mfa with every delete\n", j.name)
+	j.mfa = mfa
+	return nil
+}
@@ -0,0 +1,84 @@
+package deleter
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+//How often -deadline-per-10k checks the run's delete rate once -pace-after has passed
+const paceInterval = 30 * time.Second
+
+//startPaceWatch checks the run's overall delete rate against -deadline-per-10k, warning when it falls behind
+//and, with -pace-abort, stopping the run, so a run set up too slow for its bucket doesn't quietly take days.
+//The first check, after -pace-after, also logs the projected completion time when -expected-objects is set.
+//The returned func stops the watch.
+func startPaceWatch(start time.Time) func() {
+	stopping := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-stopping:
+			return
+		case <-time.After(*paceAfter):
+		}
+		behind := checkPace(start, true, false)
+		ticker := time.NewTicker(paceInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopping:
+				return
+			case <-ticker.C:
+				behind = checkPace(start, false, behind)
+			}
+		}
+	}()
+	return func() {
+		close(stopping)
+		<-stopped
+	}
+}
+
+//checkPace compares the rate since start with the -deadline-per-10k one and returns whether the run is behind.
+//It only warns when the run falls behind or catches up again, not on every check.
+func checkPace(start time.Time, first bool, wasBehind bool) bool {
+	elapsed := time.Since(start)
+	deleted := atomic.LoadInt64(&deletedCount)
+	perSecond := float64(deleted) / elapsed.Seconds()
+	if first {
+		InfoLogger.Printf("Deleting at %.1f obj/s over the first %s\n", perSecond, elapsed.Round(time.Second))
+		if *expectedObjects > 0 {
+			if perSecond > 0 {
+				remaining := time.Duration(float64(*expectedObjects-deleted) / perSecond * float64(time.Second))
+				if remaining < 0 {
+					remaining = 0
+				}
+				InfoLogger.Printf("Projected to finish %d entries in %s more, around %s\n",
+					*expectedObjects, remaining.Round(time.Second), time.Now().Add(remaining).Format(time.RFC3339))
+			} else {
+				WarningLogger.Printf("Nothing deleted in the first %s, no completion time to project for %d entries\n", elapsed.Round(time.Second), *expectedObjects)
+			}
+		}
+	}
+	if *deadlinePer10k == 0 || runStopped() != nil {
+		return false
+	}
+	expected := 10000 / deadlinePer10k.Seconds()
+	if perSecond >= expected {
+		if wasBehind {
+			InfoLogger.Printf("Back on -deadline-per-10k pace: %.1f obj/s, %.1f expected\n", perSecond, expected)
+		}
+		return false
+	}
+	err := fmt.Errorf("deleting at %.1f obj/s after %s, slower than the %.1f obj/s of -deadline-per-10k=%s", perSecond, elapsed.Round(time.Second), expected, *deadlinePer10k)
+	if *paceAbort {
+		stopRun(err)
+		return true
+	}
+	if !wasBehind {
+		WarningLogger.Printf("Behind pace: %v\n", err)
+	}
+	return true
+}
@@ -0,0 +1,51 @@
+package deleter
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"runtime/debug"
+	"sync/atomic"
+)
+
+//Deletes whose worker panicked, reported apart from the usual failures
+var panicCount int64
+
+//recoverDelete is deferred first in every delete worker. A panic, such as a nil pointer in an odd S3 answer,
+//would otherwise take the whole run down with it: instead the worker's entries are recorded as failed, so
+//they are in the bucket's ErrPartialFailure, retried by -auto-retry-failures and counted in the summary's
+//failed_keys, and the other workers carry on. The stack is logged with -v.
+func (j *bucketJob) recoverDelete(entries ...s3Entry) {
+	if r := recover(); r != nil {
+		j.recordPanic(r, entries)
+	}
+}
+
+//recoverRemaining is recoverDelete for a worker deleting its entries one after another, which moves *remaining
+//past each entry it is done with so only the ones it hadn't finished are recorded as failed
+func (j *bucketJob) recoverRemaining(remaining *[]s3Entry) {
+	if r := recover(); r != nil {
+		j.recordPanic(r, *remaining)
+	}
+}
+
+//recordPanic counts and logs a worker's panic and records the entries it left undeleted as failed
+func (j *bucketJob) recordPanic(r interface{}, entries []s3Entry) {
+	atomic.AddInt64(&panicCount, 1)
+	key := ""
+	if len(entries) > 0 {
+		key = aws.StringValue(entries[0].Key)
+	}
+	ErrorLogger.Printf("Delete of %d entries from %s, starting at %q, panicked: %v\n", len(entries), j.name, key, r)
+	if *verbosity {
+		ErrorLogger.Printf("%s\n", debug.Stack())
+	}
+	for _, entry := range entries {
+		j.recordFailed(entry)
+	}
+}
+
+//reportPanics warns about deletes whose worker panicked, which is a bug rather than anything S3 said
+func reportPanics() {
+	if n := atomic.LoadInt64(&panicCount); n > 0 {
+		WarningLogger.Printf("%d delete workers panicked, their keys were recorded as failed: run with -v for the stacks\n", n)
+	}
+}
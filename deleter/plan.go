@@ -0,0 +1,302 @@
+package deleter
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"golang.org/x/sync/errgroup"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+//Plan row type saying the bucket itself is to be deleted once its entries are gone
+const planBucketRow = "Bucket"
+
+//planWriter records a dry run's would-be deletes, one CSV row per entry, for -plan-in to execute later.
+//With -partition-plan the plan is a directory holding one file per bucket and top-level prefix instead of a single file.
+type planWriter struct {
+	mu     sync.Mutex
+	single *planFile
+	dir    string
+	files  map[string]*planFile
+}
+
+type planFile struct {
+	file *os.File
+	w    *csv.Writer
+}
+
+//Open -plan-out file, nil when not writing a plan
+var planOut *planWriter
+
+func createPlan(path string) (*planWriter, error) {
+	if *partitionPlan {
+		if err := os.MkdirAll(path, 0755); err != nil {
+			return nil, err
+		}
+		return &planWriter{dir: path, files: map[string]*planFile{}}, nil
+	}
+	f, err := createPlanFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &planWriter{single: f}, nil
+}
+
+func createPlanFile(path string) (*planFile, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	f := &planFile{file: file, w: csv.NewWriter(file)}
+	f.w.Write([]string{"bucket", "type", "key", "version_id"})
+	return f, nil
+}
+
+//Characters kept as they are in partition file names, anything else becomes _
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+//partitionName is the file a plan row goes to with -partition-plan: the bucket plus the key's top-level prefix.
+//Keys at the top level share _root, and the bucket's own row goes to _bucket.
+func partitionName(bucket string, entryType string, key string) string {
+	prefix := "_root"
+	switch {
+	case entryType == planBucketRow:
+		prefix = "_bucket"
+	case strings.Contains(key, "/"):
+		prefix = unsafeFileChars.ReplaceAllString(key[:strings.Index(key, "/")], "_")
+	}
+	return bucket + "." + prefix + ".csv"
+}
+
+func (p *planWriter) add(bucket string, entryType string, key string, versionId string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	f := p.single
+	if f == nil {
+		name := partitionName(bucket, entryType, key)
+		if f = p.files[name]; f == nil {
+			var err error
+			if f, err = createPlanFile(filepath.Join(p.dir, name)); err != nil {
+				exitErrorf("Unable to create plan partition %s: %v", name, err)
+			}
+			p.files[name] = f
+		}
+	}
+	f.w.Write([]string{bucket, entryType, key, versionId})
+}
+
+func (p *planWriter) close() error {
+	if p.single != nil {
+		return p.single.close()
+	}
+	var firstErr error
+	for _, f := range p.files {
+		if err := f.close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (f *planFile) close() error {
+	f.w.Flush()
+	if err := f.w.Error(); err != nil {
+		f.file.Close()
+		return err
+	}
+	return f.file.Close()
+}
+
+//planEntries is the dry run's stand-in for deleting: it logs and records what would be deleted
+func (j *bucketJob) planEntries(entries []s3Entry) *errgroup.Group {
+	for _, entry := range entries {
+		atomic.AddInt64(&j.stats.planned, 1)
+		if *verbosity {
+			InfoLogger.Printf("Would delete %s %s: %s\n", entry.Type, aws.StringValue(entry.Key), aws.StringValue(entry.VersionId))
+		}
+		if planDiff != nil {
+			planDiff.see(j.name, entry)
+		}
+		if *probe {
+			var ok bool
+			if entry, ok = j.pickProbeEntry(entry); !ok {
+				continue
+			}
+		}
+		if planOut != nil {
+			planOut.add(j.name, entry.Type, aws.StringValue(entry.Key), aws.StringValue(entry.VersionId))
+		}
+	}
+	return &errgroup.Group{}
+}
+
+//savedPlan is a plan read back by -plan-in, grouped by bucket
+type savedPlan struct {
+	buckets       []string
+	entries       map[string][]s3Entry
+	deleteBuckets map[string]bool
+}
+
+var loadedPlan *savedPlan
+
+//readPlan loads a plan written by -plan-out, either a single file or a -partition-plan directory
+func readPlan(path string) (*savedPlan, error) {
+	p := &savedPlan{entries: map[string][]s3Entry{}, deleteBuckets: map[string]bool{}}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return p, p.readFile(path)
+	}
+	files, err := filepath.Glob(filepath.Join(path, "*.csv"))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if err := p.readFile(file); err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+	}
+	return p, nil
+}
+
+func (p *savedPlan) readFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	r := csv.NewReader(file)
+	r.FieldsPerRecord = 4
+	if _, err := r.Read(); err != nil {
+		return fmt.Errorf("reading header: %v", err)
+	}
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		bucket, entryType, key, versionId := row[0], row[1], row[2], row[3]
+		if _, seen := p.entries[bucket]; !seen && !p.deleteBuckets[bucket] {
+			p.buckets = append(p.buckets, bucket)
+			p.entries[bucket] = nil
+		}
+		switch entryType {
+		case planBucketRow:
+			p.deleteBuckets[bucket] = true
+		case "Marker", "Version", "Object":
+			entry := s3Entry{Key: aws.String(key), Type: entryType}
+			if versionId != "" {
+				entry.VersionId = aws.String(versionId)
+			}
+			p.entries[bucket] = append(p.entries[bucket], entry)
+		default:
+			return fmt.Errorf("unknown row type %q", entryType)
+		}
+	}
+	return nil
+}
+
+func planKey(key *string, versionId *string) string {
+	return aws.StringValue(key) + "\x00" + aws.StringValue(versionId)
+}
+
+//executePlan deletes exactly the bucket's entries from -plan-in, and the bucket if the plan says so.
+//The bucket is re-listed first so entries that no longer exist are reported instead of silently skipped.
+//It returns why it stopped early, if it did.
+func (j *bucketJob) executePlan() error {
+	planned := loadedPlan.entries[j.name]
+	remaining := make(map[string]s3Entry, len(planned))
+	for _, entry := range planned {
+		remaining[planKey(entry.Key, entry.VersionId)] = entry
+	}
+
+	var present []s3Entry
+	err := j.listVersionPages(&s3.ListObjectVersionsInput{
+		Bucket:  aws.String(j.name),
+		MaxKeys: aws.Int64(listPageSize),
+	},
+		func(page *s3.ListObjectVersionsOutput, lastPage bool) bool {
+			listed := append(markerEntries(page.DeleteMarkers), versionEntries(page.Versions)...)
+			for _, entry := range listed {
+				k := planKey(entry.Key, entry.VersionId)
+				if _, ok := remaining[k]; ok {
+					present = append(present, entry)
+					delete(remaining, k)
+				}
+			}
+			return !lastPage
+		})
+	if err != nil && j.followRedirect(err) {
+		return j.executePlan()
+	}
+	if j.timedOut() {
+		return j.ctx.Err()
+	}
+	if err != nil {
+		return fmt.Errorf("unable to list versions of %s to check the plan: %w", j.name, bucketError(j.name, err))
+	}
+	//With the listing cut short by -listing-error=skip-page, the entries not found may just not have been listed
+	if j.listingSkipped > 0 {
+		WarningLogger.Printf("The listing of %s was cut short, %d planned entries it didn't reach are left for another run\n", j.name, len(remaining))
+	} else if len(remaining) > 0 {
+		if auditLog != nil {
+			j.auditMissing(remaining)
+		}
+		WarningLogger.Printf("%d of %d planned entries in %s no longer exist\n", len(remaining), len(planned), j.name)
+		if *verbosity {
+			for k := range remaining {
+				WarningLogger.Printf("Missing from %s: %q\n", j.name, k)
+			}
+		}
+	}
+
+	InfoLogger.Printf("Deleting %d planned entries from %s\n", len(present), j.name)
+	for start := 0; start < len(present); start += int(listPageSize) {
+		end := start + int(listPageSize)
+		if end > len(present) {
+			end = len(present)
+		}
+		err := j.dispatchEntries(present[start:end]).Wait()
+		if j.timedOut() {
+			return j.ctx.Err()
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if auditLog != nil {
+		j.failedMu.Lock()
+		for _, entry := range j.failed {
+			auditLog.record(j.name, entry.Type, aws.StringValue(entry.Key), aws.StringValue(entry.VersionId), "failed")
+		}
+		j.failedMu.Unlock()
+	}
+
+	if loadedPlan.deleteBuckets[j.name] {
+		if len(remaining) > 0 && j.listingSkipped == 0 {
+			err := fmt.Errorf("bucket %s changed since the plan was made, %d planned entries no longer exist", j.name, len(remaining))
+			ErrorLogger.Printf("Not deleting bucket %s: %v\n", j.name, err)
+			recordBucketFailure(j.name, err)
+			return nil
+		}
+		j.finishEmptied()
+		if auditLog != nil && j.stats.bucketDeleted {
+			auditLog.record(j.name, planBucketRow, "", "", "deleted")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,237 @@
+package deleter
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestPlanRoundTrip(t *testing.T) {
+	rows := []struct {
+		bucket, entryType, key, versionId string
+	}{
+		{"demo", "Marker", "a/marker", "v3"},
+		{"demo", "Version", "a/b,c.dat", "v2"},
+		{"demo", "Version", "quoted \"key\"\nwith a newline", "v1"},
+		{"demo", "Object", "top-level", ""},
+		{"demo", planBucketRow, "", ""},
+		{"other", "Version", "x/y", "v9"},
+	}
+	for _, partition := range []bool{false, true} {
+		partition := partition
+		t.Run(map[bool]string{false: "single file", true: "partitioned"}[partition], func(t *testing.T) {
+			setFlags(t, map[string]string{"partition-plan": map[bool]string{false: "false", true: "true"}[partition]})
+			path := filepath.Join(t.TempDir(), "plan.csv")
+			w, err := createPlan(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, row := range rows {
+				w.add(row.bucket, row.entryType, row.key, row.versionId)
+			}
+			if err := w.close(); err != nil {
+				t.Fatal(err)
+			}
+
+			p, err := readPlan(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !p.deleteBuckets["demo"] || p.deleteBuckets["other"] {
+				t.Errorf("buckets to delete %v, want only demo", p.deleteBuckets)
+			}
+			var got []string
+			for _, bucket := range []string{"demo", "other"} {
+				for _, entry := range p.entries[bucket] {
+					got = append(got, bucket+"|"+entry.Type+"|"+planKey(entry.Key, entry.VersionId))
+					if aws.StringValue(entry.Key) == "top-level" && entry.VersionId != nil {
+						t.Errorf("an entry without a version ID came back with %q", *entry.VersionId)
+					}
+				}
+			}
+			var want []string
+			for _, row := range rows {
+				if row.entryType != planBucketRow {
+					want = append(want, row.bucket+"|"+row.entryType+"|"+planKey(aws.String(row.key), aws.String(row.versionId)))
+				}
+			}
+			//Partitions are read back one file after the other, so only the single file keeps the order
+			if partition {
+				sort.Strings(got)
+				sort.Strings(want)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("read back\n%q\nwant\n%q", got, want)
+			}
+		})
+	}
+}
+
+//failingDeleteS3 is the fake bucket, refusing every delete of one key
+type failingDeleteS3 struct {
+	*fakeS3
+	key string
+}
+
+func (f *failingDeleteS3) DeleteObjectWithContext(ctx aws.Context, input *s3.DeleteObjectInput, opts ...request.Option) (*s3.DeleteObjectOutput, error) {
+	if aws.StringValue(input.Key) == f.key {
+		return nil, awserr.NewRequestFailure(awserr.New("AccessDenied", "Access Denied", nil), http.StatusForbidden, "fake")
+	}
+	return f.fakeS3.DeleteObjectWithContext(ctx, input, opts...)
+}
+
+//dryRunPlan has a dry run of bucket f write its plan, and loads it back as -plan-in would
+func dryRunPlan(t *testing.T, f *fakeS3) *savedPlan {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "plan.csv")
+	t.Run("dry run", func(t *testing.T) {
+		setFlags(t, map[string]string{"dry-run": "true"})
+		var err error
+		if planOut, err = createPlan(path); err != nil {
+			t.Fatal(err)
+		}
+		defer func() { planOut = nil }()
+		newTestJob(t, f).dryRun()
+		if err := planOut.close(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	p, err := readPlan(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestExecutePlan(t *testing.T) {
+	setFlags(t, map[string]string{"bucket-delete-grace": "1ms"})
+	defer func() {
+		loadedPlan = nil
+		failedBuckets = nil
+	}()
+
+	t.Run("deletes the planned bucket", func(t *testing.T) {
+		f := newFakeS3("demo", 20)
+		loadedPlan = dryRunPlan(t, f)
+		if n := len(loadedPlan.entries["demo"]); n != 20*fakeVersionsPerKey+4 {
+			t.Fatalf("%d entries planned, want %d", n, 20*fakeVersionsPerKey+4)
+		}
+		j := newTestJob(t, f)
+		if err := j.executePlan(); err != nil {
+			t.Fatal(err)
+		}
+		if !f.deleted || !j.stats.bucketDeleted {
+			t.Error("the bucket wasn't deleted")
+		}
+	})
+
+	t.Run("keeps a bucket that changed", func(t *testing.T) {
+		failedBuckets = nil
+		f := newFakeS3("demo", 20)
+		loadedPlan = dryRunPlan(t, f)
+		f.mu.Lock()
+		delete(f.keys, "fake/000/000000.dat")
+		f.mu.Unlock()
+		j := newTestJob(t, f)
+		if err := j.executePlan(); err != nil {
+			t.Fatal(err)
+		}
+		if f.deleted || j.stats.bucketDeleted {
+			t.Error("a bucket whose planned entries went missing was deleted")
+		}
+		if len(failedBuckets) != 1 {
+			t.Errorf("%d bucket failures recorded, want 1", len(failedBuckets))
+		}
+	})
+
+	t.Run("keeps a bucket with failed deletes", func(t *testing.T) {
+		failedBuckets = nil
+		f := newFakeS3("demo", 20)
+		loadedPlan = dryRunPlan(t, f)
+		j := newTestJob(t, &failingDeleteS3{fakeS3: f, key: "fake/001/000001.dat"})
+		if err := j.executePlan(); err != nil {
+			t.Fatal(err)
+		}
+		if f.deleted {
+			t.Error("a bucket with failed deletes was deleted")
+		}
+		if len(failedBuckets) != 1 || exitCode(failedBuckets[0].err) != exitPartialFailure {
+			t.Errorf("bucket failures %v, want one partial failure", failedBuckets)
+		}
+	})
+}
+
+//flakyListS3 is the fake bucket, failing its first failures versions listings with a 503
+type flakyListS3 struct {
+	*fakeS3
+	failures int
+}
+
+func (f *flakyListS3) ListObjectVersionsPagesWithContext(ctx aws.Context, input *s3.ListObjectVersionsInput, fn func(*s3.ListObjectVersionsOutput, bool) bool, opts ...request.Option) error {
+	if f.failures > 0 {
+		f.failures--
+		return awserr.NewRequestFailure(awserr.New("SlowDown", "Please reduce your request rate", nil), http.StatusServiceUnavailable, "fake")
+	}
+	return f.fakeS3.ListObjectVersionsPagesWithContext(ctx, input, fn, opts...)
+}
+
+func TestExecutePlanListingError(t *testing.T) {
+	setFlags(t, map[string]string{"bucket-delete-grace": "0", "list-retry-initial": "1ms"})
+	defer func() {
+		loadedPlan = nil
+		failedBuckets = nil
+	}()
+
+	t.Run("retry", func(t *testing.T) {
+		f := newFakeS3("demo", 20)
+		loadedPlan = dryRunPlan(t, f)
+		j := newTestJob(t, &flakyListS3{fakeS3: f, failures: 2})
+		if err := j.executePlan(); err != nil {
+			t.Fatal(err)
+		}
+		if !f.deleted {
+			t.Error("the bucket wasn't deleted after the re-list was retried")
+		}
+	})
+
+	t.Run("skip-page", func(t *testing.T) {
+		setFlags(t, map[string]string{"listing-error": "skip-page"})
+		failedBuckets = nil
+		f := newFakeS3("demo", 20)
+		loadedPlan = dryRunPlan(t, f)
+		j := newTestJob(t, &flakyListS3{fakeS3: f, failures: 1})
+		if err := j.executePlan(); err != nil {
+			t.Fatal(err)
+		}
+		if f.deleted {
+			t.Error("the bucket was deleted although its listing was cut short")
+		}
+		if len(failedBuckets) != 1 || !strings.Contains(failedBuckets[0].err.Error(), "skip-page") {
+			t.Errorf("bucket failures %v, want one for the cut short listing", failedBuckets)
+		}
+	})
+}
+
+func TestProbeLeftOutOfPlan(t *testing.T) {
+	setFlags(t, map[string]string{"probe": "true", "force": "true", "bucket-delete-grace": "0"})
+	defer func() { loadedPlan = nil }()
+	f := newFakeS3("demo", 20)
+	loadedPlan = dryRunPlan(t, f)
+	if n := len(loadedPlan.entries["demo"]); n != 20*fakeVersionsPerKey+4-1 {
+		t.Fatalf("%d entries planned, want all but the probed one, %d", n, 20*fakeVersionsPerKey+4-1)
+	}
+	j := newTestJob(t, f)
+	if err := j.executePlan(); err != nil {
+		t.Fatal(err)
+	}
+	if !f.deleted {
+		t.Error("the bucket wasn't deleted, the plan still expected the probed entry")
+	}
+}
@@ -0,0 +1,108 @@
+package deleter
+
+import (
+	"encoding/csv"
+	"github.com/aws/aws-sdk-go/aws"
+	"os"
+	"sort"
+	"sync"
+)
+
+//planDiffer compares a dry run with the -plan-diff plan of an earlier one, writing each entry's status to the
+//-plan-diff-out report as it goes: new (not in the earlier plan), unchanged (in both) or gone (in the earlier
+//plan but not found now, so deleted in between). Gone rows are only written for buckets this run covered.
+type planDiffer struct {
+	mu        sync.Mutex
+	previous  map[string]map[string]s3Entry
+	checked   map[string]bool
+	file      *os.File
+	w         *csv.Writer
+	new       int
+	unchanged int
+	gone      int
+}
+
+//Open -plan-diff comparison, nil when not diffing
+var planDiff *planDiffer
+
+func newPlanDiffer(previousPath string, outPath string) (*planDiffer, error) {
+	previous, err := readPlan(previousPath)
+	if err != nil {
+		return nil, err
+	}
+	d := &planDiffer{previous: map[string]map[string]s3Entry{}, checked: map[string]bool{}}
+	for bucket, entries := range previous.entries {
+		d.previous[bucket] = make(map[string]s3Entry, len(entries))
+		for _, entry := range entries {
+			d.previous[bucket][planKey(entry.Key, entry.VersionId)] = entry
+		}
+	}
+	if d.file, err = os.Create(outPath); err != nil {
+		return nil, err
+	}
+	d.w = csv.NewWriter(d.file)
+	d.w.Write([]string{"status", "bucket", "type", "key", "version_id"})
+	return d, nil
+}
+
+//check notes that the dry run looked at the bucket, whether or not it finds anything in it now
+func (d *planDiffer) check(bucket string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.checked[bucket] = true
+}
+
+//see records an entry the dry run would delete
+func (d *planDiffer) see(bucket string, entry s3Entry) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	status := "new"
+	k := planKey(entry.Key, entry.VersionId)
+	if _, ok := d.previous[bucket][k]; ok {
+		status = "unchanged"
+		delete(d.previous[bucket], k)
+		d.unchanged++
+	} else {
+		d.new++
+	}
+	d.write(status, bucket, entry)
+}
+
+func (d *planDiffer) write(status string, bucket string, entry s3Entry) {
+	d.w.Write([]string{status, bucket, entry.Type, aws.StringValue(entry.Key), aws.StringValue(entry.VersionId)})
+}
+
+//close writes the gone rows, closes the report and logs the counts
+func (d *planDiffer) close(previousPath string, outPath string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	buckets := make([]string, 0, len(d.previous))
+	for bucket := range d.previous {
+		buckets = append(buckets, bucket)
+	}
+	sort.Strings(buckets)
+	var unchecked []string
+	for _, bucket := range buckets {
+		if !d.checked[bucket] {
+			unchecked = append(unchecked, bucket)
+			continue
+		}
+		for _, entry := range d.previous[bucket] {
+			d.write("gone", bucket, entry)
+			d.gone++
+		}
+	}
+	d.w.Flush()
+	if err := d.w.Error(); err != nil {
+		d.file.Close()
+		return err
+	}
+	if err := d.file.Close(); err != nil {
+		return err
+	}
+	InfoLogger.Printf("Compared with %s: %d new, %d unchanged, %d gone, written to %s\n", previousPath, d.new, d.unchanged, d.gone, outPath)
+	for _, bucket := range unchecked {
+		WarningLogger.Printf("%s is in %s but wasn't part of this run, its entries weren't compared\n", bucket, previousPath)
+	}
+	return nil
+}
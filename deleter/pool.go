@@ -0,0 +1,456 @@
+package deleter
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"math"
+	"math/rand"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//How often the adaptive controller re-evaluates the pool size
+const adaptiveInterval = time.Second
+
+//concurrencyFlag is a worker count that can also be given as "auto"
+type concurrencyFlag struct {
+	n    int
+	auto bool
+}
+
+func (f *concurrencyFlag) String() string {
+	if f.auto {
+		return "auto"
+	}
+	return strconv.Itoa(f.n)
+}
+
+func (f *concurrencyFlag) Set(value string) error {
+	if value == "auto" {
+		f.auto = true
+		return nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	f.n, f.auto = n, false
+	return nil
+}
+
+//Assumed round trip of one delete, used to size the pool for a target rate
+const assumedDeleteLatency = 100 * time.Millisecond
+
+//autoConcurrency picks a pool size for -concurrency=auto. With -rate set it is the number of
+//workers needed to sustain that rate at assumedDeleteLatency, doubled for latency spikes.
+//Without a rate it is 64 workers per CPU. Either way it stays between NumCPU and 1000.
+func autoConcurrency(rate float64) int {
+	cpus := runtime.NumCPU()
+	n := 64 * cpus
+	if rate > 0 {
+		n = rateConcurrency(rate)
+	}
+	if n < cpus {
+		n = cpus
+	}
+	if n > 1000 {
+		n = 1000
+	}
+	return n
+}
+
+//rateConcurrency is how many workers it takes to keep up with rate requests a second at assumedDeleteLatency,
+//with 2× headroom for slow requests
+func rateConcurrency(rate float64) int {
+	return int(math.Ceil(rate*assumedDeleteLatency.Seconds())) * 2
+}
+
+//How many times more workers than -rate can keep busy -concurrency has to be before it is warned about
+const idleWorkerFactor = 4
+
+//checkIdleWorkers warns when -concurrency is far above what -rate can keep busy: the extra workers only wait
+//on the rate limiter, which costs goroutines and makes a slow run look like a stuck one. With
+//-cap-concurrency -concurrency is lowered to what the rate can sustain instead.
+func checkIdleWorkers() {
+	if *rateLimit <= 0 {
+		return
+	}
+	busy := rateConcurrency(*rateLimit)
+	if *concurrency <= busy*idleWorkerFactor {
+		return
+	}
+	if *capConcurrency {
+		InfoLogger.Printf("Lowering -concurrency from %d to %d, as many as -rate %g keeps busy\n", *concurrency, busy, *rateLimit)
+		*concurrency = busy
+		return
+	}
+	WarningLogger.Printf("-rate %g keeps only about %d of the %d -concurrency workers busy, the rest will wait on the rate limiter: "+
+		"raise -rate, lower -concurrency or set -cap-concurrency\n", *rateLimit, busy, *concurrency)
+}
+
+//workerPool bounds the number of deletes in flight. Its size can be changed while it is in use.
+type workerPool struct {
+	//Throttling errors seen since the adaptive controller last looked, updated atomically
+	throttles int64
+
+	mu    sync.Mutex
+	cond  *sync.Cond
+	size  int
+	inUse int
+}
+
+func newWorkerPool(size int) *workerPool {
+	p := &workerPool{size: size}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+//acquire blocks until a worker slot is free
+func (p *workerPool) acquire() {
+	p.mu.Lock()
+	for p.inUse >= p.size {
+		p.cond.Wait()
+	}
+	p.inUse++
+	p.mu.Unlock()
+}
+
+func (p *workerPool) release() {
+	p.mu.Lock()
+	p.inUse--
+	p.mu.Unlock()
+	p.cond.Signal()
+}
+
+//resize changes the pool size. Shrinking doesn't interrupt running deletes, it only holds back new ones.
+func (p *workerPool) resize(size int) {
+	p.mu.Lock()
+	p.size = size
+	p.mu.Unlock()
+	p.cond.Broadcast()
+}
+
+func (p *workerPool) Size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.size
+}
+
+func (p *workerPool) recordThrottle() {
+	atomic.AddInt64(&p.throttles, 1)
+}
+
+//adaptConcurrency grows the pool while deletes succeed and halves it when throttling is observed (AIMD).
+//It never goes below min, so a run that is throttled for good keeps making some progress, with backoff
+//spacing out those few workers' requests. It runs until done is closed.
+func adaptConcurrency(p *workerPool, min int, max int, done <-chan struct{}) {
+	ticker := time.NewTicker(adaptiveInterval)
+	defer ticker.Stop()
+	atFloor := false
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		size := p.Size()
+		next := size
+		if atomic.SwapInt64(&p.throttles, 0) > 0 {
+			next = size / 2
+			//Once per stint at the floor, it is the sign the account is severely rate limited
+			if next <= min && !atFloor {
+				atFloor = true
+				WarningLogger.Printf("Adaptive concurrency is down to -adaptive-min (%d) and S3 is still throttling, the account is severely rate limited\n", min)
+			}
+		} else {
+			step := size / 10
+			if step < 1 {
+				step = 1
+			}
+			next = size + step
+		}
+		if next < min {
+			next = min
+		}
+		if next > max {
+			next = max
+		}
+		if next > min {
+			atFloor = false
+		}
+		if next != size {
+			p.resize(next)
+			if *verbosity {
+				InfoLogger.Printf("Adaptive concurrency %d -> %d\n", size, next)
+			}
+		}
+	}
+}
+
+//Worker pool shared by every bucket of a multi-bucket run, so -concurrency bounds the deletes in flight
+//across the run rather than per bucket. nil with a single bucket or -per-bucket-pools.
+var sharedPool *workerPool
+
+//startPool makes a pool of -concurrency workers for name (a bucket, or all of them), starts -adaptive or -ramp-up
+//on it and registers it for the concurrency signals. stop undoes that once the pool is no longer used.
+func startPool(name string) (p *workerPool, stop func()) {
+	p = newWorkerPool(*concurrency)
+	done := make(chan struct{})
+	if *adaptive {
+		//Start low and let the controller find the limit
+		start := 16
+		if start < *adaptiveMin {
+			start = *adaptiveMin
+		}
+		if start > *adaptiveMax {
+			start = *adaptiveMax
+		}
+		p.resize(start)
+		go adaptConcurrency(p, *adaptiveMin, *adaptiveMax, done)
+	} else if *rampUp > 0 && *concurrency > 1 {
+		go rampConcurrency(p, name, *concurrency, *rampUp, done)
+	}
+	registerPool(p, name)
+	return p, func() {
+		close(done)
+		unregisterPool(p)
+	}
+}
+
+//rampConcurrency grows the pool linearly from 1 worker to target over the -ramp-up period, so S3 can scale
+//its request rate for a cold bucket instead of answering a sudden burst with SlowDown. It stops early if done is closed.
+func rampConcurrency(p *workerPool, bucket string, target int, over time.Duration, done <-chan struct{}) {
+	interval := time.Second
+	if over/10 < interval {
+		interval = over / 10
+	}
+	if *verbosity {
+		InfoLogger.Printf("Ramping %s from 1 to %d workers over %s, resizing every %s\n", bucket, target, over, interval)
+	}
+	p.resize(1)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	start := time.Now()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			elapsed := now.Sub(start)
+			if elapsed >= over {
+				p.resize(target)
+				if *verbosity {
+					InfoLogger.Printf("Ramp-up of %s done at %d workers\n", bucket, target)
+				}
+				return
+			}
+			p.resize(1 + int(float64(target-1)*elapsed.Seconds()/over.Seconds()))
+		}
+	}
+}
+
+//Pools of the buckets being emptied right now, so a signal can resize all of them
+var (
+	activePoolsMu sync.Mutex
+	activePools   = map[*workerPool]string{}
+)
+
+func registerPool(p *workerPool, bucket string) {
+	activePoolsMu.Lock()
+	activePools[p] = bucket
+	activePoolsMu.Unlock()
+}
+
+func unregisterPool(p *workerPool) {
+	activePoolsMu.Lock()
+	delete(activePools, p)
+	activePoolsMu.Unlock()
+}
+
+//nudgeConcurrency grows (or shrinks) every active pool by a quarter, at least 1,
+//staying within -adaptive-min and -adaptive-max. It is driven by SIGUSR1/SIGUSR2.
+func nudgeConcurrency(grow bool) {
+	activePoolsMu.Lock()
+	defer activePoolsMu.Unlock()
+	if len(activePools) == 0 {
+		InfoLogger.Print("No bucket is being emptied, concurrency unchanged\n")
+		return
+	}
+	for p, bucket := range activePools {
+		size := p.Size()
+		step := size / 4
+		if step < 1 {
+			step = 1
+		}
+		next := size - step
+		if grow {
+			next = size + step
+		}
+		if next < *adaptiveMin {
+			next = *adaptiveMin
+		}
+		if next > *adaptiveMax {
+			next = *adaptiveMax
+		}
+		p.resize(next)
+		InfoLogger.Printf("Concurrency for %s %d -> %d\n", bucket, size, next)
+	}
+}
+
+//pageLimit caps the deletes in flight for one page at -workers-per-page, on top of the bucket's worker pool
+type pageLimit chan struct{}
+
+//newPageLimit returns the cap for a new page, nil (no cap) when -workers-per-page isn't set
+func newPageLimit() pageLimit {
+	if *workersPerPage == 0 {
+		return nil
+	}
+	return make(pageLimit, *workersPerPage)
+}
+
+func (l pageLimit) acquire() {
+	if l != nil {
+		l <- struct{}{}
+	}
+}
+
+func (l pageLimit) release() {
+	if l != nil {
+		<-l
+	}
+}
+
+//Most -parallel-pages, since every page in flight is held in memory
+const maxParallelPages = 16
+
+//pagePipeline overlaps the deletes of one listing page with fetching the next, when -workers-per-page is set.
+//Pages are still deleted one at a time and in order; only the listing runs ahead by one page.
+//With -parallel-pages up to that many pages are deleted at once instead, in no particular order.
+type pagePipeline struct {
+	pending chan error
+
+	slots   chan struct{}
+	running sync.WaitGroup
+	errMu   sync.Mutex
+	err     error
+}
+
+//run starts deleting a page once the previous page is done, returning the previous page's error.
+//Without -workers-per-page it deletes the page right away and returns its error.
+func (p *pagePipeline) run(deletePage func() error) error {
+	if *parallelPages > 1 {
+		return p.runParallel(deletePage)
+	}
+	if *workersPerPage == 0 {
+		return deletePage()
+	}
+	if err := p.wait(); err != nil {
+		return err
+	}
+	p.pending = make(chan error, 1)
+	go func(done chan<- error) {
+		done <- deletePage()
+	}(p.pending)
+	return nil
+}
+
+//runParallel starts deleting a page as soon as fewer than -parallel-pages are in flight, returning the first error
+//of any page before it
+func (p *pagePipeline) runParallel(deletePage func() error) error {
+	if err := p.firstErr(); err != nil {
+		return err
+	}
+	if p.slots == nil {
+		p.slots = make(chan struct{}, *parallelPages)
+	}
+	p.slots <- struct{}{}
+	p.running.Add(1)
+	go func() {
+		defer p.running.Done()
+		defer func() { <-p.slots }()
+		if err := deletePage(); err != nil {
+			p.errMu.Lock()
+			if p.err == nil {
+				p.err = err
+			}
+			p.errMu.Unlock()
+		}
+	}()
+	return nil
+}
+
+func (p *pagePipeline) firstErr() error {
+	p.errMu.Lock()
+	defer p.errMu.Unlock()
+	return p.err
+}
+
+//wait blocks until the pages in flight, if any, are done
+func (p *pagePipeline) wait() error {
+	if *parallelPages > 1 {
+		p.running.Wait()
+		return p.firstErr()
+	}
+	if p.pending == nil {
+		return nil
+	}
+	err := <-p.pending
+	p.pending = nil
+	return err
+}
+
+//Source for -order shuffled and -shuffle-within-page. It is shared by every bucket, so it is locked.
+var (
+	shuffleMu  sync.Mutex
+	shuffleRng *rand.Rand
+)
+
+//seedShuffle seeds the shuffles from -seed, or from the clock when no seed is given
+func seedShuffle(seed int64) {
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	} else if *order == "shuffled" || *shuffleWithinPage {
+		InfoLogger.Printf("Shuffling with -seed %d\n", seed)
+	}
+	shuffleRng = rand.New(rand.NewSource(seed))
+}
+
+//shuffleEntries puts a page's entries in random order, spreading the page's deletes over its key range
+func shuffleEntries(entries []s3Entry) []s3Entry {
+	shuffleMu.Lock()
+	shuffleRng.Shuffle(len(entries), func(i, j int) {
+		entries[i], entries[j] = entries[j], entries[i]
+	})
+	shuffleMu.Unlock()
+	return entries
+}
+
+//orderPage puts a page's entries in the order they are handed to the workers: random with -shuffle-within-page,
+//otherwise the -delete-order sort
+func orderPage(entries []s3Entry) []s3Entry {
+	if *shuffleWithinPage {
+		return shuffleEntries(entries)
+	}
+	return sortEntries(entries)
+}
+
+//sortEntries orders the entries of a page by LastModified for -delete-order, so they are handed to the
+//workers newest or oldest first. Listings come sorted by key, so this only reorders within a page.
+func sortEntries(entries []s3Entry) []s3Entry {
+	newestFirst := *deleteOrder == "modified-desc"
+	if !newestFirst && *deleteOrder != "modified-asc" {
+		return entries
+	}
+	sort.SliceStable(entries, func(a, b int) bool {
+		ta, tb := aws.TimeValue(entries[a].LastModified), aws.TimeValue(entries[b].LastModified)
+		if newestFirst {
+			return ta.After(tb)
+		}
+		return ta.Before(tb)
+	})
+	return entries
+}
@@ -0,0 +1,53 @@
+package deleter
+
+import (
+	"flag"
+	"strings"
+)
+
+//presetFlag is one flag value a preset sets, unless skip says it would clash with the flags given
+type presetFlag struct {
+	name  string
+	value string
+	skip  func() bool
+}
+
+//safePreset is what -safe turns on. Most are the defaults, listed so a preset run doesn't depend on them staying
+//that way. -safe also checks that a -b bucket belongs to the caller's account, as discovery always does.
+var safePreset = []presetFlag{
+	{"force", "false", nil},
+	{"retry-all-errors", "false", nil},
+	{"max-access-denied", "10", nil},
+	{"allow-cross-account", "false", nil},
+	{"delete-even-if-failed", "false", nil},
+	//A bucket that is already empty has no later point to confirm at
+	{"confirm-before-bucket-delete", "true", func() bool { return *deleteBucketOnly }},
+}
+
+//fastPreset is what -fast turns on, for getting a bucket gone as quickly as possible. The HTTP transport needs
+//nothing: it is sized for -concurrency anyway.
+var fastPreset = []presetFlag{
+	{"batch", "true", nil},
+	//The per-key orders send a key's entries one by one
+	{"include-versioned-batch", "true", perKeyOrder},
+	{"concurrency", "auto", nil},
+	{"verbose-batch", "false", nil},
+	{"skip-verify", "true", nil},
+	{"warm-up", "true", nil},
+}
+
+//applyPreset sets each of the preset's flags that wasn't given on the command line, so any of them can still be
+//overridden one by one. The flags it set are logged.
+func applyPreset(preset string, flags []presetFlag) {
+	var applied []string
+	for _, f := range flags {
+		if flagSet(f.name) || (f.skip != nil && f.skip()) {
+			continue
+		}
+		if err := flag.Set(f.name, f.value); err != nil {
+			exitErrorf("-%s: unable to set -%s=%s: %v", preset, f.name, f.value, err)
+		}
+		applied = append(applied, "-"+f.name+"="+f.value)
+	}
+	InfoLogger.Printf("-%s sets %s\n", preset, strings.Join(applied, " "))
+}
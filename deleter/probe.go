@@ -0,0 +1,85 @@
+package deleter
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+//pickProbeEntry remembers the first entry a -dry-run -probe would delete, preferring a version or object over a
+//delete marker, whose removal would bring an object back rather than take one away. The entry picked stays out of
+//the -plan-out plan until the probe is over, so it returns the entry to plan instead, if there is one: the given
+//entry, or the one it replaces as the probe.
+func (j *bucketJob) pickProbeEntry(entry s3Entry) (s3Entry, bool) {
+	j.probeMu.Lock()
+	defer j.probeMu.Unlock()
+	if j.probeEntry == nil {
+		j.probeEntry = &entry
+		return s3Entry{}, false
+	}
+	if j.probeEntry.Type == "Marker" && entry.Type != "Marker" {
+		replaced := *j.probeEntry
+		j.probeEntry = &entry
+		return replaced, true
+	}
+	return entry, true
+}
+
+//probeDelete is -probe: once the dry run has listed the bucket it really deletes one of the entries it would
+//have deleted anyway, to find out whether the real run has the permissions it needs. The entry is shown and has
+//to be confirmed on a terminal, or -force given; otherwise no probe is made. A denied probe fails the bucket.
+//Unless the probe deleted it, the entry then goes into the -plan-out plan like the rest.
+func (j *bucketJob) probeDelete() {
+	entry := j.probeEntry
+	if entry == nil {
+		InfoLogger.Printf("Nothing in %s the dry run would delete, so no probe delete\n", j.name)
+		return
+	}
+	deleted := false
+	defer func() {
+		if !deleted && planOut != nil {
+			planOut.add(j.name, entry.Type, aws.StringValue(entry.Key), aws.StringValue(entry.VersionId))
+		}
+	}()
+	key, version := aws.StringValue(entry.Key), aws.StringValue(entry.VersionId)
+	WarningLogger.Printf("PROBE: really deleting %s %s (version %s) from %s to test delete permissions, this can't be undone\n", entry.Type, key, version, j.name)
+	if !*force {
+		if !isTerminal(os.Stdin) {
+			WarningLogger.Printf("No terminal to confirm the probe delete on, skipping it (-force confirms it)\n")
+			return
+		}
+		fmt.Printf("Type yes to delete %s %s from %s: ", entry.Type, key, j.name)
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if strings.TrimSpace(answer) != "yes" {
+			InfoLogger.Printf("Probe delete skipped\n")
+			return
+		}
+	}
+	_, err := j.svc.DeleteObjectWithContext(j.ctx, &s3.DeleteObjectInput{
+		Bucket:    aws.String(j.name),
+		Key:       entry.Key,
+		VersionId: entry.VersionId,
+		MFA:       j.mfa,
+	})
+	if err == nil {
+		deleted = true
+		atomic.AddInt64(&deletedCount, 1)
+		atomic.AddInt64(&j.stats.deleted, 1)
+		j.recordDeleted(*entry)
+		InfoLogger.Printf("Probe delete of %s succeeded, the real run can delete from %s\n", key, j.name)
+		return
+	}
+	if aerr, ok := err.(awserr.Error); ok && (aerr.Code() == "AccessDenied" || statusCode(err) == http.StatusForbidden) {
+		err = fmt.Errorf("probe delete of %s was denied, the real run would fail: check s3:DeleteObject and s3:DeleteObjectVersion and the bucket policy: %w", key, err)
+		ErrorLogger.Printf("%v\n", err)
+		recordBucketFailure(j.name, err)
+		return
+	}
+	WarningLogger.Printf("Probe delete of %s failed, which says nothing either way about permissions: %v\n", key, err)
+}
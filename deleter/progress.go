@@ -0,0 +1,106 @@
+package deleter
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+//How often the -progress line is redrawn
+const progressInterval = time.Second
+
+//isTerminal reports whether f is an interactive terminal rather than a file or pipe
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+//progressLine redraws a one-line status on stderr with the delete rate and the retry rate of the last interval.
+//A climbing retry rate is S3 throttling, the cue to lower -concurrency or -rate.
+type progressLine struct {
+	stopping chan struct{}
+	stopped  chan struct{}
+}
+
+//startProgress starts the -progress line, or returns nil when stderr isn't a terminal
+func startProgress() *progressLine {
+	if !isTerminal(os.Stderr) {
+		return nil
+	}
+	p := &progressLine{stopping: make(chan struct{}), stopped: make(chan struct{})}
+	go p.run()
+	return p
+}
+
+func (p *progressLine) run() {
+	defer close(p.stopped)
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	lastAt := time.Now()
+	lastDeleted, lastRetries := atomic.LoadInt64(&deletedCount), atomic.LoadInt64(&retryCount)
+	for {
+		select {
+		case <-p.stopping:
+			fmt.Fprint(os.Stderr, "\r\033[K")
+			return
+		case now := <-ticker.C:
+			deleted, retries := atomic.LoadInt64(&deletedCount), atomic.LoadInt64(&retryCount)
+			seconds := now.Sub(lastAt).Seconds()
+			fmt.Fprintf(os.Stderr, "\r\033[K%d deleted, %.0f obj/s, %.0f retries/s",
+				deleted, float64(deleted-lastDeleted)/seconds, float64(retries-lastRetries)/seconds)
+			lastAt, lastDeleted, lastRetries = now, deleted, retries
+		}
+	}
+}
+
+//stop clears the line so the summary starts on a clean one
+func (p *progressLine) stop() {
+	close(p.stopping)
+	<-p.stopped
+}
+
+//heartbeat logs a plain line on stderr every -heartbeat, for CI logs where the -progress line isn't drawn
+//and a quiet run would otherwise look hung. Unlike InfoLogger it isn't silenced by -q.
+type heartbeat struct {
+	logger   *log.Logger
+	every    time.Duration
+	stopping chan struct{}
+	stopped  chan struct{}
+}
+
+func startHeartbeat(every time.Duration) *heartbeat {
+	h := &heartbeat{
+		logger:   log.New(os.Stderr, "HEARTBEAT: ", log.Ldate|log.Ltime),
+		every:    every,
+		stopping: make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go h.run()
+	return h
+}
+
+func (h *heartbeat) run() {
+	defer close(h.stopped)
+	ticker := time.NewTicker(h.every)
+	defer ticker.Stop()
+	start := time.Now()
+	lastAt, lastDeleted := start, atomic.LoadInt64(&deletedCount)
+	for {
+		select {
+		case <-h.stopping:
+			return
+		case now := <-ticker.C:
+			deleted, retries := atomic.LoadInt64(&deletedCount), atomic.LoadInt64(&retryCount)
+			h.logger.Printf("%d deleted, %.0f obj/s over the last %s, %d retries, running %s\n",
+				deleted, float64(deleted-lastDeleted)/now.Sub(lastAt).Seconds(), h.every, retries, now.Sub(start).Round(time.Second))
+			lastAt, lastDeleted = now, deleted
+		}
+	}
+}
+
+func (h *heartbeat) stop() {
+	close(h.stopping)
+	<-h.stopped
+}
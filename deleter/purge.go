@@ -0,0 +1,203 @@
+package deleter
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+//bucketConfigKind is one kind of bucket-level configuration -purge-config removes.
+//list returns a page of configuration IDs and the token for the next page, "" after the last one.
+type bucketConfigKind struct {
+	name   string
+	list   func(j *bucketJob, token *string) (ids []*string, next *string, err error)
+	remove func(j *bucketJob, id *string) error
+}
+
+var bucketConfigKinds = []bucketConfigKind{
+	{
+		name: "analytics",
+		list: func(j *bucketJob, token *string) ([]*string, *string, error) {
+			out, err := j.svc.ListBucketAnalyticsConfigurationsWithContext(j.ctx, &s3.ListBucketAnalyticsConfigurationsInput{
+				Bucket:            aws.String(j.name),
+				ContinuationToken: token,
+			})
+			if err != nil {
+				return nil, nil, err
+			}
+			var ids []*string
+			for _, c := range out.AnalyticsConfigurationList {
+				ids = append(ids, c.Id)
+			}
+			return ids, out.NextContinuationToken, nil
+		},
+		remove: func(j *bucketJob, id *string) error {
+			_, err := j.svc.DeleteBucketAnalyticsConfigurationWithContext(j.ctx, &s3.DeleteBucketAnalyticsConfigurationInput{
+				Bucket: aws.String(j.name),
+				Id:     id,
+			})
+			return err
+		},
+	},
+	{
+		name: "metrics",
+		list: func(j *bucketJob, token *string) ([]*string, *string, error) {
+			out, err := j.svc.ListBucketMetricsConfigurationsWithContext(j.ctx, &s3.ListBucketMetricsConfigurationsInput{
+				Bucket:            aws.String(j.name),
+				ContinuationToken: token,
+			})
+			if err != nil {
+				return nil, nil, err
+			}
+			var ids []*string
+			for _, c := range out.MetricsConfigurationList {
+				ids = append(ids, c.Id)
+			}
+			return ids, out.NextContinuationToken, nil
+		},
+		remove: func(j *bucketJob, id *string) error {
+			_, err := j.svc.DeleteBucketMetricsConfigurationWithContext(j.ctx, &s3.DeleteBucketMetricsConfigurationInput{
+				Bucket: aws.String(j.name),
+				Id:     id,
+			})
+			return err
+		},
+	},
+	{
+		name: "inventory",
+		list: func(j *bucketJob, token *string) ([]*string, *string, error) {
+			out, err := j.svc.ListBucketInventoryConfigurationsWithContext(j.ctx, &s3.ListBucketInventoryConfigurationsInput{
+				Bucket:            aws.String(j.name),
+				ContinuationToken: token,
+			})
+			if err != nil {
+				return nil, nil, err
+			}
+			var ids []*string
+			for _, c := range out.InventoryConfigurationList {
+				ids = append(ids, c.Id)
+			}
+			return ids, out.NextContinuationToken, nil
+		},
+		remove: func(j *bucketJob, id *string) error {
+			_, err := j.svc.DeleteBucketInventoryConfigurationWithContext(j.ctx, &s3.DeleteBucketInventoryConfigurationInput{
+				Bucket: aws.String(j.name),
+				Id:     id,
+			})
+			return err
+		},
+	},
+}
+
+//bucketSecurityConfigs are the bucket's one-of-a-kind security settings -purge-config removes, so a bucket
+//created later under the same name doesn't start out with them
+var bucketSecurityConfigs = []struct {
+	name   string
+	remove func(j *bucketJob) error
+}{
+	{
+		//S3 goes back to its own SSE-S3 default, it can't be turned off entirely
+		name: "default encryption configuration",
+		remove: func(j *bucketJob) error {
+			_, err := j.svc.DeleteBucketEncryptionWithContext(j.ctx, &s3.DeleteBucketEncryptionInput{
+				Bucket: aws.String(j.name),
+			})
+			return err
+		},
+	},
+	{
+		name: "public access block",
+		remove: func(j *bucketJob) error {
+			_, err := j.svc.DeletePublicAccessBlockWithContext(j.ctx, &s3.DeletePublicAccessBlockInput{
+				Bucket: aws.String(j.name),
+			})
+			return err
+		},
+	},
+	{
+		//The canned private ACL leaves only the owner's own grant. Done before the ownership controls go, since
+		//with ACLs disabled S3 still takes private but answers AccessControlListNotSupported to anything else.
+		name: "ACL grants other than the owner's",
+		remove: func(j *bucketJob) error {
+			_, err := j.svc.PutBucketAclWithContext(j.ctx, &s3.PutBucketAclInput{
+				Bucket: aws.String(j.name),
+				ACL:    aws.String(s3.BucketCannedACLPrivate),
+			})
+			return err
+		},
+	},
+	{
+		name: "ownership controls",
+		remove: func(j *bucketJob) error {
+			_, err := j.svc.DeleteBucketOwnershipControlsWithContext(j.ctx, &s3.DeleteBucketOwnershipControlsInput{
+				Bucket: aws.String(j.name),
+			})
+			return err
+		},
+	},
+}
+
+//isNoSuchConfiguration reports whether a configuration was already gone, which is what -purge-config wanted
+func isNoSuchConfiguration(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	if !ok {
+		return false
+	}
+	switch aerr.Code() {
+	case "NoSuchConfiguration", "NotFound", "ServerSideEncryptionConfigurationNotFoundError", "NoSuchPublicAccessBlockConfiguration",
+		"OwnershipControlsNotFoundError", "AccessControlListNotSupported":
+		return true
+	}
+	return false
+}
+
+//purgeConfig removes the bucket's analytics, metrics, inventory and replication configurations, its default
+//encryption, its public access block, its ACL grants and its ownership controls before DeleteBucket.
+//Failing to list or remove one is only warned about: the bucket delete is still tried, and takes them with it.
+func (j *bucketJob) purgeConfig() {
+	for _, kind := range bucketConfigKinds {
+		var token *string
+		for {
+			ids, next, err := kind.list(j, token)
+			if err != nil {
+				if !isNoSuchConfiguration(err) && j.ctx.Err() == nil {
+					WarningLogger.Printf("Unable to list %s configurations of %s: %v\n", kind.name, j.name, err)
+				}
+				break
+			}
+			for _, id := range ids {
+				err := kind.remove(j, id)
+				if err != nil && !isNoSuchConfiguration(err) {
+					WarningLogger.Printf("Unable to remove %s configuration %s from %s: %v\n", kind.name, aws.StringValue(id), j.name, err)
+					continue
+				}
+				InfoLogger.Printf("Removed %s configuration %s from %s\n", kind.name, aws.StringValue(id), j.name)
+			}
+			if aws.StringValue(next) == "" {
+				break
+			}
+			token = next
+		}
+	}
+	for _, config := range bucketSecurityConfigs {
+		err := config.remove(j)
+		if err != nil && isNoSuchConfiguration(err) {
+			continue
+		}
+		if err != nil {
+			WarningLogger.Printf("Unable to remove the %s from %s: %v\n", config.name, j.name, err)
+			continue
+		}
+		InfoLogger.Printf("Removed the %s from %s\n", config.name, j.name)
+	}
+	if j.replicated {
+		_, err := j.svc.DeleteBucketReplicationWithContext(j.ctx, &s3.DeleteBucketReplicationInput{
+			Bucket: aws.String(j.name),
+		})
+		if err != nil {
+			WarningLogger.Printf("Unable to remove the replication configuration from %s: %v\n", j.name, err)
+			return
+		}
+		InfoLogger.Printf("Removed the replication configuration from %s\n", j.name)
+	}
+}
@@ -0,0 +1,109 @@
+package deleter
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+)
+
+//The region S3 names in an AuthorizationHeaderMalformed message: "the region 'us-east-1' is wrong; expecting 'eu-west-1'"
+var expectingRegion = regexp.MustCompile(`expecting '([a-z0-9-]+)'`)
+
+//What a region name in a -region-map file has to look like, e.g. us-east-1 or us-gov-west-1
+var regionName = regexp.MustCompile(`^[a-z]{2}(-[a-z]+)+-[0-9]+$`)
+
+//loadRegionMap fills the region cache from a -region-map file, so getRegion doesn't look those buckets up.
+//Each line is bucket,region; blank lines and lines starting with # are skipped. It returns how many buckets were mapped.
+func loadRegionMap(path string) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	mapped := map[string]string{}
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		row := strings.Split(text, ",")
+		if len(row) != 2 {
+			return 0, fmt.Errorf("line %d: want bucket,region, got %q", line, text)
+		}
+		bucket, region := strings.TrimSpace(row[0]), strings.TrimSpace(row[1])
+		if bucket == "" {
+			return 0, fmt.Errorf("line %d: empty bucket name", line)
+		}
+		if !regionName.MatchString(region) {
+			return 0, fmt.Errorf("line %d: %q is not a region", line, region)
+		}
+		if prev, ok := mapped[bucket]; ok && prev != region {
+			return 0, fmt.Errorf("line %d: %s is mapped to both %s and %s", line, bucket, prev, region)
+		}
+		mapped[bucket] = region
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+
+	regionCacheMu.Lock()
+	for bucket, region := range mapped {
+		regionCache[bucket] = region
+	}
+	regionCacheMu.Unlock()
+	return len(mapped), nil
+}
+
+//redirectRegion works out the bucket's real region from an error saying the request went to the wrong one.
+//It returns "" if err isn't such an error or the region can't be found.
+func (j *bucketJob) redirectRegion(err error) string {
+	aerr, ok := err.(awserr.Error)
+	if !ok {
+		return ""
+	}
+	switch aerr.Code() {
+	case "AuthorizationHeaderMalformed":
+		if m := expectingRegion.FindStringSubmatch(aerr.Message()); m != nil {
+			return m[1]
+		}
+	case "PermanentRedirect":
+	default:
+		if statusCode(err) != http.StatusMovedPermanently {
+			return ""
+		}
+	}
+	//A PermanentRedirect error body only names the endpoint, the region header of a HeadBucket is easier to rely on
+	sess, sessErr := newSession(j.region)
+	if sessErr != nil {
+		return ""
+	}
+	return regionFromHeadBucket(sess, j.name)
+}
+
+//followRedirect moves the bucket's client to the region S3 redirected to, so the failed call can be retried.
+//It returns false if err isn't a redirect, or it points at the region already in use.
+func (j *bucketJob) followRedirect(err error) bool {
+	region := j.redirectRegion(err)
+	if region == "" || region == j.region {
+		return false
+	}
+	sess, err := newSession(region)
+	if err != nil {
+		return false
+	}
+	if *verbosity {
+		InfoLogger.Printf("%s answered from the wrong region, switching from %s to %s\n", j.name, j.region, region)
+	}
+	regionCacheMu.Lock()
+	regionCache[j.name] = region
+	regionCacheMu.Unlock()
+	j.region = region
+	j.svc = newS3Client(sess)
+	return true
+}
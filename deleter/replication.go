@@ -0,0 +1,50 @@
+package deleter
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"strings"
+)
+
+//checkReplication warns when the bucket replicates to other buckets, naming them: deleting a replication source
+//leaves its destinations with a rule that no longer has a source, and with -strict-replication such a bucket is
+//refused unless -force is given. S3 has no call that tells a destination bucket it is one, so only sources are caught.
+func (j *bucketJob) checkReplication() error {
+	out, err := j.svc.GetBucketReplicationWithContext(j.ctx, &s3.GetBucketReplicationInput{
+		Bucket: aws.String(j.name),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "ReplicationConfigurationNotFoundError" {
+			return nil
+		}
+		//Like with MFA Delete, not being allowed to look doesn't mean there is anything to find
+		if *verbosity {
+			WarningLogger.Printf("Unable to check %s for replication: %v\n", j.name, err)
+		}
+		return nil
+	}
+	if out.ReplicationConfiguration == nil || len(out.ReplicationConfiguration.Rules) == 0 {
+		return nil
+	}
+	j.replicated = true
+
+	var destinations []string
+	seen := map[string]bool{}
+	for _, rule := range out.ReplicationConfiguration.Rules {
+		if rule.Destination == nil {
+			continue
+		}
+		destination := strings.TrimPrefix(aws.StringValue(rule.Destination.Bucket), "arn:aws:s3:::")
+		if !seen[destination] {
+			seen[destination] = true
+			destinations = append(destinations, destination)
+		}
+	}
+	if *strictReplication && !*force && !*dryRun {
+		return fmt.Errorf("%s replicates to %s, which -strict-replication refuses without -force", j.name, strings.Join(destinations, ", "))
+	}
+	WarningLogger.Printf("%s replicates to %s, deleting it breaks that replication\n", j.name, strings.Join(destinations, ", "))
+	return nil
+}
@@ -0,0 +1,130 @@
+package deleter
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"github.com/aws/aws-sdk-go/aws"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+//Successful deletes across the run by kind, updated atomically
+var runObjectsDeleted, runVersionsDeleted, runMarkersDeleted int64
+
+//recordDeleted counts a successful delete towards the bucket's and the run's totals by kind and the summary breakdowns
+func (j *bucketJob) recordDeleted(entry s3Entry) {
+	atomic.AddInt64(j.deletedOfKind(entry.Type), 1)
+	switch entry.Type {
+	case "Version":
+		atomic.AddInt64(&runVersionsDeleted, 1)
+	case "Marker":
+		atomic.AddInt64(&runMarkersDeleted, 1)
+	default:
+		atomic.AddInt64(&runObjectsDeleted, 1)
+	}
+	atomic.AddInt64(&j.stats.bytesDeleted, entry.Size)
+	recordBreakdowns(entry)
+	if auditLog != nil {
+		auditLog.record(j.name, entry.Type, aws.StringValue(entry.Key), aws.StringValue(entry.VersionId), "deleted")
+	}
+}
+
+//deletedOfKind is the bucket's counter for deletes of an entry type: Object, Version or Marker
+func (j *bucketJob) deletedOfKind(deleteType string) *int64 {
+	switch deleteType {
+	case "Version":
+		return &j.stats.versionsDeleted
+	case "Marker":
+		return &j.stats.markersDeleted
+	}
+	return &j.stats.objectsDeleted
+}
+
+//BucketResult is one bucket's record in -results-out
+type BucketResult struct {
+	Name            string  `json:"name"`
+	Region          string  `json:"region"`
+	Objects         int64   `json:"objects_deleted"`
+	Versions        int64   `json:"versions_deleted"`
+	Markers         int64   `json:"markers_deleted"`
+	FreedBytes      int64   `json:"freed_bytes"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	Status          string  `json:"status"`
+	Error           string  `json:"error,omitempty"`
+}
+
+//bucketStatus is how a bucket ended: deleted, emptied (but kept), skipped or failed
+func bucketStatus(bucket BucketStats) string {
+	switch {
+	case len(bucket.Errors) > 0:
+		return "failed"
+	case bucket.Skipped != "":
+		return "skipped"
+	case bucket.BucketDeleted:
+		return "deleted"
+	}
+	return "emptied"
+}
+
+//writeResults writes -results-out, one record per bucket including those that failed, as CSV for a path
+//ending in .csv and as a JSON array otherwise
+func writeResults(path string, start time.Time) error {
+	var results []BucketResult
+	for _, bucket := range summaryStats(start).Buckets {
+		result := BucketResult{
+			Name:            bucket.Name,
+			Region:          bucket.Region,
+			Objects:         bucket.Objects,
+			Versions:        bucket.Versions,
+			Markers:         bucket.Markers,
+			FreedBytes:      bucket.FreedBytes,
+			DurationSeconds: bucket.DurationSeconds,
+			Status:          bucketStatus(bucket),
+			Error:           strings.Join(bucket.Errors, "; "),
+		}
+		if result.Error == "" {
+			result.Error = bucket.Skipped
+		}
+		results = append(results, result)
+	}
+	if !strings.HasSuffix(strings.ToLower(path), ".csv") {
+		if results == nil {
+			results = []BucketResult{}
+		}
+		data, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(path, append(data, '\n'), 0644)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(file)
+	w.Write([]string{"bucket", "region", "objects_deleted", "versions_deleted", "markers_deleted", "freed_bytes", "duration_seconds", "status", "error"})
+	for _, r := range results {
+		w.Write([]string{
+			r.Name,
+			r.Region,
+			strconv.FormatInt(r.Objects, 10),
+			strconv.FormatInt(r.Versions, 10),
+			strconv.FormatInt(r.Markers, 10),
+			strconv.FormatInt(r.FreedBytes, 10),
+			strconv.FormatFloat(r.DurationSeconds, 'f', 1, 64),
+			r.Status,
+			r.Error,
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
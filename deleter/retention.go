@@ -0,0 +1,130 @@
+package deleter
+
+import (
+	"encoding/csv"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//keyRetention is what -version-retention-report found for one key
+type keyRetention struct {
+	bucket, key     string
+	versions        int64
+	markers         int64
+	noncurrentBytes int64
+	totalBytes      int64
+	oldest          time.Time
+}
+
+//Every key of every bucket reported on, written out once the run is over
+var (
+	retentionMu   sync.Mutex
+	retentionRows []*keyRetention
+)
+
+//reportRetention is -version-retention-report: it pages through every version and delete marker of the bucket,
+//or of each -prefix, and tallies them per key without deleting anything. It returns false if the bucket timed out.
+func (j *bucketJob) reportRetention() bool {
+	prefixes := keyPrefixes
+	if len(prefixes) == 0 {
+		prefixes = []string{""}
+	}
+	keys := map[string]*keyRetention{}
+	var order []*keyRetention
+	row := func(key *string) *keyRetention {
+		r, ok := keys[aws.StringValue(key)]
+		if !ok {
+			r = &keyRetention{bucket: j.name, key: aws.StringValue(key)}
+			keys[r.key] = r
+			order = append(order, r)
+		}
+		return r
+	}
+	for _, prefix := range prefixes {
+		input := s3.ListObjectVersionsInput{
+			Bucket:  aws.String(j.name),
+			MaxKeys: aws.Int64(listPageSize),
+		}
+		if prefix != "" {
+			input.Prefix = aws.String(prefix)
+		}
+		err := j.listVersionPages(&input, func(page *s3.ListObjectVersionsOutput, lastPage bool) bool {
+			for _, version := range page.Versions {
+				r := row(version.Key)
+				r.versions++
+				r.totalBytes += aws.Int64Value(version.Size)
+				if !aws.BoolValue(version.IsLatest) {
+					r.noncurrentBytes += aws.Int64Value(version.Size)
+				}
+				if modified := aws.TimeValue(version.LastModified); r.oldest.IsZero() || modified.Before(r.oldest) {
+					r.oldest = modified
+				}
+			}
+			for _, marker := range page.DeleteMarkers {
+				row(marker.Key).markers++
+			}
+			return j.ctx.Err() == nil
+		})
+		if j.timedOut() {
+			return false
+		}
+		if err != nil {
+			exitErrorf("Unable to list versions of %s: %v", j.name, err)
+		}
+	}
+	var versions, markers, reclaimable int64
+	for _, r := range order {
+		versions += r.versions
+		markers += r.markers
+		reclaimable += r.noncurrentBytes
+	}
+	InfoLogger.Printf("%s has %d keys with %d versions and %d delete markers, %d bytes in noncurrent versions\n",
+		j.name, len(order), versions, markers, reclaimable)
+	retentionMu.Lock()
+	retentionRows = append(retentionRows, order...)
+	retentionMu.Unlock()
+	return true
+}
+
+//writeRetentionReport writes the keys reported on as CSV to path, or to stdout for "-", the most reclaimable
+//bytes first. A key with only delete markers left has an empty oldest_version.
+func writeRetentionReport(path string) error {
+	if path == "-" {
+		return encodeRetentionReport(os.Stdout)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := encodeRetentionReport(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func encodeRetentionReport(out io.Writer) error {
+	retentionMu.Lock()
+	defer retentionMu.Unlock()
+	sort.SliceStable(retentionRows, func(a, b int) bool {
+		return retentionRows[a].noncurrentBytes > retentionRows[b].noncurrentBytes
+	})
+	w := csv.NewWriter(out)
+	w.Write([]string{"bucket", "key", "versions", "delete_markers", "noncurrent_bytes", "total_bytes", "oldest_version"})
+	for _, r := range retentionRows {
+		oldest := ""
+		if !r.oldest.IsZero() {
+			oldest = r.oldest.UTC().Format(time.RFC3339)
+		}
+		w.Write([]string{r.bucket, r.key, strconv.FormatInt(r.versions, 10), strconv.FormatInt(r.markers, 10),
+			strconv.FormatInt(r.noncurrentBytes, 10), strconv.FormatInt(r.totalBytes, 10), oldest})
+	}
+	w.Flush()
+	return w.Error()
+}
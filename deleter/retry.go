@@ -0,0 +1,217 @@
+package deleter
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/cenkalti/backoff/v4"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+//retryBudgetFlag is -total-retry-budget: a number of retries, or a duration of retry delays, shared by every
+//delete in the run. Zero for both means no budget.
+type retryBudgetFlag struct {
+	count int64
+	wait  time.Duration
+}
+
+func (f *retryBudgetFlag) String() string {
+	if f.wait > 0 {
+		return f.wait.String()
+	}
+	if f.count > 0 {
+		return strconv.FormatInt(f.count, 10)
+	}
+	return ""
+}
+
+func (f *retryBudgetFlag) Set(value string) error {
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if n <= 0 {
+			return fmt.Errorf("must be positive")
+		}
+		f.count, f.wait = n, 0
+		return nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("not a retry count or a duration")
+	}
+	if d <= 0 {
+		return fmt.Errorf("must be positive")
+	}
+	f.count, f.wait = 0, d
+	return nil
+}
+
+var totalRetryBudget retryBudgetFlag
+
+//Retries and nanoseconds of retry delay spent against -total-retry-budget, and whether running out was logged
+var (
+	budgetRetries   int64
+	budgetWaited    int64
+	budgetExhausted int32
+)
+
+//runBudgetBackOff charges each retry its policy allows to -total-retry-budget, and stops retrying once the
+//budget is spent, so every delete after that is tried once and recorded as failed straight away.
+type runBudgetBackOff struct {
+	backoff.BackOff
+}
+
+func (b *runBudgetBackOff) NextBackOff() time.Duration {
+	next := b.BackOff.NextBackOff()
+	if next == backoff.Stop {
+		return next
+	}
+	retries := atomic.AddInt64(&budgetRetries, 1)
+	waited := time.Duration(atomic.AddInt64(&budgetWaited, int64(next)))
+	if (totalRetryBudget.count > 0 && retries > totalRetryBudget.count) || (totalRetryBudget.wait > 0 && waited > totalRetryBudget.wait) {
+		if atomic.CompareAndSwapInt32(&budgetExhausted, 0, 1) {
+			WarningLogger.Printf("-total-retry-budget of %s is spent, failing deletes without retrying from now on\n", totalRetryBudget.String())
+		}
+		return backoff.Stop
+	}
+	return next
+}
+
+//retryBudgetSpent reports whether -total-retry-budget ran out during the run
+func retryBudgetSpent() bool {
+	return atomic.LoadInt32(&budgetExhausted) == 1
+}
+
+//newDeleteBackOff builds the retry policy for a single delete request
+func newDeleteBackOff() backoff.BackOff {
+	var b backoff.BackOff
+	switch *backoffStrategy {
+	case "constant":
+		b = &maxElapsedBackOff{BackOff: backoff.NewConstantBackOff(*retryInterval), max: *retryBudget}
+	case "linear":
+		b = &maxElapsedBackOff{BackOff: &linearBackOff{step: *retryInterval}, max: *retryBudget}
+	default:
+		exp := backoff.NewExponentialBackOff()
+		exp.InitialInterval = *retryInterval
+		exp.RandomizationFactor = *retryJitter
+		exp.MaxElapsedTime = *retryBudget
+		b = exp
+	}
+	if totalRetryBudget.count > 0 || totalRetryBudget.wait > 0 {
+		b = &runBudgetBackOff{BackOff: b}
+	}
+	return b
+}
+
+//newListBackOff is the policy for retrying a listing page, kept apart from the delete policy: a listing that
+//gives up costs the whole pagination so far, so it is worth being more patient with than a single delete.
+//-retry-jitter applies to both.
+func newListBackOff() backoff.BackOff {
+	b := backoff.NewExponentialBackOff()
+	b.InitialInterval = *listRetryInitial
+	b.MaxInterval = *listRetryMaxInterval
+	b.MaxElapsedTime = *listRetryMaxElapsed
+	b.RandomizationFactor = *retryJitter
+	return b
+}
+
+//linearBackOff waits one step longer before each retry: step, 2*step, 3*step...
+type linearBackOff struct {
+	step  time.Duration
+	count int64
+}
+
+func (b *linearBackOff) NextBackOff() time.Duration {
+	b.count++
+	return time.Duration(b.count) * b.step
+}
+
+func (b *linearBackOff) Reset() {
+	b.count = 0
+}
+
+//maxElapsedBackOff stops a policy that would otherwise retry forever once max has passed since Reset
+type maxElapsedBackOff struct {
+	backoff.BackOff
+	max   time.Duration
+	start time.Time
+}
+
+func (b *maxElapsedBackOff) NextBackOff() time.Duration {
+	if time.Since(b.start) > b.max {
+		return backoff.Stop
+	}
+	return b.BackOff.NextBackOff()
+}
+
+func (b *maxElapsedBackOff) Reset() {
+	b.start = time.Now()
+	b.BackOff.Reset()
+}
+
+//Longest Retry-After honoured, so a misbehaving server can't park a worker for hours
+const maxRetryAfter = 2 * time.Minute
+
+//retryAfterBackOff waits as long as the server asked with a Retry-After header on the last failed attempt,
+//instead of the policy's own delay. The policy still decides when to give up, and is used when there is no header.
+type retryAfterBackOff struct {
+	backoff.BackOff
+	wait time.Duration
+}
+
+func (b *retryAfterBackOff) NextBackOff() time.Duration {
+	next := b.BackOff.NextBackOff()
+	wait := b.wait
+	b.wait = 0
+	if next == backoff.Stop || wait <= 0 {
+		return next
+	}
+	return wait
+}
+
+//capture is a request option that remembers a failed attempt's Retry-After for the next NextBackOff
+func (b *retryAfterBackOff) capture() request.Option {
+	return func(r *request.Request) {
+		r.Handlers.Complete.PushBack(func(r *request.Request) {
+			if r.Error != nil && r.HTTPResponse != nil {
+				b.wait = parseRetryAfter(r.HTTPResponse.Header.Get("Retry-After"))
+			}
+		})
+	}
+}
+
+//parseRetryAfter reads a Retry-After value, either seconds or an HTTP date, as a delay capped at maxRetryAfter.
+//It returns 0 when the header is missing or can't be read.
+func parseRetryAfter(value string) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	var wait time.Duration
+	if seconds, err := strconv.Atoi(value); err == nil {
+		wait = time.Duration(seconds) * time.Second
+	} else if at, err := http.ParseTime(value); err == nil {
+		wait = time.Until(at)
+	}
+	if wait > maxRetryAfter {
+		wait = maxRetryAfter
+	}
+	return wait
+}
+
+//attemptContext bounds a single request attempt by -per-object-timeout, so a hung request is cancelled
+//and retried instead of holding its worker forever
+func attemptContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if *objectTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, *objectTimeout)
+}
+
+//attemptTimedOut reports whether an attempt failed because of its own -per-object-timeout rather than the bucket's context.
+//One that succeeded just as the timeout fired didn't fail, and counts as the success it is.
+func attemptTimedOut(ctx context.Context, attemptCtx context.Context, err error) bool {
+	return err != nil && *objectTimeout > 0 && ctx.Err() == nil && attemptCtx.Err() == context.DeadlineExceeded
+}
@@ -0,0 +1,30 @@
+package deleter
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestAttemptTimedOut(t *testing.T) {
+	setFlags(t, map[string]string{"per-object-timeout": "1ns"})
+	timeout := *objectTimeout
+
+	ctx := context.Background()
+	attemptCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	<-attemptCtx.Done()
+
+	if !attemptTimedOut(ctx, attemptCtx, context.DeadlineExceeded) {
+		t.Error("a failed attempt past its -per-object-timeout should count as hung")
+	}
+	//The answer came in just as the timeout fired: it is a success, not a retry
+	if attemptTimedOut(ctx, attemptCtx, nil) {
+		t.Error("an attempt that succeeded should not count as hung")
+	}
+	bucketCtx, cancelBucket := context.WithCancel(ctx)
+	cancelBucket()
+	if attemptTimedOut(bucketCtx, attemptCtx, errors.New("cancelled")) {
+		t.Error("an attempt cut short by the bucket's context should not count as hung")
+	}
+}
@@ -0,0 +1,130 @@
+package deleter
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+//What each -role-chain entry has to look like
+var roleARN = regexp.MustCompile(`^arn:aws[a-z-]*:iam::[0-9]{12}:role/.+$`)
+
+//Credentials of the last role in -role-chain, which newSession uses when set
+var roleCredentials *credentials.Credentials
+
+//parseRoleChain splits -role-chain into its role ARNs, checking each looks like one
+func parseRoleChain(value string) ([]string, error) {
+	var arns []string
+	for _, arn := range strings.Split(value, ",") {
+		arn = strings.TrimSpace(arn)
+		if !roleARN.MatchString(arn) {
+			return nil, fmt.Errorf("%q is not an IAM role ARN", arn)
+		}
+		arns = append(arns, arn)
+	}
+	return arns, nil
+}
+
+//assumeRoleChain assumes each role in turn with the credentials of the one before, starting from the usual
+//profile or environment credentials. Every hop is assumed straight away, so a role that can't be assumed is
+//reported as the hop it is rather than as AccessDenied on the first S3 call. The credentials returned renew
+//themselves, hop by hop, when they expire.
+func assumeRoleChain(arns []string) (*credentials.Credentials, error) {
+	sess, err := newSession("us-east-1")
+	if err != nil {
+		return nil, err
+	}
+	var creds *credentials.Credentials
+	for i, arn := range arns {
+		creds = stscreds.NewCredentials(sess, arn, func(p *stscreds.AssumeRoleProvider) {
+			p.RoleSessionName = "deleteS3bucket"
+		})
+		if _, err := creds.Get(); err != nil {
+			return nil, fmt.Errorf("hop %d of %d, assuming %s: %w", i+1, len(arns), arn, err)
+		}
+		if *verbosity {
+			InfoLogger.Printf("Assumed %s (hop %d of %d)\n", arn, i+1, len(arns))
+		}
+		sess = sess.Copy(&aws.Config{Credentials: creds})
+	}
+	return creds, nil
+}
+
+//How long after a credential refresh further ExpiredToken errors are put down to requests signed before it
+const credentialRefreshWindow = 30 * time.Second
+
+//Credentials of every session made, which refreshExpiredCredentials renews
+var (
+	sessionCredentialsMu sync.Mutex
+	sessionCredentials   = map[*credentials.Credentials]bool{}
+	credentialsRefreshed time.Time
+)
+
+//trackCredentials remembers a session's credentials for refreshExpiredCredentials
+func trackCredentials(creds *credentials.Credentials) {
+	if creds == nil {
+		return
+	}
+	sessionCredentialsMu.Lock()
+	sessionCredentials[creds] = true
+	sessionCredentialsMu.Unlock()
+}
+
+//isExpiredToken reports whether a request was refused because its temporary credentials ran out
+func isExpiredToken(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	if !ok {
+		return false
+	}
+	switch aerr.Code() {
+	case "ExpiredToken", "ExpiredTokenException", "TokenRefreshRequired":
+		return true
+	}
+	return false
+}
+
+//refreshExpiredCredentials is called with a delete's error. For ExpiredToken it expires the credentials of every
+//session so they are fetched again, re-assuming -role-chain or re-reading the profile, and reports whether the
+//request should be retried with them. Requests that fail the same way just after a refresh that renewed them
+//retry without another. If the refresh brings back the same keys, as it does for static credentials, the error
+//stays fatal.
+func refreshExpiredCredentials(err error) bool {
+	if !isExpiredToken(err) {
+		return false
+	}
+	sessionCredentialsMu.Lock()
+	defer sessionCredentialsMu.Unlock()
+	if time.Since(credentialsRefreshed) < credentialRefreshWindow {
+		return true
+	}
+	renewed := false
+	for creds := range sessionCredentials {
+		//Get would fetch new credentials for ones past their expiry time itself, hiding the old ones
+		expired := creds.IsExpired()
+		var old credentials.Value
+		if !expired {
+			old, _ = creds.Get()
+		}
+		creds.Expire()
+		value, err := creds.Get()
+		if err != nil {
+			WarningLogger.Printf("Unable to refresh expired credentials: %v\n", err)
+			continue
+		}
+		if expired || value.AccessKeyID != old.AccessKeyID || value.SecretAccessKey != old.SecretAccessKey || value.SessionToken != old.SessionToken {
+			renewed = true
+		}
+	}
+	if !renewed {
+		return false
+	}
+	credentialsRefreshed = time.Now()
+	InfoLogger.Print("Credentials expired, refreshed them and retrying")
+	return true
+}
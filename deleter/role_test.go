@@ -0,0 +1,129 @@
+package deleter
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"net/http"
+	"testing"
+	"time"
+)
+
+//rotatingProvider hands out new keys on every Retrieve, like AssumeRole, or with sticky only once the old ones
+//are past their expiry time, like a cached SSO or container role
+type rotatingProvider struct {
+	generation int
+	expired    bool
+	sticky     bool
+}
+
+func (p *rotatingProvider) Retrieve() (credentials.Value, error) {
+	if !p.sticky || p.expired || p.generation == 0 {
+		p.generation++
+	}
+	p.expired = false
+	return credentials.Value{AccessKeyID: fmt.Sprintf("AKID%d", p.generation), SecretAccessKey: "secret", SessionToken: fmt.Sprintf("token%d", p.generation)}, nil
+}
+
+func (p *rotatingProvider) IsExpired() bool {
+	return p.generation == 0 || p.expired
+}
+
+var errExpiredToken = awserr.NewRequestFailure(awserr.New("ExpiredToken", "The provided token has expired.", nil), http.StatusBadRequest, "")
+
+//useCredentials makes creds the only tracked credentials, with no refresh made yet
+func useCredentials(t *testing.T, creds *credentials.Credentials) {
+	sessionCredentialsMu.Lock()
+	saved := sessionCredentials
+	sessionCredentials = map[*credentials.Credentials]bool{creds: true}
+	credentialsRefreshed = time.Time{}
+	sessionCredentialsMu.Unlock()
+	t.Cleanup(func() {
+		sessionCredentialsMu.Lock()
+		sessionCredentials = saved
+		credentialsRefreshed = time.Time{}
+		sessionCredentialsMu.Unlock()
+	})
+}
+
+func TestRefreshExpiredCredentials(t *testing.T) {
+	t.Run("static keys stay fatal", func(t *testing.T) {
+		creds := credentials.NewStaticCredentials("AKID", "secret", "token")
+		creds.Get()
+		useCredentials(t, creds)
+		if refreshExpiredCredentials(errExpiredToken) {
+			t.Error("retrying with the same static keys")
+		}
+	})
+
+	t.Run("renewed keys", func(t *testing.T) {
+		p := &rotatingProvider{}
+		creds := credentials.NewCredentials(p)
+		creds.Get()
+		useCredentials(t, creds)
+		if !refreshExpiredCredentials(errExpiredToken) {
+			t.Error("not retrying with renewed keys")
+		}
+	})
+
+	t.Run("sticky keys past their expiry time", func(t *testing.T) {
+		p := &rotatingProvider{sticky: true}
+		creds := credentials.NewCredentials(p)
+		creds.Get()
+		p.expired = true
+		useCredentials(t, creds)
+		if !refreshExpiredCredentials(errExpiredToken) {
+			t.Error("not retrying once Get had already renewed expired keys")
+		}
+	})
+
+	t.Run("sticky keys not expired", func(t *testing.T) {
+		creds := credentials.NewCredentials(&rotatingProvider{sticky: true})
+		creds.Get()
+		useCredentials(t, creds)
+		if refreshExpiredCredentials(errExpiredToken) {
+			t.Error("retrying with the keys the provider handed out before")
+		}
+	})
+
+	t.Run("other errors", func(t *testing.T) {
+		useCredentials(t, credentials.NewCredentials(&rotatingProvider{}))
+		if refreshExpiredCredentials(awserr.New("AccessDenied", "Access Denied", nil)) {
+			t.Error("refreshing credentials for AccessDenied")
+		}
+	})
+}
+
+//expiringListS3 is the fake bucket, refusing the first versions listing with ExpiredToken
+type expiringListS3 struct {
+	*fakeS3
+	expired bool
+}
+
+func (f *expiringListS3) ListObjectVersionsPagesWithContext(ctx aws.Context, input *s3.ListObjectVersionsInput, fn func(*s3.ListObjectVersionsOutput, bool) bool, opts ...request.Option) error {
+	if f.expired {
+		f.expired = false
+		return errExpiredToken
+	}
+	return f.fakeS3.ListObjectVersionsPagesWithContext(ctx, input, fn, opts...)
+}
+
+func TestListingRefreshesExpiredCredentials(t *testing.T) {
+	for _, mode := range []string{"retry", "skip-page", "abort"} {
+		t.Run(mode, func(t *testing.T) {
+			setFlags(t, map[string]string{"listing-error": mode})
+			useCredentials(t, credentials.NewCredentials(&rotatingProvider{}))
+			f := &expiringListS3{fakeS3: newFakeS3("demo", 5), expired: true}
+			j := newTestJob(t, f)
+			if err := j.deleteAllVersions(); err != nil {
+				t.Fatal(err)
+			}
+			if left := len(f.sortedKeys("")); left != 0 || j.listingSkipped != 0 {
+				t.Errorf("%d keys left and %d listings skipped, want the listing carried on with refreshed credentials", left, j.listingSkipped)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package deleter
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"os"
+	"strings"
+	"sync"
+)
+
+//Serializes prompts so buckets processed concurrently don't ask at the same time
+var promptMu sync.Mutex
+
+//sampleAndConfirm prints the first -sample-keys versions and delete markers in the bucket and,
+//unless the run is already confirmed with -force or DELETE_S3_CONFIRM, asks whether to go on.
+//It returns false if the bucket should be left alone.
+func (j *bucketJob) sampleAndConfirm() bool {
+	out, err := j.svc.ListObjectVersionsWithContext(j.ctx, &s3.ListObjectVersionsInput{
+		Bucket:  aws.String(j.name),
+		MaxKeys: aws.Int64(*sampleKeys),
+	})
+	if err != nil {
+		exitErrorf("Unable to sample %s: %v", j.name, err)
+	}
+	entries := append(versionEntries(out.Versions), markerEntries(out.DeleteMarkers)...)
+
+	promptMu.Lock()
+	defer promptMu.Unlock()
+	InfoLogger.Printf("First %d entries in %s:\n", len(entries), j.name)
+	for _, entry := range entries {
+		fmt.Printf("  %-7s %s %s %d\n", entry.Type, aws.StringValue(entry.Key), aws.StringValue(entry.VersionId), entry.Size)
+	}
+	if aws.BoolValue(out.IsTruncated) {
+		fmt.Printf("  ...\n")
+	}
+	if _, confirmed := os.LookupEnv(confirmEnv); *force || confirmed {
+		return true
+	}
+
+	fmt.Printf("Proceed with %s? [y/N]: ", j.name)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	InfoLogger.Printf("Leaving %s alone\n", j.name)
+	return false
+}
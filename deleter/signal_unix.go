@@ -0,0 +1,20 @@
+// +build !windows
+
+package deleter
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+//watchConcurrencySignals lets an operator retune a running teardown: SIGUSR1 adds workers, SIGUSR2 removes them
+func watchConcurrencySignals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		for sig := range signals {
+			nudgeConcurrency(sig == syscall.SIGUSR1)
+		}
+	}()
+}
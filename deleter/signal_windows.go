@@ -0,0 +1,6 @@
+// +build windows
+
+package deleter
+
+//Windows has no SIGUSR1/SIGUSR2, concurrency can only be changed by -adaptive there
+func watchConcurrencySignals() {}
@@ -0,0 +1,35 @@
+package deleter
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"time"
+)
+
+//startStatusServer serves the running Stats as JSON at /status, and a plain health check at /healthz, on addr
+//for as long as the run lasts. As with -summary-every, buckets only appear in /status once they are finished.
+//Calling the returned func shuts the server down.
+func startStatusServer(addr string, start time.Time) (stop func(), err error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(summaryStats(start))
+	})
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok\n"))
+	})
+	server := &http.Server{Handler: mux}
+	go server.Serve(listener)
+	InfoLogger.Printf("Serving status on http://%s/status\n", listener.Addr())
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	}, nil
+}
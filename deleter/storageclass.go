@@ -0,0 +1,76 @@
+package deleter
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"sort"
+	"sync"
+)
+
+var (
+	storageClassesMu sync.Mutex
+	storageClasses   = map[string]*DeleteTotals{}
+)
+
+//recordBreakdowns adds a successful delete to the -report-per-prefix and -report-by-class breakdowns, when set
+func recordBreakdowns(entry s3Entry) {
+	if *reportPerPrefix {
+		recordTopPrefix(aws.StringValue(entry.Key), entry.Size)
+	}
+	if *reportByClass {
+		recordStorageClass(entry)
+	}
+}
+
+//recordStorageClass tallies a deleted version or object by its storage class from the listing.
+//Delete markers have no class and aren't counted, and listings leave STANDARD out on some stores.
+func recordStorageClass(entry s3Entry) {
+	if entry.Type == "Marker" {
+		return
+	}
+	class := aws.StringValue(entry.StorageClass)
+	if class == "" {
+		class = s3.StorageClassStandard
+	}
+	storageClassesMu.Lock()
+	defer storageClassesMu.Unlock()
+	totals, ok := storageClasses[class]
+	if !ok {
+		totals = &DeleteTotals{}
+		storageClasses[class] = totals
+	}
+	totals.Deleted++
+	totals.Bytes += entry.Size
+}
+
+//reportStorageClasses logs the -report-by-class breakdown, the classes that held the most data first
+func reportStorageClasses() {
+	storageClassesMu.Lock()
+	defer storageClassesMu.Unlock()
+	classes := make([]string, 0, len(storageClasses))
+	for class := range storageClasses {
+		classes = append(classes, class)
+	}
+	sort.Slice(classes, func(a, b int) bool {
+		if storageClasses[classes[a]].Bytes != storageClasses[classes[b]].Bytes {
+			return storageClasses[classes[a]].Bytes > storageClasses[classes[b]].Bytes
+		}
+		return classes[a] < classes[b]
+	})
+	InfoLogger.Printf("Deleted per storage class:\n")
+	for _, class := range classes {
+		totals := storageClasses[class]
+		InfoLogger.Printf("  %s: %d entries, %s\n", class, totals.Deleted, formatBytes(float64(totals.Bytes)))
+	}
+}
+
+//storageClassStats copies the breakdown for the JSON summary
+func storageClassStats() map[string]DeleteTotals {
+	storageClassesMu.Lock()
+	defer storageClassesMu.Unlock()
+	stats := make(map[string]DeleteTotals, len(storageClasses))
+	for class, totals := range storageClasses {
+		stats[class] = *totals
+	}
+	return stats
+}
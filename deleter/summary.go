@@ -0,0 +1,406 @@
+package deleter
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//Retries performed across the run, updated atomically
+var retryCount int64
+
+//bucketFailure is a bucket the run gave up on without aborting everything else
+type bucketFailure struct {
+	name string
+	err  error
+}
+
+var (
+	failedBucketsMu sync.Mutex
+	failedBuckets   []bucketFailure
+)
+
+func recordBucketFailure(name string, err error) {
+	failedBucketsMu.Lock()
+	failedBuckets = append(failedBuckets, bucketFailure{name: name, err: err})
+	failedBucketsMu.Unlock()
+}
+
+//Entries deleted under each of -prefix/-prefix-file across the run, see recordPrefixDeleted
+var (
+	prefixDeletedMu sync.Mutex
+	prefixDeleted   = map[string]int64{}
+)
+
+func recordPrefixDeleted(prefix string, n int64) {
+	prefixDeletedMu.Lock()
+	prefixDeleted[prefix] += n
+	prefixDeletedMu.Unlock()
+}
+
+//errorTally counts one error code seen by delete requests, with what it most likely means
+type errorTally struct {
+	code  string
+	hint  string
+	count int64
+}
+
+var (
+	errorCodesMu sync.Mutex
+	errorCodes   = map[string]*errorTally{}
+)
+
+//Error code counted for a delete attempt cut off by -per-object-timeout
+const attemptTimeoutCode = "AttemptTimeout"
+
+const (
+	hintThrottling  = "throttling, lower -concurrency or -rate"
+	hintPermissions = "permissions or credentials, check IAM"
+	hintNetwork     = "network, check connectivity"
+)
+
+//recordError counts a failed delete attempt, retried or not, by its AWS error code for the summary
+func recordError(err error) {
+	tallyError(classifyError(err))
+}
+
+//classifyError is the AWS error code of a failed delete attempt, "other" when there is none, and the hint the
+//summary gives for it
+func classifyError(err error) (code string, hint string) {
+	code = "other"
+	if aerr, ok := err.(awserr.Error); ok {
+		code = aerr.Code()
+	}
+	switch {
+	case isThrottle(err):
+		hint = hintThrottling
+	case isFatal(err) || statusCode(err) == http.StatusForbidden || code == "AccessDenied":
+		hint = hintPermissions
+	case code == request.ErrCodeRequestError || code == request.ErrCodeResponseTimeout || code == "RequestTimeout":
+		hint = hintNetwork
+	}
+	return code, hint
+}
+
+//attemptFields describes a failed delete attempt for the per-attempt log lines as key=value pairs: the error
+//code and hint as the summary counts them, the HTTP status and the message
+func attemptFields(err error) string {
+	code, hint := classifyError(err)
+	message := err.Error()
+	if aerr, ok := err.(awserr.Error); ok {
+		message = aerr.Message()
+	}
+	fields := fmt.Sprintf("code=%s", code)
+	if status := statusCode(err); status != 0 {
+		fields += fmt.Sprintf(" status=%d", status)
+	}
+	if hint != "" {
+		fields += fmt.Sprintf(" hint=%q", hint)
+	}
+	return fields + fmt.Sprintf(" message=%q", message)
+}
+
+func tallyError(code string, hint string) {
+	errorCodesMu.Lock()
+	defer errorCodesMu.Unlock()
+	t := errorCodes[code]
+	if t == nil {
+		t = &errorTally{code: code, hint: hint}
+		errorCodes[code] = t
+	}
+	t.count++
+}
+
+//reportErrorCodes logs the error codes seen across the run, most frequent first
+func reportErrorCodes() {
+	errorCodesMu.Lock()
+	defer errorCodesMu.Unlock()
+	if len(errorCodes) == 0 {
+		return
+	}
+	tallies := make([]*errorTally, 0, len(errorCodes))
+	for _, t := range errorCodes {
+		tallies = append(tallies, t)
+	}
+	sort.Slice(tallies, func(a, b int) bool {
+		if tallies[a].count != tallies[b].count {
+			return tallies[a].count > tallies[b].count
+		}
+		return tallies[a].code < tallies[b].code
+	})
+	InfoLogger.Print("Errors by code:\n")
+	for _, t := range tallies {
+		if t.hint != "" {
+			InfoLogger.Printf("  %s: %d (%s)\n", t.code, t.count, t.hint)
+		} else {
+			InfoLogger.Printf("  %s: %d\n", t.code, t.count)
+		}
+	}
+}
+
+//countRetry is the backoff notify hook, called once before every retry
+func countRetry(error, time.Duration) {
+	atomic.AddInt64(&retryCount, 1)
+}
+
+//printSummary logs the run-wide totals once every bucket is done
+func printSummary(start time.Time) {
+	deleted := atomic.LoadInt64(&deletedCount)
+	retries := atomic.LoadInt64(&retryCount)
+	InfoLogger.Printf("Deleted %d objects in %s with %d retries\n", deleted, time.Since(start).Round(time.Second), retries)
+	if folders := atomic.LoadInt64(&folderMarkers); folders > 0 {
+		InfoLogger.Printf("%d of them were folder markers\n", folders)
+	}
+	for _, prefix := range keyPrefixes {
+		InfoLogger.Printf("  %d under %q\n", prefixDeleted[prefix], prefix)
+	}
+	if *reportPerPrefix {
+		reportTopPrefixes()
+	}
+	if *reportByClass {
+		reportStorageClasses()
+	}
+	if *reportSkipped {
+		reportFilterSkips()
+	}
+	if *verifyDeletes {
+		reportVerifiedDeletes()
+	}
+	reportErrorCodes()
+	reportPanics()
+	if *histogram {
+		reportHistograms()
+	}
+	if *verbosity {
+		reportGoroutines()
+	}
+	if retryBudgetSpent() {
+		WarningLogger.Printf("-total-retry-budget ran out, so later deletes weren't retried: S3 is throttling the account as a whole, not a few keys\n")
+	} else if retries > 0 && retries*10 > deleted {
+		WarningLogger.Printf("High retry count, S3 was likely throttling: try a lower -concurrency or -rate\n")
+	}
+	reportSkippedBuckets()
+	for _, failure := range failedBuckets {
+		ErrorLogger.Printf("Bucket %s failed: %v\n", failure.name, failure.err)
+	}
+}
+
+//reportSkippedBuckets lists the buckets left untouched on purpose, such as those without -require-bucket-tag
+func reportSkippedBuckets() {
+	bucketResultsMu.Lock()
+	defer bucketResultsMu.Unlock()
+	for _, result := range bucketResults {
+		if result.Skipped != "" {
+			WarningLogger.Printf("Skipped bucket %s: %s\n", result.Name, result.Skipped)
+		}
+	}
+}
+
+//runExitCode is the exit status for the run: 0 when every bucket succeeded, otherwise that of the first failure
+func runExitCode() int {
+	failedBucketsMu.Lock()
+	defer failedBucketsMu.Unlock()
+	if len(failedBuckets) == 0 {
+		return 0
+	}
+	return exitCode(failedBuckets[0].err)
+}
+
+//Stats is the run summary written by -summary-json-out
+type Stats struct {
+	Success        bool                    `json:"success"`
+	Deleted        int64                   `json:"deleted"`
+	Retries        int64                   `json:"retries"`
+	RetryBudget    bool                    `json:"retry_budget_spent,omitempty"`
+	FreedBytes     int64                   `json:"freed_bytes"`
+	FolderMarkers  int64                   `json:"folder_markers"`
+	Prefixes       map[string]int64        `json:"prefixes,omitempty"`
+	TopPrefixes    map[string]DeleteTotals `json:"top_prefixes,omitempty"`
+	StorageClasses map[string]DeleteTotals `json:"storage_classes,omitempty"`
+	FilterSkips    map[string]int64        `json:"skipped_by_filter,omitempty"`
+	ErrorCodes     map[string]int64        `json:"error_codes,omitempty"`
+	Panics         int64                   `json:"panics,omitempty"`
+	ElapsedSeconds float64                 `json:"elapsed_seconds"`
+	Error          string                  `json:"error,omitempty"`
+	Buckets        []BucketStats           `json:"buckets"`
+}
+
+//BucketStats is one bucket's part of Stats
+type BucketStats struct {
+	Name            string   `json:"name"`
+	Region          string   `json:"region"`
+	Deleted         int64    `json:"deleted"`
+	Objects         int64    `json:"objects_deleted"`
+	Versions        int64    `json:"versions_deleted"`
+	Markers         int64    `json:"markers_deleted"`
+	FreedBytes      int64    `json:"freed_bytes"`
+	DurationSeconds float64  `json:"duration_seconds"`
+	FailedKeys      int      `json:"failed_keys"`
+	BucketDeleted   bool     `json:"bucket_deleted"`
+	Skipped         string   `json:"skipped,omitempty"`
+	Errors          []string `json:"errors,omitempty"`
+}
+
+var (
+	bucketResultsMu sync.Mutex
+	bucketResults   []BucketStats
+)
+
+//recordBucketResult keeps a finished bucket's numbers for the JSON summary
+func recordBucketResult(j *bucketJob) {
+	j.failedMu.Lock()
+	failed := len(j.failed)
+	j.failedMu.Unlock()
+	bucketResultsMu.Lock()
+	bucketResults = append(bucketResults, BucketStats{
+		Name:            j.name,
+		Region:          j.region,
+		Deleted:         atomic.LoadInt64(&j.stats.deleted),
+		Objects:         atomic.LoadInt64(&j.stats.objectsDeleted),
+		Versions:        atomic.LoadInt64(&j.stats.versionsDeleted),
+		Markers:         atomic.LoadInt64(&j.stats.markersDeleted),
+		FreedBytes:      atomic.LoadInt64(&j.stats.bytesDeleted),
+		DurationSeconds: time.Since(j.started).Seconds(),
+		FailedKeys:      failed,
+		BucketDeleted:   j.stats.bucketDeleted,
+		Skipped:         j.stats.skipped,
+	})
+	bucketResultsMu.Unlock()
+}
+
+//summaryStats gathers the run totals and per-bucket results
+func summaryStats(start time.Time) Stats {
+	stats := Stats{
+		Deleted:        atomic.LoadInt64(&deletedCount),
+		Retries:        atomic.LoadInt64(&retryCount),
+		FreedBytes:     atomic.LoadInt64(&freedBytes),
+		FolderMarkers:  atomic.LoadInt64(&folderMarkers),
+		RetryBudget:    retryBudgetSpent(),
+		ElapsedSeconds: time.Since(start).Seconds(),
+		Buckets:        []BucketStats{},
+	}
+	if err := runStopped(); err != nil {
+		stats.Error = err.Error()
+	}
+	errorCodesMu.Lock()
+	if len(errorCodes) > 0 {
+		stats.ErrorCodes = make(map[string]int64, len(errorCodes))
+		for code, t := range errorCodes {
+			stats.ErrorCodes[code] = t.count
+		}
+	}
+	errorCodesMu.Unlock()
+	if len(keyPrefixes) > 0 {
+		prefixDeletedMu.Lock()
+		stats.Prefixes = make(map[string]int64, len(prefixDeleted))
+		for prefix, n := range prefixDeleted {
+			stats.Prefixes[prefix] = n
+		}
+		prefixDeletedMu.Unlock()
+	}
+	if *reportPerPrefix {
+		stats.TopPrefixes = topPrefixStats()
+	}
+	if *reportByClass {
+		stats.StorageClasses = storageClassStats()
+	}
+	stats.FilterSkips = filterSkipStats()
+	stats.Panics = atomic.LoadInt64(&panicCount)
+
+	failedBucketsMu.Lock()
+	errs := map[string][]string{}
+	for _, failure := range failedBuckets {
+		errs[failure.name] = append(errs[failure.name], failure.err.Error())
+	}
+	failedBucketsMu.Unlock()
+
+	bucketResultsMu.Lock()
+	for _, result := range bucketResults {
+		result.Errors = errs[result.Name]
+		delete(errs, result.Name)
+		stats.Buckets = append(stats.Buckets, result)
+	}
+	bucketResultsMu.Unlock()
+	//Buckets that failed before they got as far as a job, e.g. their region couldn't be found
+	for name, bucketErrs := range errs {
+		stats.Buckets = append(stats.Buckets, BucketStats{Name: name, Errors: bucketErrs})
+	}
+
+	stats.Success = stats.Error == ""
+	for _, bucket := range stats.Buckets {
+		if len(bucket.Errors) > 0 {
+			stats.Success = false
+		}
+	}
+	return stats
+}
+
+//writeSummaryJSON writes the run summary to path for CI to check
+func writeSummaryJSON(path string, start time.Time) error {
+	data, err := json.MarshalIndent(summaryStats(start), "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
+
+//startPeriodicSummary logs the running Stats as one line of JSON every -summary-every, so a long run's logs
+//show where it got to. Buckets only appear in it once they are finished. Calling the returned func stops it.
+func startPeriodicSummary(every time.Duration, start time.Time) (stop func()) {
+	stopping, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(every)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopping:
+				return
+			case <-ticker.C:
+			}
+			stats := summaryStats(start)
+			data, err := json.Marshal(stats)
+			if err != nil {
+				continue
+			}
+			InfoLogger.Printf("Summary so far (%.0f obj/s, %s freed): %s\n",
+				float64(stats.Deleted)/stats.ElapsedSeconds, formatBytes(float64(stats.FreedBytes)), data)
+		}
+	}()
+	return func() {
+		close(stopping)
+		<-stopped
+	}
+}
+
+//printResultLine is all -q prints at the end: one line on stderr saying whether the run worked
+func printResultLine(start time.Time) {
+	stats := summaryStats(start)
+	if stats.Success {
+		fmt.Fprintf(os.Stderr, "OK: deleted %d objects\n", stats.Deleted)
+		return
+	}
+	var failedKeys, failedBuckets int
+	for _, bucket := range stats.Buckets {
+		failedKeys += bucket.FailedKeys
+		if len(bucket.Errors) > 0 {
+			failedBuckets++
+		}
+	}
+	detail := "see the errors above"
+	if *summaryJSON != "" {
+		detail = "see " + *summaryJSON
+	}
+	if stats.Error != "" {
+		detail = stats.Error + ", " + detail
+	}
+	fmt.Fprintf(os.Stderr, "FAILED: %d buckets, %d objects not deleted, %d deleted, %s\n", failedBuckets, failedKeys, stats.Deleted, detail)
+}
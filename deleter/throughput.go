@@ -0,0 +1,106 @@
+package deleter
+
+import (
+	"encoding/csv"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+//How often -throughput-report samples the successful delete counter
+const throughputInterval = 5 * time.Second
+
+//throughputSampler records the deletion rate of each interval while the emptying passes run
+type throughputSampler struct {
+	start    time.Time
+	last     int64
+	lastAt   time.Time
+	samples  []float64
+	elapsed  []time.Duration
+	stopping chan struct{}
+	stopped  chan struct{}
+}
+
+func startThroughputSampler() *throughputSampler {
+	now := time.Now()
+	t := &throughputSampler{
+		start:    now,
+		lastAt:   now,
+		last:     atomic.LoadInt64(&deletedCount),
+		stopping: make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+func (t *throughputSampler) run() {
+	defer close(t.stopped)
+	ticker := time.NewTicker(throughputInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			t.sample(now)
+		case <-t.stopping:
+			t.sample(time.Now())
+			return
+		}
+	}
+}
+
+func (t *throughputSampler) sample(now time.Time) {
+	count := atomic.LoadInt64(&deletedCount)
+	seconds := now.Sub(t.lastAt).Seconds()
+	if seconds <= 0 {
+		return
+	}
+	t.samples = append(t.samples, float64(count-t.last)/seconds)
+	t.elapsed = append(t.elapsed, now.Sub(t.start))
+	t.last, t.lastAt = count, now
+}
+
+//stop takes a final sample for the partial interval and waits for the sampler to exit
+func (t *throughputSampler) stop() {
+	close(t.stopping)
+	<-t.stopped
+}
+
+func (t *throughputSampler) report() {
+	if len(t.samples) == 0 {
+		return
+	}
+	min, max, sum := t.samples[0], t.samples[0], 0.0
+	for _, rate := range t.samples {
+		if rate < min {
+			min = rate
+		}
+		if rate > max {
+			max = rate
+		}
+		sum += rate
+	}
+	InfoLogger.Printf("Throughput over %d samples: min %.1f obj/s, avg %.1f obj/s, max %.1f obj/s\n",
+		len(t.samples), min, sum/float64(len(t.samples)), max)
+}
+
+//writeCSV writes the time series as elapsed seconds and objects per second
+func (t *throughputSampler) writeCSV(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := csv.NewWriter(file)
+	w.Write([]string{"elapsed_seconds", "objects_per_second"})
+	for i, rate := range t.samples {
+		w.Write([]string{
+			strconv.FormatFloat(t.elapsed[i].Seconds(), 'f', 1, 64),
+			strconv.FormatFloat(rate, 'f', 1, 64),
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
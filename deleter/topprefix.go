@@ -0,0 +1,91 @@
+package deleter
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+//Most top-level prefixes -report-per-prefix tracks, further ones are counted under topPrefixOther
+const maxTopPrefixes = 1000
+
+const (
+	//Keys without a / sit at the top of the bucket
+	topPrefixRoot = "(top level)"
+	//Keys once the map is full, or whose first segment is empty or unusually long
+	topPrefixOther = "(other)"
+)
+
+//DeleteTotals is what was deleted under one line of a summary breakdown: a top-level prefix or a storage class
+type DeleteTotals struct {
+	Deleted int64 `json:"deleted"`
+	Bytes   int64 `json:"bytes"`
+}
+
+var (
+	topPrefixesMu sync.Mutex
+	topPrefixes   = map[string]*DeleteTotals{}
+)
+
+//topPrefix is the first path segment of key, with its slash, or one of the catch-all names
+func topPrefix(key string) string {
+	i := strings.Index(key, "/")
+	switch {
+	case i < 0:
+		return topPrefixRoot
+	case i == 0 || i > 256:
+		return topPrefixOther
+	}
+	return key[:i+1]
+}
+
+//recordTopPrefix adds a successful delete to its top-level prefix for -report-per-prefix
+func recordTopPrefix(key string, size int64) {
+	prefix := topPrefix(key)
+	topPrefixesMu.Lock()
+	defer topPrefixesMu.Unlock()
+	totals, ok := topPrefixes[prefix]
+	if !ok {
+		if len(topPrefixes) >= maxTopPrefixes {
+			prefix = topPrefixOther
+		}
+		if totals, ok = topPrefixes[prefix]; !ok {
+			totals = &DeleteTotals{}
+			topPrefixes[prefix] = totals
+		}
+	}
+	totals.Deleted++
+	totals.Bytes += size
+}
+
+//reportTopPrefixes logs the -report-per-prefix breakdown, the prefixes that held the most data first
+func reportTopPrefixes() {
+	topPrefixesMu.Lock()
+	defer topPrefixesMu.Unlock()
+	prefixes := make([]string, 0, len(topPrefixes))
+	for prefix := range topPrefixes {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Slice(prefixes, func(a, b int) bool {
+		if topPrefixes[prefixes[a]].Bytes != topPrefixes[prefixes[b]].Bytes {
+			return topPrefixes[prefixes[a]].Bytes > topPrefixes[prefixes[b]].Bytes
+		}
+		return prefixes[a] < prefixes[b]
+	})
+	InfoLogger.Printf("Deleted per top-level prefix:\n")
+	for _, prefix := range prefixes {
+		totals := topPrefixes[prefix]
+		InfoLogger.Printf("  %s: %d entries, %s\n", prefix, totals.Deleted, formatBytes(float64(totals.Bytes)))
+	}
+}
+
+//topPrefixStats copies the breakdown for the JSON summary
+func topPrefixStats() map[string]DeleteTotals {
+	topPrefixesMu.Lock()
+	defer topPrefixesMu.Unlock()
+	stats := make(map[string]DeleteTotals, len(topPrefixes))
+	for prefix, totals := range topPrefixes {
+		stats[prefix] = *totals
+	}
+	return stats
+}
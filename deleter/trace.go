@@ -0,0 +1,290 @@
+package deleter
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//Spans buffered before they are sent to -otel-endpoint
+const traceBatchSize = 512
+
+//span is one OpenTelemetry span of the run: the run itself, a bucket, or a listing page and its deletes.
+//Every method is a no-op on a nil span, which is what startSpan returns when -otel-endpoint isn't set.
+type span struct {
+	traceID  string
+	id       string
+	parentID string
+	name     string
+	start    time.Time
+
+	mu    sync.Mutex
+	attrs []otlpAttribute
+}
+
+//traceExporter sends finished spans to an OTLP/HTTP collector as JSON.
+//The encoding is done here rather than with the OpenTelemetry SDK, which would be most of the binary.
+type traceExporter struct {
+	url    string
+	client *http.Client
+
+	mu      sync.Mutex
+	pending []otlpSpan
+	warned  bool
+}
+
+//Exporter for -otel-endpoint, nil when tracing is off
+var tracer *traceExporter
+
+//Span covering the whole run, parent of the bucket spans
+var runSpan *span
+
+//newTraceExporter points at endpoint's /v1/traces, the OTLP/HTTP traces path, unless it is already given
+func newTraceExporter(endpoint string) *traceExporter {
+	url := strings.TrimSuffix(endpoint, "/")
+	if !strings.HasSuffix(url, "/v1/traces") {
+		url += "/v1/traces"
+	}
+	return &traceExporter{url: url, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func randomID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+//startSpan starts a span under parent, or a new trace when parent is nil. It returns nil when tracing is off.
+func startSpan(name string, parent *span) *span {
+	if tracer == nil {
+		return nil
+	}
+	s := &span{id: randomID(8), name: name, start: time.Now()}
+	if parent != nil {
+		s.traceID, s.parentID = parent.traceID, parent.id
+	} else {
+		s.traceID = randomID(16)
+	}
+	return s
+}
+
+func (s *span) setString(key string, value string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.attrs = append(s.attrs, otlpAttribute{Key: key, Value: otlpValue{StringValue: &value}})
+	s.mu.Unlock()
+}
+
+func (s *span) setInt(key string, value int64) {
+	if s == nil {
+		return
+	}
+	v := strconv.FormatInt(value, 10)
+	s.mu.Lock()
+	s.attrs = append(s.attrs, otlpAttribute{Key: key, Value: otlpValue{IntValue: &v}})
+	s.mu.Unlock()
+}
+
+//end finishes the span, marking it failed if err isn't nil, and queues it for export
+func (s *span) end(err error) {
+	if s == nil {
+		return
+	}
+	now := time.Now()
+	status := otlpStatus{Code: otlpStatusOK}
+	if err != nil {
+		status = otlpStatus{Code: otlpStatusError, Message: err.Error()}
+	}
+	s.mu.Lock()
+	out := otlpSpan{
+		TraceID:           s.traceID,
+		SpanID:            s.id,
+		ParentSpanID:      s.parentID,
+		Name:              s.name,
+		Kind:              otlpKindInternal,
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(now.UnixNano(), 10),
+		Attributes:        s.attrs,
+		Status:            status,
+	}
+	s.mu.Unlock()
+	tracer.add(out)
+}
+
+func (t *traceExporter) add(s otlpSpan) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pending = append(t.pending, s)
+	if len(t.pending) >= traceBatchSize {
+		t.sendLocked()
+	}
+}
+
+//flush sends whatever spans are still buffered, at the end of the run
+func (t *traceExporter) flush() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sendLocked()
+}
+
+//sendLocked posts the buffered spans. A collector that can't be reached is warned about once, tracing never fails the run.
+func (t *traceExporter) sendLocked() {
+	if len(t.pending) == 0 {
+		return
+	}
+	body, err := json.Marshal(otlpTraces{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpAttribute{{Key: "service.name", Value: otlpValue{StringValue: &traceServiceName}}}},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: traceServiceName},
+			Spans: t.pending,
+		}},
+	}}})
+	t.pending = nil
+	if err == nil {
+		var resp *http.Response
+		if resp, err = t.client.Post(t.url, "application/json", bytes.NewReader(body)); err == nil {
+			resp.Body.Close()
+			if resp.StatusCode >= 300 {
+				err = fmt.Errorf("collector answered %s", resp.Status)
+			}
+		}
+	}
+	if err != nil && !t.warned {
+		t.warned = true
+		WarningLogger.Printf("Unable to export spans to %s: %v\n", t.url, err)
+	}
+}
+
+//bucketFailureErr is the first error recorded for a bucket, for its span's status
+func bucketFailureErr(name string) error {
+	failedBucketsMu.Lock()
+	defer failedBucketsMu.Unlock()
+	for _, failure := range failedBuckets {
+		if failure.name == name {
+			return failure.err
+		}
+	}
+	return nil
+}
+
+//tracePage wraps the deletes of one listing page in a span under the bucket's. The span starts when the page
+//was requested, so it covers the listing call as well, whose share is the s3.list_ms attribute.
+func (j *bucketJob) tracePage(listing string, listStart time.Time, entries int, deletePage func() error) func() error {
+	if tracer == nil {
+		return deletePage
+	}
+	s := startSpan(listing+" page", j.span)
+	s.start = listStart
+	s.setInt("s3.list_ms", time.Since(listStart).Milliseconds())
+	s.setInt("s3.entries", int64(entries))
+	return func() error {
+		err := deletePage()
+		s.end(err)
+		return err
+	}
+}
+
+//endBucketSpan finishes a bucket's span with its counts and the error it failed with, if any
+func (j *bucketJob) endBucketSpan() {
+	if j.span == nil {
+		return
+	}
+	j.failedMu.Lock()
+	failed := len(j.failed)
+	j.failedMu.Unlock()
+	j.span.setInt("s3.deleted", atomic.LoadInt64(&j.stats.deleted))
+	j.span.setInt("s3.failed_keys", int64(failed))
+	if j.stats.bucketDeleted {
+		j.span.setString("s3.bucket_deleted", "true")
+	}
+	j.span.end(bucketFailureErr(j.name))
+}
+
+//endRunSpan finishes the run's span and sends every span still buffered
+func endRunSpan() {
+	if tracer == nil {
+		return
+	}
+	failedBucketsMu.Lock()
+	failures := len(failedBuckets)
+	failedBucketsMu.Unlock()
+	runSpan.setInt("s3.deleted", atomic.LoadInt64(&deletedCount))
+	runSpan.setInt("s3.retries", atomic.LoadInt64(&retryCount))
+	runSpan.setInt("s3.failed_buckets", int64(failures))
+	err := runStopped()
+	if err == nil && failures > 0 {
+		err = fmt.Errorf("%d buckets failed", failures)
+	}
+	runSpan.end(err)
+	tracer.flush()
+}
+
+//The OTLP JSON encoding of the spans, see opentelemetry-proto's trace/v1/trace.proto.
+//64-bit integers are strings and trace and span IDs are hex, as the OTLP/HTTP JSON mapping requires.
+
+var traceServiceName = "deleteS3bucket"
+
+const (
+	otlpKindInternal = 1
+	otlpStatusOK     = 1
+	otlpStatusError  = 2
+)
+
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
@@ -0,0 +1,34 @@
+package deleter
+
+import (
+	"net/http"
+	"sync"
+)
+
+var (
+	httpClientOnce sync.Once
+	httpClient     *http.Client
+)
+
+//sharedHTTPClient is the HTTP client every session uses, built once flags are final. Go's default transport
+//keeps only 2 idle connections per host, so at high -concurrency most requests would open a new connection and
+//throw it away after. This one keeps up to -max-idle-conns per host, by default as many as deletes can be in
+//flight, and caps connections per host at -max-conns-per-host when set.
+func sharedHTTPClient() *http.Client {
+	httpClientOnce.Do(func() {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		idle := *maxIdleConns
+		if idle == 0 {
+			//-adaptive and SIGUSR1 can raise concurrency up to -adaptive-max
+			idle = *concurrency
+			if *adaptiveMax > idle {
+				idle = *adaptiveMax
+			}
+		}
+		transport.MaxIdleConns = 0
+		transport.MaxIdleConnsPerHost = idle
+		transport.MaxConnsPerHost = *maxConnsPerHost
+		httpClient = &http.Client{Transport: transport}
+	})
+	return httpClient
+}
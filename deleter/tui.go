@@ -0,0 +1,160 @@
+package deleter
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//How often the -tui dashboard is redrawn, and how many warning and error lines it keeps
+const (
+	dashboardInterval = time.Second
+	dashboardLines    = 8
+)
+
+//The -tui dashboard while it is up, so exitErrorf can take it down before it logs
+var dashboard *tuiDashboard
+
+//tuiDashboard draws a full-screen view of the run on stderr in the terminal's alternate screen: a progress bar
+//against -expected-objects, the delete and retry rates, deletes by kind and the latest warnings and errors.
+//The log lines it shows are kept off the screen while it is up; the summary is logged as usual once it is gone.
+type tuiDashboard struct {
+	start    time.Time
+	mu       sync.Mutex
+	recent   []string
+	dropped  int
+	lastInfo string
+	outputs  []io.Writer
+	stopping chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+//dashboardWriter takes a logger's lines for the dashboard
+type dashboardWriter struct {
+	d        *tuiDashboard
+	keepLine bool
+}
+
+func (w dashboardWriter) Write(p []byte) (int, error) {
+	line := strings.TrimRight(string(p), "\n")
+	w.d.mu.Lock()
+	if w.keepLine {
+		w.d.recent = append(w.d.recent, line)
+		if len(w.d.recent) > dashboardLines {
+			w.d.recent = w.d.recent[1:]
+			w.d.dropped++
+		}
+	} else {
+		w.d.lastInfo = line
+	}
+	w.d.mu.Unlock()
+	return len(p), nil
+}
+
+//startDashboard puts up the -tui dashboard, or returns nil when stderr isn't a terminal and logging stays as is
+func startDashboard(start time.Time) *tuiDashboard {
+	if !isTerminal(os.Stderr) {
+		WarningLogger.Printf("-tui needs a terminal, logging as usual\n")
+		return nil
+	}
+	d := &tuiDashboard{
+		start:    start,
+		outputs:  []io.Writer{InfoLogger.Writer(), WarningLogger.Writer(), ErrorLogger.Writer()},
+		stopping: make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	InfoLogger.SetOutput(dashboardWriter{d: d})
+	WarningLogger.SetOutput(dashboardWriter{d: d, keepLine: true})
+	ErrorLogger.SetOutput(dashboardWriter{d: d, keepLine: true})
+	fmt.Fprint(os.Stderr, "\033[?1049h\033[?25l")
+	go d.run()
+	return d
+}
+
+func (d *tuiDashboard) run() {
+	defer close(d.stopped)
+	ticker := time.NewTicker(dashboardInterval)
+	defer ticker.Stop()
+	lastAt := time.Now()
+	lastDeleted, lastRetries := atomic.LoadInt64(&deletedCount), atomic.LoadInt64(&retryCount)
+	for {
+		select {
+		case <-d.stopping:
+			return
+		case now := <-ticker.C:
+			deleted, retries := atomic.LoadInt64(&deletedCount), atomic.LoadInt64(&retryCount)
+			seconds := now.Sub(lastAt).Seconds()
+			d.draw(now, deleted, float64(deleted-lastDeleted)/seconds, float64(retries-lastRetries)/seconds)
+			lastAt, lastDeleted, lastRetries = now, deleted, retries
+		}
+	}
+}
+
+//draw redraws the whole screen in one write, so it doesn't flicker
+func (d *tuiDashboard) draw(now time.Time, deleted int64, perSecond float64, retriesPerSecond float64) {
+	var b bytes.Buffer
+	b.WriteString("\033[H\033[2J")
+	fmt.Fprintf(&b, "deleteS3bucket, running %s\n\n", now.Sub(d.start).Round(time.Second))
+	if *expectedObjects > 0 {
+		const width = 40
+		done := float64(deleted) / float64(*expectedObjects)
+		if done > 1 {
+			done = 1
+		}
+		filled := int(done * width)
+		fmt.Fprintf(&b, "[%s%s] %5.1f%%  %d of %d\n", strings.Repeat("#", filled), strings.Repeat(".", width-filled), done*100, deleted, *expectedObjects)
+	} else {
+		fmt.Fprintf(&b, "%d deleted (-expected-objects gives a progress bar)\n", deleted)
+	}
+	fmt.Fprintf(&b, "%.0f obj/s, %.0f retries/s", perSecond, retriesPerSecond)
+	if perSecond > 0 && retriesPerSecond > perSecond/10 {
+		b.WriteString("  throttled? try a lower -concurrency or -rate")
+	}
+	fmt.Fprintf(&b, "\n%d objects, %d versions, %d delete markers, %s freed\n\n",
+		atomic.LoadInt64(&runObjectsDeleted), atomic.LoadInt64(&runVersionsDeleted), atomic.LoadInt64(&runMarkersDeleted),
+		formatBytes(float64(atomic.LoadInt64(&freedBytes))))
+	d.mu.Lock()
+	fmt.Fprintf(&b, "%s\n\nRecent warnings and errors:\n", d.lastInfo)
+	if len(d.recent) == 0 {
+		b.WriteString("  none\n")
+	}
+	for _, line := range d.recent {
+		fmt.Fprintf(&b, "  %s\n", line)
+	}
+	d.mu.Unlock()
+	os.Stderr.Write(b.Bytes())
+}
+
+//stop takes the dashboard down and logs the warnings and errors it was showing, so they stay in the scrollback.
+//It can be called more than once.
+func (d *tuiDashboard) stop() {
+	d.stopOnce.Do(func() {
+		close(d.stopping)
+		<-d.stopped
+		fmt.Fprint(os.Stderr, "\033[?25h\033[?1049l")
+		InfoLogger.SetOutput(d.outputs[0])
+		WarningLogger.SetOutput(d.outputs[1])
+		ErrorLogger.SetOutput(d.outputs[2])
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if len(d.recent) == 0 {
+			return
+		}
+		out := log.New(os.Stderr, "", 0)
+		if d.dropped > 0 {
+			out.Printf("Last %d warnings and errors of the run (%d earlier ones not shown):\n", len(d.recent), d.dropped)
+		} else {
+			out.Printf("Warnings and errors of the run:\n")
+		}
+		for _, line := range d.recent {
+			out.Println(line)
+		}
+	})
+}
@@ -0,0 +1,145 @@
+package deleter
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"time"
+)
+
+//abortUploads aborts the bucket's incomplete multipart uploads, whose parts are stored (and billed) but never
+//show up in a listing of versions. With -abort-uploads-older-than only uploads initiated before that long ago
+//are aborted, so uploads in progress right now are left to finish. It returns false if the bucket timed out.
+//
+//-abort-timeout bounds the whole phase. When it runs out the uploads left are reported and the bucket is
+//emptied anyway, or the run is stopped with -on-error=abort.
+func (j *bucketJob) abortUploads() bool {
+	var aborted, skipped, failed, remaining int
+	//cutShort is set when -abort-timeout ran out with uploads left; more when there were pages after them
+	var cutShort, more bool
+	ctx, cancel := j.ctx, context.CancelFunc(func() {})
+	if *abortTimeout > 0 {
+		ctx, cancel = context.WithTimeout(j.ctx, *abortTimeout)
+	}
+	defer cancel()
+	expired := func() bool {
+		return ctx.Err() != nil && !j.timedOut()
+	}
+	cutoff := time.Now().Add(-*uploadsOlderThan)
+	err := j.svc.ListMultipartUploadsPagesWithContext(ctx, &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(j.name),
+	},
+		func(page *s3.ListMultipartUploadsOutput, lastPage bool) bool {
+			for i, upload := range page.Uploads {
+				if expired() {
+					remaining, more, cutShort = len(page.Uploads)-i, !lastPage, true
+					return false
+				}
+				if *uploadsOlderThan > 0 && upload.Initiated != nil && upload.Initiated.After(cutoff) {
+					skipped++
+					if *verbosity {
+						InfoLogger.Printf("Keeping upload of %s started %s\n", aws.StringValue(upload.Key), upload.Initiated.Format(time.RFC3339))
+					}
+					continue
+				}
+				if *dryRun {
+					aborted++
+					if *verbosity {
+						InfoLogger.Printf("Would abort upload of %s: %s\n", aws.StringValue(upload.Key), aws.StringValue(upload.UploadId))
+					}
+					continue
+				}
+				if limiter != nil {
+					limiter.Wait(ctx)
+				}
+				_, err := j.svc.AbortMultipartUploadWithContext(ctx, &s3.AbortMultipartUploadInput{
+					Bucket:   aws.String(j.name),
+					Key:      upload.Key,
+					UploadId: upload.UploadId,
+				})
+				if err != nil {
+					if j.timedOut() || j.stopIfFatal(err) {
+						return false
+					}
+					if expired() {
+						remaining, more, cutShort = len(page.Uploads)-i, !lastPage, true
+						return false
+					}
+					failed++
+					WarningLogger.Printf("Unable to abort upload of %s: %s: %v\n", aws.StringValue(upload.Key), aws.StringValue(upload.UploadId), err)
+					continue
+				}
+				aborted++
+				if *verbosity {
+					InfoLogger.Printf("Aborted upload of %s: %s\n", aws.StringValue(upload.Key), aws.StringValue(upload.UploadId))
+				}
+			}
+			return !lastPage
+		})
+	if j.timedOut() {
+		return false
+	}
+	if err != nil {
+		if !expired() {
+			WarningLogger.Printf("Unable to list multipart uploads in %s: %v\n", j.name, err)
+			return true
+		}
+		//Ran out while a page was being listed, so what is left is unknown
+		cutShort, more = true, true
+	}
+	verb := "Aborted"
+	if *dryRun {
+		verb = "Would abort"
+	}
+	if aborted > 0 || skipped > 0 || failed > 0 {
+		InfoLogger.Printf("%s %d multipart uploads in %s, kept %d newer than %s, %d failed\n", verb, aborted, j.name, skipped, *uploadsOlderThan, failed)
+	}
+	if cutShort {
+		left := fmt.Sprintf("%d", remaining)
+		if more && remaining == 0 {
+			left = "an unknown number"
+		} else if more {
+			left = fmt.Sprintf("at least %d", remaining)
+		}
+		if *onError == "abort" {
+			stopRun(fmt.Errorf("-abort-timeout of %s ran out in %s with %d multipart uploads aborted and %s still to abort, and -on-error=abort", *abortTimeout, j.name, aborted, left))
+			return false
+		}
+		WarningLogger.Printf("-abort-timeout of %s ran out in %s: %d multipart uploads aborted and %s still to abort, emptying the bucket anyway\n", *abortTimeout, j.name, aborted, left)
+	}
+	return true
+}
+
+//listUploads is -list-incomplete-uploads: it prints the bucket's multipart uploads in progress, one per line,
+//and changes nothing. It returns false if the bucket timed out.
+func (j *bucketJob) listUploads() bool {
+	var count int
+	err := j.svc.ListMultipartUploadsPagesWithContext(j.ctx, &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(j.name),
+		Prefix: aws.String(*uploadsPrefix),
+	},
+		func(page *s3.ListMultipartUploadsOutput, lastPage bool) bool {
+			for _, upload := range page.Uploads {
+				count++
+				initiator := ""
+				if upload.Initiator != nil {
+					initiator = aws.StringValue(upload.Initiator.DisplayName)
+					if initiator == "" {
+						initiator = aws.StringValue(upload.Initiator.ID)
+					}
+				}
+				fmt.Printf("%s\t%s\t%s\t%s\t%s\n", j.name, aws.StringValue(upload.Key), aws.StringValue(upload.UploadId),
+					aws.TimeValue(upload.Initiated).Format(time.RFC3339), initiator)
+			}
+			return !lastPage
+		})
+	if j.timedOut() {
+		return false
+	}
+	if err != nil {
+		exitErrorf("Unable to list multipart uploads in %s: %v", j.name, err)
+	}
+	InfoLogger.Printf("%d incomplete multipart uploads in %s\n", count, j.name)
+	return true
+}
@@ -0,0 +1,81 @@
+package deleter
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"net/http"
+	"sync/atomic"
+)
+
+//Entries still there after -verify-deletes deleted them a second time, and ones that couldn't be checked, updated atomically
+var (
+	resistedDeletes   int64
+	unverifiedDeletes int64
+)
+
+//stillPresent asks HeadObject whether a deleted entry is really gone. A 404 means it is. A delete marker's own
+//version answers 405 rather than 200, so that counts as present too.
+func (j *bucketJob) stillPresent(ctx context.Context, input s3.DeleteObjectInput) (bool, error) {
+	_, err := j.svc.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket:    input.Bucket,
+		Key:       input.Key,
+		VersionId: input.VersionId,
+	})
+	switch {
+	case err == nil, statusCode(err) == http.StatusMethodNotAllowed:
+		return true, nil
+	case statusCode(err) == http.StatusNotFound:
+		return false, nil
+	}
+	return false, err
+}
+
+//verifyDeleted is -verify-deletes: it confirms a successful DeleteObject with HeadObject, deletes the entry
+//again if it is still there, and records it as failed if it survives that too.
+func (j *bucketJob) verifyDeleted(ctx context.Context, input s3.DeleteObjectInput, deleteType string, size int64) {
+	key, versionId := aws.StringValue(input.Key), aws.StringValue(input.VersionId)
+	for redeleted := false; ; redeleted = true {
+		present, err := j.stillPresent(ctx, input)
+		if err != nil {
+			if ctx.Err() == nil {
+				atomic.AddInt64(&unverifiedDeletes, 1)
+				WarningLogger.Printf("Unable to verify the delete of %s %s: %s: %v\n", deleteType, key, versionId, err)
+			}
+			return
+		}
+		if !present {
+			return
+		}
+		if redeleted {
+			break
+		}
+		WarningLogger.Printf("%s %s: %s is still there after being deleted, deleting it again\n", deleteType, key, versionId)
+		if _, err := j.svc.DeleteObjectWithContext(ctx, &input); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			ErrorLogger.Printf("Unable to delete %s %s: %s again: %v\n", deleteType, key, versionId, err)
+			break
+		}
+	}
+	//It was counted as deleted when the first DeleteObject succeeded
+	atomic.AddInt64(&deletedCount, -1)
+	atomic.AddInt64(&j.stats.deleted, -1)
+	atomic.AddInt64(j.deletedOfKind(deleteType), -1)
+	atomic.AddInt64(&j.stats.bytesDeleted, -size)
+	recordFreed(-size)
+	atomic.AddInt64(&resistedDeletes, 1)
+	ErrorLogger.Printf("%s %s: %s resisted deletion\n", deleteType, key, versionId)
+	j.recordFailed(s3Entry{Key: input.Key, VersionId: input.VersionId, Size: size, Type: deleteType})
+}
+
+//reportVerifiedDeletes logs what -verify-deletes found across the run
+func reportVerifiedDeletes() {
+	if n := atomic.LoadInt64(&resistedDeletes); n > 0 {
+		ErrorLogger.Printf("%d entries resisted deletion, they are listed above and counted as failed\n", n)
+	}
+	if n := atomic.LoadInt64(&unverifiedDeletes); n > 0 {
+		WarningLogger.Printf("%d deletes couldn't be verified\n", n)
+	}
+}
@@ -0,0 +1,44 @@
+package deleter
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"sync/atomic"
+	"testing"
+)
+
+//resistingS3 is the fake bucket, where HeadObject finds every deleted entry still there
+type resistingS3 struct {
+	*fakeS3
+}
+
+func (f *resistingS3) HeadObjectWithContext(ctx aws.Context, input *s3.HeadObjectInput, opts ...request.Option) (*s3.HeadObjectOutput, error) {
+	return &s3.HeadObjectOutput{}, nil
+}
+
+func TestVerifyDeletedRollsBackResisted(t *testing.T) {
+	setFlags(t, map[string]string{"verify-deletes": "true"})
+	f := newFakeS3("demo", 1)
+	j := newTestJob(t, &resistingS3{fakeS3: f})
+	entry := firstVersion(f)
+	entry.Size = 4096
+	freed, resisted := atomic.LoadInt64(&freedBytes), atomic.LoadInt64(&resistedDeletes)
+
+	j.pool.acquire()
+	if err := j.deleteS3Object(j.ctx, s3.DeleteObjectInput{Bucket: aws.String(j.name), Key: entry.Key, VersionId: entry.VersionId}, entry); err != nil {
+		t.Fatal(err)
+	}
+	if j.stats.deleted != 0 || j.stats.bytesDeleted != 0 {
+		t.Errorf("%d deleted and %d bytes left counted, want both taken back", j.stats.deleted, j.stats.bytesDeleted)
+	}
+	if got := atomic.LoadInt64(&freedBytes) - freed; got != 0 {
+		t.Errorf("%d bytes counted as freed, want 0", got)
+	}
+	if got := atomic.LoadInt64(&resistedDeletes) - resisted; got != 1 {
+		t.Errorf("%d resisted deletes counted, want 1", got)
+	}
+	if len(j.failed) != 1 {
+		t.Errorf("%d deletes recorded as failed, want 1", len(j.failed))
+	}
+}
@@ -0,0 +1,135 @@
+package deleter
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+//stringsFlag is a flag that can be given more than once, collecting every value
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+//Version IDs given with -version-id, for -key
+var versionIds stringsFlag
+
+//deleteKeyVersions deletes exactly the -version-id versions of -key and nothing else, leaving the bucket in place.
+//The key's versions are listed first so IDs that don't exist are reported rather than sent as deletes.
+//It returns false if the bucket timed out.
+func (j *bucketJob) deleteKeyVersions() bool {
+	wanted := make(map[string]bool, len(versionIds))
+	for _, id := range versionIds {
+		wanted[id] = true
+	}
+
+	var found []s3Entry
+	err := j.svc.ListObjectVersionsPagesWithContext(j.ctx, &s3.ListObjectVersionsInput{
+		Bucket: aws.String(j.name),
+		Prefix: aws.String(*objectKey),
+	},
+		func(page *s3.ListObjectVersionsOutput, lastPage bool) bool {
+			listed := append(markerEntries(page.DeleteMarkers), versionEntries(page.Versions)...)
+			for _, entry := range listed {
+				id := aws.StringValue(entry.VersionId)
+				if aws.StringValue(entry.Key) == *objectKey && wanted[id] {
+					found = append(found, entry)
+					delete(wanted, id)
+				}
+			}
+			return !lastPage && len(wanted) > 0
+		})
+	if j.timedOut() {
+		return false
+	}
+	if err != nil {
+		exitErrorf("Unable to list versions of %s in %s: %v", *objectKey, j.name, err)
+	}
+	for id := range wanted {
+		WarningLogger.Printf("Version %s of %s not found in %s\n", id, *objectKey, j.name)
+	}
+
+	if *dryRun {
+		j.planEntries(found)
+		InfoLogger.Printf("Would delete %d versions of %s\n", atomic.LoadInt64(&j.stats.planned), *objectKey)
+	} else {
+		InfoLogger.Printf("Deleting %d versions of %s\n", len(found), *objectKey)
+		err := j.dispatchEntries(found).Wait()
+		if j.timedOut() {
+			return false
+		}
+		if err != nil {
+			exitErrorf("Aborting %s: %v", j.name, err)
+		}
+	}
+	if len(wanted) > 0 {
+		recordBucketFailure(j.name, fmt.Errorf("%d of %d version IDs of %s not found", len(wanted), len(versionIds), *objectKey))
+	}
+	return true
+}
+
+//keepNewestVersions deletes every version of each key except its -keep-versions newest (by LastModified),
+//along with every delete marker, leaving the bucket in place. A key's versions can run over into the next page,
+//so they are held until the listing has moved past the key: memory is one page plus the versions of the key
+//still being listed, however many versions that key has. It returns why it stopped early, if it did.
+func (j *bucketJob) keepNewestVersions(listPrefix *string) error {
+	pending := map[string][]s3Entry{}
+	var fatalErr error
+	err := j.listVersionPages(&s3.ListObjectVersionsInput{
+		Bucket:  aws.String(j.name),
+		Prefix:  listPrefix,
+		MaxKeys: aws.Int64(listPageSize),
+	},
+		func(page *s3.ListObjectVersionsOutput, lastPage bool) bool {
+			for _, entry := range versionEntries(page.Versions) {
+				key := aws.StringValue(entry.Key)
+				pending[key] = append(pending[key], entry)
+			}
+			//The key the next page starts at may have more versions there, everything else is complete
+			unfinished := ""
+			if !lastPage {
+				unfinished = aws.StringValue(page.NextKeyMarker)
+			}
+			expired := markerEntries(page.DeleteMarkers)
+			for key, versions := range pending {
+				if key == unfinished {
+					continue
+				}
+				expired = append(expired, surplusVersions(versions)...)
+				delete(pending, key)
+			}
+			fatalErr = j.deleteVersionEntries(expired).Wait()
+			return fatalErr == nil && !lastPage
+		})
+	if j.timedOut() {
+		return j.ctx.Err()
+	}
+	if fatalErr != nil {
+		return fatalErr
+	}
+	if err != nil {
+		return fmt.Errorf("unable to list versions of %s: %w", j.name, bucketError(j.name, err))
+	}
+	return nil
+}
+
+//surplusVersions returns the versions of one key beyond the -keep-versions newest
+func surplusVersions(versions []s3Entry) []s3Entry {
+	if len(versions) <= *keepVersions {
+		return nil
+	}
+	sort.SliceStable(versions, func(a, b int) bool {
+		return aws.TimeValue(versions[a].LastModified).After(aws.TimeValue(versions[b].LastModified))
+	})
+	return versions[*keepVersions:]
+}
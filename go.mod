@@ -3,6 +3,8 @@ module github.com/cgkades/deleteS3bucket
 go 1.15
 
 require (
-	github.com/aws/aws-sdk-go v1.36.15
+	github.com/aws/aws-sdk-go v1.46.7
 	github.com/cenkalti/backoff/v4 v4.1.0
+	golang.org/x/sync v0.0.0-20220722155255-886fb9371eb4
+	golang.org/x/time v0.0.0-20201208040808-7e3f01d25324
 )